@@ -0,0 +1,352 @@
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ModeRecord Mode = "record" // send the requests and save the interactions, overwriting the cassette
+	ModeReplay Mode = "replay" // answer the requests from the cassette, nothing is sent
+	ModeAuto   Mode = "auto"   // replay when the cassette exists, record otherwise
+
+	MatchMethod MatchField = "method"
+	MatchPath   MatchField = "path" // the scheme, host and path of the url
+	MatchQuery  MatchField = "query"
+	MatchBody   MatchField = "body" // JSON bodies match when they're equal regardless of formatting and key order
+
+	Redacted = "[REDACTED]"
+)
+
+var (
+	// DefaultMatch is used when the options don't set the fields to match on.
+	DefaultMatch = []MatchField{MatchMethod, MatchPath, MatchQuery}
+
+	// defaultScrubHeaders are scrubbed on top of the headers of the options.
+	defaultScrubHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+)
+
+type (
+	Mode       string
+	MatchField string
+
+	// Options configure how interactions are recorded and matched.
+	Options struct {
+		Mode         Mode
+		Match        []MatchField
+		ScrubHeaders []string          // headers whose values are replaced, like the plugin's PluginMetadata.SecretHeaders
+		ScrubValues  []string          // secrets that are replaced anywhere in the url, headers and bodies, like connection tokens
+		Transport    http.RoundTripper // sends the requests in record mode, defaults to http.DefaultTransport
+	}
+
+	Cassette struct {
+		Interactions []Interaction `yaml:"interactions"`
+	}
+
+	Interaction struct {
+		Request  Request  `yaml:"request"`
+		Response Response `yaml:"response"`
+	}
+
+	Request struct {
+		Method  string      `yaml:"method"`
+		URL     string      `yaml:"url"`
+		Headers http.Header `yaml:"headers,omitempty"`
+		Body    string      `yaml:"body,omitempty"`
+	}
+
+	Response struct {
+		StatusCode int         `yaml:"status_code"`
+		Headers    http.Header `yaml:"headers,omitempty"`
+		Body       string      `yaml:"body,omitempty"`
+	}
+
+	// Recorder is an http.RoundTripper that records interactions into a YAML cassette, or replays them.
+	// it is safe for concurrent use.
+	Recorder struct {
+		path     string
+		options  Options
+		mode     Mode
+		cassette Cassette
+		replayed []bool // the interactions that were already replayed, so repeated requests get the following responses
+		mutex    sync.Mutex
+	}
+
+	// wrappedRecorder sends the recorded requests with its own transport, and shares the cassette of its recorder.
+	wrappedRecorder struct {
+		recorder  *Recorder
+		transport http.RoundTripper
+	}
+)
+
+// New creates a recorder of the cassette file, in replay mode the cassette is loaded.
+func New(path string, options Options) (*Recorder, error) {
+	recorder := &Recorder{path: path, options: options, mode: options.Mode}
+	if len(recorder.options.Match) == 0 {
+		recorder.options.Match = DefaultMatch
+	}
+	if recorder.options.Transport == nil {
+		recorder.options.Transport = http.DefaultTransport
+	}
+
+	if recorder.mode == "" || recorder.mode == ModeAuto {
+		recorder.mode = ModeReplay
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			recorder.mode = ModeRecord
+		}
+	}
+
+	if recorder.mode == ModeRecord {
+		return recorder, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read cassette %s", path)
+	}
+
+	if err = yaml.Unmarshal(content, &recorder.cassette); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse cassette %s", path)
+	}
+	recorder.replayed = make([]bool, len(recorder.cassette.Interactions))
+
+	return recorder, nil
+}
+
+// Mode returns whether the recorder records or replays.
+func (r *Recorder) Mode() Mode {
+	return r.mode
+}
+
+// Wrap returns a round tripper that sends the recorded requests with the transport, so it can be used as a TransportConfig.Middleware.
+// every wrapped transport records into the same cassette, the transport of the options isn't changed.
+func (r *Recorder) Wrap(transport http.RoundTripper) http.RoundTripper {
+	return &wrappedRecorder{recorder: r, transport: transport}
+}
+
+func (w *wrappedRecorder) RoundTrip(request *http.Request) (*http.Response, error) {
+	return w.recorder.roundTrip(request, w.transport)
+}
+
+func (r *Recorder) RoundTrip(request *http.Request) (*http.Response, error) {
+	return r.roundTrip(request, r.options.Transport)
+}
+
+func (r *Recorder) roundTrip(request *http.Request, transport http.RoundTripper) (*http.Response, error) {
+	body, err := readBody(request)
+	if err != nil {
+		return nil, err
+	}
+
+	recordedRequest := Request{
+		Method:  request.Method,
+		URL:     r.scrub(request.URL.String()),
+		Headers: r.scrubHeaders(request.Header),
+		Body:    r.scrub(string(body)),
+	}
+
+	if r.mode == ModeReplay {
+		return r.replay(request, recordedRequest)
+	}
+
+	// the body was consumed, a round tripper mustn't modify the request so a copy is sent.
+	outgoingRequest := request.Clone(request.Context())
+	if body != nil {
+		outgoingRequest.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	return r.record(transport, outgoingRequest, recordedRequest)
+}
+
+func (r *Recorder) replay(request *http.Request, recordedRequest Request) (*http.Response, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, interaction := range r.cassette.Interactions {
+		if r.replayed[i] || !r.matches(interaction.Request, recordedRequest) {
+			continue
+		}
+
+		r.replayed[i] = true
+		return interaction.Response.toHTTPResponse(request), nil
+	}
+
+	return nil, errors.Errorf("cassette %s has no interaction for %s %s", r.path, recordedRequest.Method, recordedRequest.URL)
+}
+
+func (r *Recorder) record(transport http.RoundTripper, request *http.Request, recordedRequest Request) (*http.Response, error) {
+	response, err := transport.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	_ = response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Request: recordedRequest,
+		Response: Response{
+			StatusCode: response.StatusCode,
+			Headers:    r.scrubHeaders(response.Header),
+			Body:       r.scrub(string(body)),
+		},
+	})
+
+	// the cassette is saved after every interaction, so there's nothing to flush when the test ends.
+	if err = r.save(); err != nil {
+		return nil, errors.Wrapf(err, "failed to save cassette %s", r.path)
+	}
+
+	response.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return response, nil
+}
+
+func (r *Recorder) save() error {
+	content, err := yaml.Marshal(r.cassette)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(r.path, content, 0644)
+}
+
+// readBody reads and closes the body of the request, as round trippers must.
+func readBody(request *http.Request) ([]byte, error) {
+	if request.Body == nil || request.Body == http.NoBody {
+		return nil, nil
+	}
+	defer request.Body.Close()
+
+	return ioutil.ReadAll(request.Body)
+}
+
+func (r *Recorder) matches(recorded Request, request Request) bool {
+	recordedURL, recordedQuery := splitURL(recorded.URL)
+	requestURL, requestQuery := splitURL(request.URL)
+
+	for _, field := range r.options.Match {
+		switch field {
+		case MatchMethod:
+			if !strings.EqualFold(recorded.Method, request.Method) {
+				return false
+			}
+		case MatchPath:
+			if recordedURL != requestURL {
+				return false
+			}
+		case MatchQuery:
+			if recordedQuery != requestQuery {
+				return false
+			}
+		case MatchBody:
+			if !bodiesMatch(recorded.Body, request.Body) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (r *Recorder) scrub(value string) string {
+	for _, secret := range r.options.ScrubValues {
+		if secret != "" {
+			value = strings.ReplaceAll(value, secret, Redacted)
+		}
+	}
+
+	return value
+}
+
+func (r *Recorder) scrubHeaders(header http.Header) http.Header {
+	scrubbed := make(http.Header, len(header))
+	for name, values := range header {
+		scrubbedValues := make([]string, len(values))
+		for i, value := range values {
+			if r.isScrubbedHeader(name) {
+				scrubbedValues[i] = Redacted
+			} else {
+				scrubbedValues[i] = r.scrub(value)
+			}
+		}
+		scrubbed[name] = scrubbedValues
+	}
+
+	return scrubbed
+}
+
+func (r *Recorder) isScrubbedHeader(name string) bool {
+	for _, scrubbedHeader := range append(defaultScrubHeaders, r.options.ScrubHeaders...) {
+		if strings.EqualFold(name, scrubbedHeader) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r Response) toHTTPResponse(request *http.Request) *http.Response {
+	header := r.Headers.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode)),
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       request,
+	}
+}
+
+// splitURL returns the url without the query, and the query with its params sorted.
+func splitURL(rawURL string) (string, string) {
+	index := strings.Index(rawURL, "?")
+	if index < 0 {
+		return rawURL, ""
+	}
+
+	params := strings.Split(rawURL[index+1:], "&")
+	sort.Strings(params)
+	return rawURL[:index], strings.Join(params, "&")
+}
+
+func bodiesMatch(recorded string, body string) bool {
+	if recorded == body {
+		return true
+	}
+
+	var recordedJSON, bodyJSON interface{}
+	if json.Unmarshal([]byte(recorded), &recordedJSON) != nil || json.Unmarshal([]byte(body), &bodyJSON) != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(recordedJSON, bodyJSON)
+}
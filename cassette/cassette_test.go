@@ -0,0 +1,202 @@
+package cassette
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type CassetteTestSuite struct {
+	suite.Suite
+	server   *httptest.Server
+	requests int32
+	path     string
+}
+
+func (suite *CassetteTestSuite) SetupTest() {
+	suite.requests = 0
+	suite.server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		count := atomic.AddInt32(&suite.requests, 1)
+		body, _ := ioutil.ReadAll(req.Body)
+
+		res.Header().Set("Content-Type", "application/json")
+		res.Header().Set("Set-Cookie", "session=secret-session")
+		if count > 1 {
+			_, _ = res.Write([]byte(`{"page": 2}`))
+			return
+		}
+		_, _ = res.Write([]byte(`{"page": 1, "echo": "` + string(body) + `"}`))
+	}))
+	suite.path = filepath.Join(suite.T().TempDir(), "cassettes", "users.yaml")
+}
+
+func (suite *CassetteTestSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+func (suite *CassetteTestSuite) send(recorder http.RoundTripper, method string, url string, body string) (*http.Response, string, error) {
+	request, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(suite.T(), err)
+	request.Header.Set("Authorization", "Bearer secret-token")
+	request.Header.Set("X-Api-Token", "secret-token")
+
+	response, err := (&http.Client{Transport: recorder}).Do(request)
+	if err != nil {
+		return nil, "", err
+	}
+	defer response.Body.Close()
+
+	responseBody, err := ioutil.ReadAll(response.Body)
+	require.NoError(suite.T(), err)
+	return response, string(responseBody), nil
+}
+
+func (suite *CassetteTestSuite) record() {
+	recorder, err := New(suite.path, Options{Mode: ModeRecord, ScrubHeaders: []string{"X-Api-Token"}, ScrubValues: []string{"secret-key"}})
+	require.NoError(suite.T(), err)
+
+	_, body, err := suite.send(recorder, http.MethodPost, suite.server.URL+"/users?b=2&a=1&key=secret-key", "jane")
+	require.NoError(suite.T(), err)
+	assert.JSONEq(suite.T(), `{"page": 1, "echo": "jane"}`, body)
+
+	_, _, err = suite.send(recorder, http.MethodPost, suite.server.URL+"/users?b=2&a=1&key=secret-key", "jane")
+	require.NoError(suite.T(), err)
+}
+
+func (suite *CassetteTestSuite) TestRecordScrubsSecrets() {
+	suite.record()
+
+	content, err := ioutil.ReadFile(suite.path)
+	require.NoError(suite.T(), err)
+	assert.NotContains(suite.T(), string(content), "secret-token")
+	assert.NotContains(suite.T(), string(content), "secret-key")
+	assert.NotContains(suite.T(), string(content), "secret-session")
+	assert.Contains(suite.T(), string(content), Redacted)
+}
+
+func (suite *CassetteTestSuite) TestReplay() {
+	suite.record()
+	suite.server.Close()
+
+	recorder, err := New(suite.path, Options{ScrubValues: []string{"secret-key"}})
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), ModeReplay, recorder.Mode())
+
+	// the query params order doesn't matter, repeated requests get the recorded responses in order.
+	url := suite.server.URL + "/users?a=1&b=2&key=secret-key"
+	response, body, err := suite.send(recorder, http.MethodPost, url, "jane")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), http.StatusOK, response.StatusCode)
+	assert.Equal(suite.T(), "200 OK", response.Status)
+	assert.Equal(suite.T(), "application/json", response.Header.Get("Content-Type"))
+	assert.JSONEq(suite.T(), `{"page": 1, "echo": "jane"}`, body)
+
+	_, body, err = suite.send(recorder, http.MethodPost, url, "jane")
+	require.NoError(suite.T(), err)
+	assert.JSONEq(suite.T(), `{"page": 2}`, body)
+
+	_, _, err = suite.send(recorder, http.MethodPost, url, "jane")
+	assert.Error(suite.T(), err)
+
+	_, _, err = suite.send(recorder, http.MethodGet, url, "")
+	assert.Error(suite.T(), err)
+}
+
+func (suite *CassetteTestSuite) TestSaveFailure() {
+	// the cassette's directory is a file, so the interaction can't be saved.
+	dir := filepath.Join(suite.T().TempDir(), "cassettes")
+	require.NoError(suite.T(), ioutil.WriteFile(dir, nil, 0600))
+	recorder, err := New(filepath.Join(dir, "users.yaml"), Options{Mode: ModeRecord})
+	require.NoError(suite.T(), err)
+
+	response, _, err := suite.send(recorder, http.MethodGet, suite.server.URL+"/users", "")
+	assert.Nil(suite.T(), response)
+	require.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "failed to save cassette")
+}
+
+func (suite *CassetteTestSuite) TestMatchBody() {
+	suite.record()
+
+	recorder, err := New(suite.path, Options{Mode: ModeReplay, Match: []MatchField{MatchMethod, MatchBody}})
+	require.NoError(suite.T(), err)
+
+	_, _, err = suite.send(recorder, http.MethodPost, "https://example.com/other", "john")
+	assert.Error(suite.T(), err)
+
+	_, body, err := suite.send(recorder, http.MethodPost, "https://example.com/other", "jane")
+	require.NoError(suite.T(), err)
+	assert.JSONEq(suite.T(), `{"page": 1, "echo": "jane"}`, body)
+
+	assert.True(suite.T(), bodiesMatch(`{"a": 1, "b": [1, 2]}`, `{"b":[1,2],"a":1}`))
+	assert.False(suite.T(), bodiesMatch(`{"a": 1}`, `{"a": 2}`))
+}
+
+func (suite *CassetteTestSuite) TestAutoMode() {
+	recorder, err := New(suite.path, Options{Mode: ModeAuto})
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), ModeRecord, recorder.Mode())
+
+	_, _, err = suite.send(recorder, http.MethodGet, suite.server.URL+"/users", "")
+	require.NoError(suite.T(), err)
+
+	recorder, err = New(suite.path, Options{Mode: ModeAuto})
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), ModeReplay, recorder.Mode())
+
+	_, err = New(filepath.Join(suite.T().TempDir(), "missing.yaml"), Options{Mode: ModeReplay})
+	assert.Error(suite.T(), err)
+}
+
+// countingTransport counts the requests it sends.
+type countingTransport struct {
+	requests int32
+}
+
+func (t *countingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&t.requests, 1)
+	return http.DefaultTransport.RoundTrip(request)
+}
+
+func (suite *CassetteTestSuite) TestWrapTransports() {
+	recorder, err := New(suite.path, Options{Mode: ModeRecord})
+	require.NoError(suite.T(), err)
+
+	// each wrapped transport sends its own requests, all of them are recorded into the same cassette.
+	transports := []*countingTransport{{}, {}}
+	var wg sync.WaitGroup
+	for _, transport := range transports {
+		wrapped := recorder.Wrap(transport)
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _, err := suite.send(wrapped, http.MethodGet, suite.server.URL+"/users", "")
+				assert.NoError(suite.T(), err)
+			}()
+		}
+	}
+	wg.Wait()
+
+	for _, transport := range transports {
+		assert.Equal(suite.T(), int32(5), atomic.LoadInt32(&transport.requests))
+	}
+	assert.Equal(suite.T(), http.DefaultTransport, recorder.options.Transport)
+
+	recorder, err = New(suite.path, Options{Mode: ModeReplay})
+	require.NoError(suite.T(), err)
+	assert.Len(suite.T(), recorder.cassette.Interactions, 10)
+}
+
+func TestCassetteSuite(t *testing.T) {
+	suite.Run(t, new(CassetteTestSuite))
+}
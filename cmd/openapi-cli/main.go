@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	gen "github.com/blinkops/blink-openapi-sdk/generate"
+	"github.com/blinkops/blink-openapi-sdk/mock"
 	"os"
 	"regexp"
 	"strings"
@@ -170,6 +171,44 @@ func main() {
 					},
 				},
 			},
+			{
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "file",
+						Aliases: []string{"f"},
+						Value:   OpenAPIFile,
+						Usage:   "openApi file name",
+					},
+					&cli.StringFlag{
+						Name:        "mask",
+						Aliases:     []string{"m"},
+						Value:       "",
+						Usage:       "mask file, only the masked actions are served",
+						DefaultText: "",
+					},
+					&cli.StringFlag{
+						Name:    "overrides",
+						Aliases: []string{"o"},
+						Usage:   "yaml file of scripted responses (status, latency, headers, body) by operation id",
+					},
+					&cli.StringFlag{
+						Name:        "host",
+						Value:       "localhost",
+						Usage:       "the address to listen on",
+						DefaultText: "localhost",
+					},
+					&cli.IntFlag{
+						Name:        "port",
+						Aliases:     []string{"p"},
+						Value:       8080,
+						Usage:       "the port to listen on",
+						DefaultText: "8080",
+					},
+				},
+				Name:   "mock",
+				Usage:  "serve a mock API of the openapi file, the plugin's REQUEST_URL can point to it",
+				Action: mock.Serve,
+			},
 		},
 	}
 
@@ -8,21 +8,64 @@ const (
 	TypeObject   = "object"
 	TypeJson     = "code:json"
 	TypeDropdown = "dropdown"
+	TypePassword = "password"
 
 	BodyParamDelimiter = "."
 	RequestBodyType    = "application/json"
 	URLEncoded         = "application/x-www-form-urlencoded"
+	MultipartFormData  = "multipart/form-data"
+	OctetStream        = "application/octet-stream"
+	FormatBinary       = "binary"
+	RawBodyParam       = "body"    // the param of bodies that are sent as is, like application/octet-stream
+	FileReference      = "file://" // prefix of binary params that reference a local file instead of base64 content
 	ParamPrefix        = "{"
 	ParamSuffix        = "}"
 	RequestUrlKey      = "REQUEST_URL"
+	DryRunKey          = "DRY_RUN" // reserved request param and action context entry that returns the request instead of sending it
 	ArrayDelimiter     = ","
 	ContentTypeHeader  = "Content-Type"
 
+	// reserved connection keys that configure the transport of the connection's requests, they are never sent as headers.
+	ProxyUrlKey           = "PROXY_URL" // http(s)://user:password@proxy:port
+	NoProxyKey            = "NO_PROXY"  // comma separated hosts, domains, IPs and CIDRs that bypass the proxy
+	CABundleKey           = "CA_BUNDLE" // PEM certificates trusted in addition to the system ones
+	ClientCertKey         = "CLIENT_CERT"
+	ClientKeyKey          = "CLIENT_KEY"
+	InsecureSkipVerifyKey = "INSECURE_SKIP_VERIFY"
+
+	// connection keys of the OAuth2 client credentials and refresh token flows.
+	TokenUrlKey     = "TOKEN_URL"
+	ClientIdKey     = "CLIENT_ID"
+	ClientSecretKey = "CLIENT_SECRET"
+	RefreshTokenKey = "REFRESH_TOKEN"
+	ScopesKey       = "SCOPES" // space or comma separated
+
+	// connection keys of AWS Signature Version 4 signing.
+	AwsAccessKeyIdKey     = "AWS_ACCESS_KEY_ID"
+	AwsSecretAccessKeyKey = "AWS_SECRET_ACCESS_KEY"
+	AwsSessionTokenKey    = "AWS_SESSION_TOKEN"
+	AwsRegionKey          = "AWS_REGION"
+	AwsServiceKey         = "AWS_SERVICE" // the signing name of the service, like s3, es or execute-api
+
+	// connection keys of JWT bearer assertions, the private key can also be a Google service account key file.
+	JwtPrivateKeyKey = "JWT_PRIVATE_KEY"
+	JwtKeyIdKey      = "JWT_KEY_ID"
+	JwtIssuerKey     = "JWT_ISSUER"
+	JwtSubjectKey    = "JWT_SUBJECT"
+	JwtAudienceKey   = "JWT_AUDIENCE"
+	JwtScopesKey     = "JWT_SCOPES"    // space or comma separated
+	JwtTokenUrlKey   = "JWT_TOKEN_URL" // the JWT is sent as the bearer token when it's empty
+	JwtLifetimeKey   = "JWT_LIFETIME"  // seconds, more than a minute, 5 minutes by default
+
 	BearerAuth        = "Bearer "
 	BasicAuth         = "Basic "
 	BasicAuthUsername = "USERNAME"
 	BasicAuthPassword = "PASSWORD"
 
+	// reserved connection key of the auth scheme of USERNAME and PASSWORD, they are sent with Basic auth by default.
+	AuthTypeKey    = "AUTH_TYPE"
+	AuthTypeDigest = "digest"
+
 	ParamPlaceholderPrefix = "Example: "
 	Error                  = 1
 	OK                     = 0
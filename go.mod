@@ -13,5 +13,6 @@ require (
 	github.com/stretchr/testify v1.5.1
 	github.com/urfave/cli/v2 v2.3.0
 	golang.org/x/sys v0.0.0-20210421221651-33663a62ff08 // indirect
+	golang.org/x/text v0.3.3
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
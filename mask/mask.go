@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/blinkops/blink-openapi-sdk/consts"
 	"github.com/blinkops/blink-openapi-sdk/zip"
@@ -13,6 +14,14 @@ import (
 
 const (
 	FormatDelimiter = "_"
+
+	PaginationLink   = "link"   // follow the rel="next" url of the Link header
+	PaginationCursor = "cursor" // send the cursor found in the response body as a query param
+	PaginationOffset = "offset" // advance an offset query param by the number of fetched items
+	PaginationPage   = "page"   // advance a page number query param by one
+
+	ResponseSizeFail     = "fail"     // fail the action when the response is larger than the max response size
+	ResponseSizeTruncate = "truncate" // return the response cut at the max response size
 )
 
 var FormatPrefixes = [...]string{"date"}
@@ -20,14 +29,21 @@ var FormatPrefixes = [...]string{"date"}
 type (
 	Mask struct {
 		Actions                  map[string]*MaskedAction `yaml:"actions,omitempty"`
+		Session                  *Session                 `yaml:"session,omitempty"`
 		ReverseActionAliasMap    map[string]string
 		ReverseParameterAliasMap map[string]map[string]string
 	}
 	MaskedAction struct {
-		Alias       string                            `yaml:"alias,omitempty"`
-		DisplayName string                            `yaml:"display_name"`
-		Description string                            `yaml:"description,omitempty"`
-		Parameters  map[string]*MaskedActionParameter `yaml:"parameters,omitempty"`
+		Alias              string                            `yaml:"alias,omitempty"`
+		DisplayName        string                            `yaml:"display_name"`
+		Description        string                            `yaml:"description,omitempty"`
+		Parameters         map[string]*MaskedActionParameter `yaml:"parameters,omitempty"`
+		Pagination         *Pagination                       `yaml:"pagination,omitempty"`
+		Retry              *Retry                            `yaml:"retry,omitempty"`
+		RateLimitWeight    int                               `yaml:"rate_limit_weight,omitempty"`    // rate limit tokens a request consumes, defaults to 1
+		ResponseValidation string                            `yaml:"response_validation,omitempty"`  // off/warn/strict, overrides the plugin's response validation
+		MaxResponseSize    int64                             `yaml:"max_response_size,omitempty"`    // max response body size in bytes, overrides the plugin's limit
+		ResponseSizePolicy string                            `yaml:"response_size_policy,omitempty"` // fail/truncate, overrides the plugin's policy
 	}
 	MaskedActionParameter struct {
 		Alias       string `yaml:"alias,omitempty"`
@@ -38,6 +54,35 @@ type (
 		Default     string `yaml:"default,omitempty"`  // override parameter default value
 		Description string `yaml:"description,omitempty"`
 	}
+	Pagination struct {
+		Type        string `yaml:"type"`                   // link/cursor/offset/page
+		ResultsPath string `yaml:"results_path,omitempty"` // "." delimited path of the results array in the body, empty when the body is the array
+		CursorPath  string `yaml:"cursor_path,omitempty"`  // "." delimited path of the next cursor in the body
+		CursorParam string `yaml:"cursor_param,omitempty"` // query param that receives the cursor
+		OffsetParam string `yaml:"offset_param,omitempty"` // query param that receives the offset
+		PageParam   string `yaml:"page_param,omitempty"`   // query param that receives the page number
+		LimitParam  string `yaml:"limit_param,omitempty"`  // query param that receives the page size
+		PageSize    int    `yaml:"page_size,omitempty"`
+		StartPage   int    `yaml:"start_page,omitempty"` // first page number, defaults to 1
+		MaxPages    int    `yaml:"max_pages,omitempty"`  // 1000 by default
+		MaxItems    int    `yaml:"max_items,omitempty"`
+	}
+	Session struct {
+		LoginAction        string            `yaml:"login_action"`                   // the operation id of the login operation
+		Parameters         map[string]string `yaml:"parameters,omitempty"`           // the login params and the connection keys of their values
+		SessionCookie      string            `yaml:"session_cookie,omitempty"`       // the cookie the login must set
+		TokenHeader        string            `yaml:"token_header,omitempty"`         // the login response header with a token, like X-CSRF-Token
+		TokenCookie        string            `yaml:"token_cookie,omitempty"`         // the login response cookie with a token, like XSRF-TOKEN
+		TokenRequestHeader string            `yaml:"token_request_header,omitempty"` // the request header of the token, defaults to token_header
+	}
+	Retry struct {
+		MaxAttempts     int           `yaml:"max_attempts,omitempty"`
+		InitialBackoff  time.Duration `yaml:"initial_backoff,omitempty"` // 500ms/2s
+		MaxBackoff      time.Duration `yaml:"max_backoff,omitempty"`
+		MaxServerWait   time.Duration `yaml:"max_server_wait,omitempty"`   // max wait for Retry-After hints, 2m by default
+		RetryAllMethods bool          `yaml:"retry_all_methods,omitempty"` // also retry non idempotent methods like POST
+		StatusCodes     []int         `yaml:"status_codes,omitempty"`
+	}
 )
 
 // ParseMask receives a mask file, parses it and returns a new mask object.
@@ -82,7 +127,7 @@ func (m *Mask) GetAction(actionName string) *MaskedAction {
 
 func (m *Mask) GetParameter(actionName string, paramName string) *MaskedActionParameter {
 	originalActionName := m.ReplaceActionAlias(actionName)
-	originalParamName := m.replaceActionParameterAlias(actionName, paramName)
+	originalParamName := m.ReplaceActionParameterAlias(actionName, paramName)
 
 	if action, ok := m.Actions[originalActionName]; ok {
 		if param, ok := action.Parameters[originalParamName]; ok {
@@ -106,7 +151,7 @@ func (m *Mask) ReplaceActionParametersAliases(originalActionName string, rawPara
 	requestParameters := map[string]string{}
 
 	for paramName, paramValue := range rawParameters {
-		originalName := m.replaceActionParameterAlias(originalActionName, paramName)
+		originalName := m.ReplaceActionParameterAlias(originalActionName, paramName)
 		requestParameters[originalName] = paramValue
 	}
 
@@ -143,7 +188,7 @@ func (m *Mask) buildParamAliasMap() {
 	m.ReverseParameterAliasMap = reverseParameterAliasMap
 }
 
-func (m *Mask) replaceActionParameterAlias(actionName string, paramName string) string {
+func (m *Mask) ReplaceActionParameterAlias(actionName string, paramName string) string {
 	if actionParams, ok := m.ReverseParameterAliasMap[actionName]; ok {
 		if originalName, ok := actionParams[paramName]; ok {
 			return originalName
@@ -29,7 +29,7 @@ func (suite *MaskTestSuite) TestBuildActionAliasMap() {
 func (suite *MaskTestSuite) TestBuildParamAliasMap() {
 	suite.Mask.buildParamAliasMap()
 	reverseParameterAliasMap := suite.Mask.ReverseParameterAliasMap
-	assert.Equal(suite.T(), len(reverseParameterAliasMap), 5)
+	assert.Equal(suite.T(), len(reverseParameterAliasMap), 6)
 	assert.Contains(suite.T(), reverseParameterAliasMap, "AddTeamMember")
 	assert.Contains(suite.T(), reverseParameterAliasMap["AddTeamMember"], "Team ID")
 	assert.Contains(suite.T(), reverseParameterAliasMap["AddTeamMember"], "User ID")
@@ -53,6 +53,25 @@ func (suite *MaskTestSuite) TestGetParameter() {
 	assert.Equal(suite.T(), actionParameter.Alias, "Folder Name")
 }
 
+func (suite *MaskTestSuite) TestPagination() {
+	pagination := suite.Mask.GetAction("SearchDashboards").Pagination
+	assert.NotNil(suite.T(), pagination)
+	assert.Equal(suite.T(), PaginationPage, pagination.Type)
+	assert.Equal(suite.T(), "results", pagination.ResultsPath)
+	assert.Equal(suite.T(), 50, pagination.PageSize)
+	assert.Equal(suite.T(), 3, pagination.MaxPages)
+	assert.Nil(suite.T(), suite.Mask.GetAction("CreateFolder").Pagination)
+}
+
+func (suite *MaskTestSuite) TestSession() {
+	session := suite.Mask.Session
+	assert.NotNil(suite.T(), session)
+	assert.Equal(suite.T(), "Login", session.LoginAction)
+	assert.Equal(suite.T(), map[string]string{"user": "USERNAME", "password": "PASSWORD"}, session.Parameters)
+	assert.Equal(suite.T(), "X-CSRF-Token", session.TokenHeader)
+	assert.Empty(suite.T(), session.TokenRequestHeader)
+}
+
 // In order for 'go test' to run this suite, we need to create
 // a normal test function and pass our suite to suite.Run
 func TestMaskSuite(t *testing.T) {
@@ -0,0 +1,31 @@
+package mock
+
+import (
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// Serve runs the mock server of the openapi-cli mock command.
+func Serve(c *cli.Context) error {
+	options := Options{OpenApiFile: c.String("file"), MaskFile: c.String("mask")}
+
+	if overridesFile := c.String("overrides"); overridesFile != "" {
+		overrides, err := LoadOverrides(overridesFile)
+		if err != nil {
+			return err
+		}
+		options.Overrides = overrides
+	}
+
+	server, err := NewServer(options)
+	if err != nil {
+		return err
+	}
+
+	address := fmt.Sprintf("%s:%d", c.String("host"), c.Int("port"))
+	log.Infof("Serving %d operations of %s on http://%s", len(server.routes), options.OpenApiFile, address)
+	return http.ListenAndServe(address, server)
+}
@@ -0,0 +1,314 @@
+package mock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	"github.com/blinkops/blink-openapi-sdk/mask"
+	"github.com/blinkops/blink-openapi-sdk/plugin"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	// Options configure the mock server.
+	Options struct {
+		OpenApiFile string
+		MaskFile    string                // only the masked operations are served, all of them when there's no mask
+		Overrides   map[string][]Override // scripted responses by operation id or action alias
+	}
+
+	// Override replaces the generated response of an operation.
+	// the overrides of an operation are a script, each one answers Times requests before the next one is used,
+	// the last override answers all the remaining requests.
+	Override struct {
+		Status  int               `yaml:"status,omitempty"`  // defaults to the first success status of the operation
+		Latency time.Duration     `yaml:"latency,omitempty"` // 500ms/2s
+		Headers map[string]string `yaml:"headers,omitempty"`
+		Body    interface{}       `yaml:"body,omitempty"` // strings are sent as is, other values as JSON
+		Times   int               `yaml:"times,omitempty"`
+	}
+
+	// Server serves the operations of an openapi spec with their examples, or payloads generated from their schemas.
+	// it is safe for concurrent use.
+	Server struct {
+		spec      *openapi3.T
+		routes    []route
+		overrides map[string][]Override
+		requests  map[string]int // the number of requests each operation got, to follow the override scripts
+		mutex     sync.Mutex
+	}
+
+	route struct {
+		path      string
+		segments  []string
+		method    string
+		pathItem  *openapi3.PathItem
+		operation *openapi3.Operation
+	}
+)
+
+// LoadOverrides reads a YAML file of overrides by operation id or action alias.
+func LoadOverrides(overridesFile string) (map[string][]Override, error) {
+	content, err := ioutil.ReadFile(overridesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := map[string][]Override{}
+	if err = yaml.Unmarshal(content, &overrides); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse overrides file %s", overridesFile)
+	}
+
+	return overrides, nil
+}
+
+// NewServer loads the spec and the mask and returns a handler of their operations.
+func NewServer(options Options) (*Server, error) {
+	spec, err := plugin.LoadOpenApi(options.OpenApiFile)
+	if err != nil {
+		return nil, err
+	}
+
+	maskData, err := mask.ParseMask(options.MaskFile)
+	if err != nil {
+		return nil, errors.Errorf("Cannot parse maskData file: %s", options.MaskFile)
+	}
+
+	server := &Server{spec: spec, overrides: map[string][]Override{}, requests: map[string]int{}}
+	for name, overrides := range options.Overrides {
+		server.overrides[maskData.ReplaceActionAlias(name)] = overrides
+	}
+
+	for path, pathItem := range spec.Paths {
+		for method, operation := range pathItem.Operations() {
+			if len(maskData.Actions) > 0 && maskData.GetAction(operation.OperationID) == nil {
+				continue
+			}
+
+			server.routes = append(server.routes, route{
+				path:      path,
+				segments:  strings.Split(strings.Trim(path, "/"), "/"),
+				method:    method,
+				pathItem:  pathItem,
+				operation: operation,
+			})
+		}
+	}
+
+	// static paths are preferred over templated ones, /users/me over /users/{id}.
+	sort.SliceStable(server.routes, func(i, j int) bool {
+		iParams, jParams := strings.Count(server.routes[i].path, consts.ParamPrefix), strings.Count(server.routes[j].path, consts.ParamPrefix)
+		if iParams != jParams {
+			return iParams < jParams
+		}
+		return server.routes[i].path < server.routes[j].path
+	})
+
+	return server, nil
+}
+
+func (s *Server) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	// the query isn't logged, plugins send api keys and tokens in it.
+	log.Info(req.Method + ": " + req.URL.Path)
+
+	matchedRoute, pathParams, pathMatched := s.findRoute(req)
+	if matchedRoute == nil {
+		if pathMatched {
+			writeError(res, http.StatusMethodNotAllowed, fmt.Sprintf("%s is not allowed on %s", req.Method, req.URL.Path))
+			return
+		}
+		writeError(res, http.StatusNotFound, fmt.Sprintf("no operation matches %s %s", req.Method, req.URL.Path))
+		return
+	}
+
+	if problems := s.validateRequest(req, matchedRoute, pathParams); len(problems) > 0 {
+		writeError(res, http.StatusBadRequest, "the request doesn't match the openapi spec", problems...)
+		return
+	}
+
+	override := s.nextOverride(matchedRoute.operation.OperationID)
+	if override.Latency > 0 {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-time.After(override.Latency):
+		}
+	}
+
+	s.writeResponse(res, matchedRoute.operation, override)
+}
+
+// findRoute returns the route of the request and its path params, and whether another method of the path exists.
+func (s *Server) findRoute(req *http.Request) (*route, map[string]string, bool) {
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	pathMatched := false
+
+	for i := range s.routes {
+		pathParams, ok := s.routes[i].match(segments)
+		if !ok {
+			continue
+		}
+
+		if s.routes[i].method == req.Method {
+			return &s.routes[i], pathParams, true
+		}
+		pathMatched = true
+	}
+
+	return nil, nil, pathMatched
+}
+
+func (r route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(r.segments) {
+		return nil, false
+	}
+
+	pathParams := map[string]string{}
+	for i, segment := range r.segments {
+		if strings.HasPrefix(segment, consts.ParamPrefix) && strings.HasSuffix(segment, consts.ParamSuffix) {
+			pathParams[strings.TrimSuffix(strings.TrimPrefix(segment, consts.ParamPrefix), consts.ParamSuffix)] = segments[i]
+			continue
+		}
+
+		if segment != segments[i] {
+			return nil, false
+		}
+	}
+
+	return pathParams, true
+}
+
+// validateRequest validates the params and the body of the request, the security requirements are ignored.
+func (s *Server) validateRequest(req *http.Request, matchedRoute *route, pathParams map[string]string) []string {
+	input := &openapi3filter.RequestValidationInput{
+		Request:    req,
+		PathParams: pathParams,
+		Route: &routers.Route{
+			Spec:      s.spec,
+			Path:      matchedRoute.path,
+			PathItem:  matchedRoute.pathItem,
+			Method:    matchedRoute.method,
+			Operation: matchedRoute.operation,
+		},
+		Options: &openapi3filter.Options{MultiError: true, AuthenticationFunc: openapi3filter.NoopAuthenticationFunc},
+	}
+
+	if err := openapi3filter.ValidateRequest(context.Background(), input); err != nil {
+		return describeRequestError(err)
+	}
+
+	return nil
+}
+
+// describeRequestError flattens the validation errors to one readable line per problem, without the schema dumps.
+func describeRequestError(err error) []string {
+	switch e := err.(type) {
+	case openapi3.MultiError:
+		var problems []string
+		for _, inner := range e {
+			problems = append(problems, describeRequestError(inner)...)
+		}
+		return problems
+	case *openapi3filter.RequestError:
+		prefix := "request body"
+		if e.Parameter != nil {
+			prefix = fmt.Sprintf("parameter %s in %s", e.Parameter.Name, e.Parameter.In)
+		}
+
+		if e.Err == nil {
+			return []string{prefix + ": " + e.Reason}
+		}
+
+		var problems []string
+		for _, problem := range describeRequestError(e.Err) {
+			problems = append(problems, prefix+": "+problem)
+		}
+		return problems
+	case *openapi3.SchemaError:
+		if pointer := e.JSONPointer(); len(pointer) > 0 {
+			return []string{"/" + strings.Join(pointer, "/") + " " + e.Reason}
+		}
+		return []string{e.Reason}
+	default:
+		return []string{err.Error()}
+	}
+}
+
+// nextOverride returns the override of the operation's next request, the zero override when there's none.
+func (s *Server) nextOverride(operationID string) Override {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	request := s.requests[operationID]
+	s.requests[operationID]++
+
+	overrides := s.overrides[operationID]
+	for i, override := range overrides {
+		if override.Times <= 0 || request < override.Times || i == len(overrides)-1 {
+			return override
+		}
+		request -= override.Times
+	}
+
+	return Override{}
+}
+
+func (s *Server) writeResponse(res http.ResponseWriter, operation *openapi3.Operation, override Override) {
+	status, response := selectResponse(operation, override.Status)
+
+	var (
+		contentType string
+		content     []byte
+		err         error
+	)
+
+	switch body := override.Body.(type) {
+	case nil:
+		contentType, content, err = responseContent(response)
+	case string:
+		contentType, content = textContentType(body), []byte(body)
+	default:
+		contentType = consts.RequestBodyType
+		content, err = json.Marshal(body)
+	}
+
+	if err != nil {
+		writeError(res, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if len(content) > 0 {
+		res.Header().Set(consts.ContentTypeHeader, contentType)
+	}
+	for name, value := range override.Headers {
+		res.Header().Set(name, value)
+	}
+
+	res.WriteHeader(status)
+	_, _ = res.Write(content)
+}
+
+func writeError(res http.ResponseWriter, status int, message string, problems ...string) {
+	errorBody := map[string]interface{}{"message": message}
+	if len(problems) > 0 {
+		errorBody["errors"] = problems
+	}
+	body, _ := json.Marshal(errorBody)
+
+	res.Header().Set(consts.ContentTypeHeader, consts.RequestBodyType)
+	res.WriteHeader(status)
+	_, _ = res.Write(body)
+}
@@ -0,0 +1,316 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	usersOpenApi = `
+openapi: 3.0.0
+info:
+  title: users
+  version: 1.0.0
+servers:
+  - url: https://api.example.com
+paths:
+  /users:
+    get:
+      operationId: ListUsers
+      parameters:
+        - name: limit
+          in: query
+          schema:
+            type: integer
+            maximum: 100
+      responses:
+        "200":
+          description: users
+          content:
+            application/json:
+              examples:
+                two:
+                  value: [{"id": 1, "name": "jane"}, {"id": 2, "name": "john"}]
+    post:
+      operationId: CreateUser
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              type: object
+              required: [name]
+              properties:
+                name:
+                  type: string
+      responses:
+        "201":
+          description: created
+          content:
+            application/json:
+              schema:
+                $ref: "#/components/schemas/User"
+  /users/me:
+    get:
+      operationId: GetMe
+      responses:
+        "200":
+          description: me
+          content:
+            application/json:
+              example: {"id": 0, "name": "me"}
+  /users/{user}:
+    get:
+      operationId: GetUser
+      parameters:
+        - name: user
+          in: path
+          required: true
+          schema:
+            type: integer
+      responses:
+        "200":
+          description: user
+          content:
+            application/json:
+              schema:
+                $ref: "#/components/schemas/User"
+        "404":
+          description: not found
+          content:
+            application/json:
+              example: {"message": "user not found"}
+  /health:
+    get:
+      operationId: Health
+      responses:
+        "200":
+          description: health
+          content:
+            text/plain:
+              example: ok
+components:
+  schemas:
+    User:
+      type: object
+      properties:
+        id:
+          type: integer
+        email:
+          type: string
+          format: email
+        role:
+          type: string
+          enum: [admin, member]
+        created_at:
+          type: string
+          format: date-time
+        tags:
+          type: array
+          items:
+            type: string
+        manager:
+          $ref: "#/components/schemas/User"
+`
+
+	usersMask = `
+actions:
+  ListUsers:
+    alias: List Users
+  CreateUser:
+    alias: Create User
+  GetMe:
+    alias: Get Me
+  GetUser:
+    alias: Get User
+`
+)
+
+type MockTestSuite struct {
+	suite.Suite
+	openApiFile string
+	maskFile    string
+}
+
+func (suite *MockTestSuite) SetupSuite() {
+	dir := suite.T().TempDir()
+	suite.openApiFile = filepath.Join(dir, "users-openapi.yaml")
+	suite.maskFile = filepath.Join(dir, "users-mask.yaml")
+	require.NoError(suite.T(), ioutil.WriteFile(suite.openApiFile, []byte(usersOpenApi), 0600))
+	require.NoError(suite.T(), ioutil.WriteFile(suite.maskFile, []byte(usersMask), 0600))
+}
+
+func (suite *MockTestSuite) newServer(options Options) *httptest.Server {
+	options.OpenApiFile = suite.openApiFile
+	server, err := NewServer(options)
+	require.NoError(suite.T(), err)
+
+	return httptest.NewServer(server)
+}
+
+func (suite *MockTestSuite) send(server *httptest.Server, method string, path string, body string) (*http.Response, string) {
+	request, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	require.NoError(suite.T(), err)
+	if body != "" {
+		request.Header.Set(consts.ContentTypeHeader, consts.RequestBodyType)
+	}
+
+	response, err := server.Client().Do(request)
+	require.NoError(suite.T(), err)
+	defer response.Body.Close()
+
+	content, err := ioutil.ReadAll(response.Body)
+	require.NoError(suite.T(), err)
+	return response, string(content)
+}
+
+func (suite *MockTestSuite) TestExamples() {
+	server := suite.newServer(Options{})
+	defer server.Close()
+
+	response, body := suite.send(server, http.MethodGet, "/users", "")
+	assert.Equal(suite.T(), http.StatusOK, response.StatusCode)
+	assert.Equal(suite.T(), consts.RequestBodyType, response.Header.Get(consts.ContentTypeHeader))
+	assert.JSONEq(suite.T(), `[{"id": 1, "name": "jane"}, {"id": 2, "name": "john"}]`, body)
+
+	// the static path wins over the templated one.
+	_, body = suite.send(server, http.MethodGet, "/users/me", "")
+	assert.JSONEq(suite.T(), `{"id": 0, "name": "me"}`, body)
+
+	response, body = suite.send(server, http.MethodGet, "/health", "")
+	assert.Equal(suite.T(), "text/plain", response.Header.Get(consts.ContentTypeHeader))
+	assert.Equal(suite.T(), "ok", body)
+}
+
+func (suite *MockTestSuite) TestSynthesizedPayload() {
+	server := suite.newServer(Options{})
+	defer server.Close()
+
+	response, body := suite.send(server, http.MethodPost, "/users", `{"name": "jane"}`)
+	assert.Equal(suite.T(), http.StatusCreated, response.StatusCode)
+
+	var user map[string]interface{}
+	require.NoError(suite.T(), json.Unmarshal([]byte(body), &user))
+	assert.Equal(suite.T(), float64(1), user["id"])
+	assert.Equal(suite.T(), "user@example.com", user["email"])
+	assert.Equal(suite.T(), "admin", user["role"])
+	assert.Equal(suite.T(), "2021-01-01T00:00:00Z", user["created_at"])
+	assert.Equal(suite.T(), []interface{}{"string"}, user["tags"])
+	assert.Contains(suite.T(), user, "manager")
+}
+
+func (suite *MockTestSuite) TestRequestValidation() {
+	server := suite.newServer(Options{})
+	defer server.Close()
+
+	cases := map[string]struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		"invalid path param":  {http.MethodGet, "/users/jane", "", http.StatusBadRequest},
+		"invalid query param": {http.MethodGet, "/users?limit=500", "", http.StatusBadRequest},
+		"missing body":        {http.MethodPost, "/users", "", http.StatusBadRequest},
+		"invalid body":        {http.MethodPost, "/users", `{"email": "jane@example.com"}`, http.StatusBadRequest},
+		"unknown path":        {http.MethodGet, "/teams", "", http.StatusNotFound},
+		"unknown method":      {http.MethodDelete, "/users", "", http.StatusMethodNotAllowed},
+		"valid":               {http.MethodGet, "/users/7?", "", http.StatusOK},
+	}
+
+	for name, c := range cases {
+		response, body := suite.send(server, c.method, c.path, c.body)
+		assert.Equal(suite.T(), c.status, response.StatusCode, "%s: %s", name, body)
+	}
+
+	_, body := suite.send(server, http.MethodGet, "/users?limit=500", "")
+	assert.Contains(suite.T(), body, "parameter limit in query")
+}
+
+func (suite *MockTestSuite) TestMask() {
+	server := suite.newServer(Options{MaskFile: suite.maskFile})
+	defer server.Close()
+
+	response, _ := suite.send(server, http.MethodGet, "/health", "")
+	assert.Equal(suite.T(), http.StatusNotFound, response.StatusCode)
+
+	response, _ = suite.send(server, http.MethodGet, "/users", "")
+	assert.Equal(suite.T(), http.StatusOK, response.StatusCode)
+}
+
+func (suite *MockTestSuite) TestOverrides() {
+	overridesFile := filepath.Join(suite.T().TempDir(), "overrides.yaml")
+	require.NoError(suite.T(), ioutil.WriteFile(overridesFile, []byte(`
+Get User:
+  - status: 429
+    times: 2
+    headers:
+      Retry-After: "1"
+  - status: 404
+    times: 1
+  - latency: 100ms
+    body:
+      id: 7
+      name: jane
+`), 0600))
+
+	overrides, err := LoadOverrides(overridesFile)
+	require.NoError(suite.T(), err)
+
+	server := suite.newServer(Options{MaskFile: suite.maskFile, Overrides: overrides})
+	defer server.Close()
+
+	for i := 0; i < 2; i++ {
+		response, _ := suite.send(server, http.MethodGet, "/users/7", "")
+		assert.Equal(suite.T(), http.StatusTooManyRequests, response.StatusCode)
+		assert.Equal(suite.T(), "1", response.Header.Get("Retry-After"))
+	}
+
+	response, body := suite.send(server, http.MethodGet, "/users/7", "")
+	assert.Equal(suite.T(), http.StatusNotFound, response.StatusCode)
+	assert.JSONEq(suite.T(), `{"message": "user not found"}`, body)
+
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		response, body = suite.send(server, http.MethodGet, "/users/7", "")
+		assert.Equal(suite.T(), http.StatusOK, response.StatusCode)
+		assert.JSONEq(suite.T(), `{"id": 7, "name": "jane"}`, body)
+		assert.GreaterOrEqual(suite.T(), int64(time.Since(start)), int64(100*time.Millisecond))
+	}
+
+	// the overrides of other operations aren't affected.
+	_, body = suite.send(server, http.MethodGet, "/users/me", "")
+	assert.JSONEq(suite.T(), `{"id": 0, "name": "me"}`, body)
+}
+
+func (suite *MockTestSuite) TestLogsWithoutQuery() {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	server := suite.newServer(Options{})
+	defer server.Close()
+
+	response, _ := suite.send(server, http.MethodGet, "/users?api_key=secret-key", "")
+	assert.Equal(suite.T(), http.StatusOK, response.StatusCode)
+	assert.Contains(suite.T(), logs.String(), "GET: /users")
+	assert.NotContains(suite.T(), logs.String(), "secret-key")
+}
+
+func TestMockSuite(t *testing.T) {
+	suite.Run(t, new(MockTestSuite))
+}
@@ -0,0 +1,224 @@
+package mock
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+const (
+	textContentTypeHeader = "text/plain; charset=utf-8"
+
+	// maxSchemaDepth stops the generation of recursive schemas, like a tree node with children nodes.
+	maxSchemaDepth = 6
+)
+
+// exampleFormats are the generated values of string formats.
+var exampleFormats = map[string]string{
+	"date":      "2021-01-01",
+	"date-time": "2021-01-01T00:00:00Z",
+	"email":     "user@example.com",
+	"uuid":      "3fa85f64-5717-4562-b3fc-2c963f66afa6",
+	"uri":       "https://example.com",
+	"url":       "https://example.com",
+	"hostname":  "example.com",
+	"ipv4":      "192.0.2.1",
+	"ipv6":      "2001:db8::1",
+	"byte":      "ZXhhbXBsZQ==",
+	"password":  "password",
+}
+
+// selectResponse returns the response of the status, the first success response of the operation by default.
+func selectResponse(operation *openapi3.Operation, status int) (int, *openapi3.Response) {
+	if status != 0 {
+		if responseRef := operation.Responses.Get(status); responseRef != nil {
+			return status, responseRef.Value
+		}
+		return status, nil
+	}
+
+	statuses := make([]string, 0, len(operation.Responses))
+	for code := range operation.Responses {
+		statuses = append(statuses, code)
+	}
+	sort.Strings(statuses)
+
+	for _, code := range statuses {
+		if statusCode, err := strconv.Atoi(code); err == nil && statusCode >= 200 && statusCode <= 299 {
+			return statusCode, operation.Responses[code].Value
+		}
+	}
+
+	if defaultResponse := operation.Responses.Default(); defaultResponse != nil {
+		return http.StatusOK, defaultResponse.Value
+	}
+
+	return http.StatusOK, nil
+}
+
+// responseContent returns the example of the response, or a payload generated from its schema.
+// JSON media types are preferred, non JSON string examples are sent as is.
+func responseContent(response *openapi3.Response) (string, []byte, error) {
+	if response == nil || len(response.Content) == 0 {
+		return "", nil, nil
+	}
+
+	contentType := consts.RequestBodyType
+	mediaType := response.Content.Get(contentType)
+	if mediaType == nil {
+		contentTypes := make([]string, 0, len(response.Content))
+		for name := range response.Content {
+			contentTypes = append(contentTypes, name)
+		}
+		sort.Strings(contentTypes)
+
+		contentType = contentTypes[0]
+		mediaType = response.Content[contentType]
+	}
+
+	body := mediaTypeExample(mediaType)
+	if text, ok := body.(string); ok && !isJSONContentType(contentType) {
+		return contentType, []byte(text), nil
+	}
+
+	if body == nil {
+		return contentType, nil, nil
+	}
+
+	content, err := json.Marshal(body)
+	return contentType, content, err
+}
+
+func mediaTypeExample(mediaType *openapi3.MediaType) interface{} {
+	if mediaType == nil {
+		return nil
+	}
+
+	if mediaType.Example != nil {
+		return mediaType.Example
+	}
+
+	names := make([]string, 0, len(mediaType.Examples))
+	for name := range mediaType.Examples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if example := mediaType.Examples[name]; example != nil && example.Value != nil && example.Value.Value != nil {
+			return example.Value.Value
+		}
+	}
+
+	if mediaType.Schema == nil {
+		return nil
+	}
+
+	return exampleValue(mediaType.Schema.Value, 0)
+}
+
+// exampleValue generates a value of the schema from its example, default, enum or type.
+func exampleValue(schema *openapi3.Schema, depth int) interface{} {
+	if schema == nil || depth > maxSchemaDepth {
+		return nil
+	}
+
+	switch {
+	case schema.Example != nil:
+		return schema.Example
+	case schema.Default != nil:
+		return schema.Default
+	case len(schema.Enum) > 0:
+		return schema.Enum[0]
+	case len(schema.AllOf) > 0:
+		return allOfExample(schema, depth)
+	case len(schema.OneOf) > 0:
+		return exampleValue(schema.OneOf[0].Value, depth+1)
+	case len(schema.AnyOf) > 0:
+		return exampleValue(schema.AnyOf[0].Value, depth+1)
+	}
+
+	switch schema.Type {
+	case consts.TypeObject:
+		return objectExample(schema, depth)
+	case consts.TypeArray:
+		if schema.Items == nil {
+			return []interface{}{}
+		}
+		if item := exampleValue(schema.Items.Value, depth+1); item != nil {
+			return []interface{}{item}
+		}
+		return []interface{}{}
+	case consts.TypeInteger:
+		if schema.Min != nil {
+			return int64(*schema.Min)
+		}
+		return 1
+	case "number":
+		if schema.Min != nil {
+			return *schema.Min
+		}
+		return 1.5
+	case consts.TypeBoolean:
+		return true
+	case "string":
+		if value, ok := exampleFormats[schema.Format]; ok {
+			return value
+		}
+		return "string"
+	default:
+		if len(schema.Properties) > 0 {
+			return objectExample(schema, depth)
+		}
+		return nil
+	}
+}
+
+func objectExample(schema *openapi3.Schema, depth int) map[string]interface{} {
+	object := map[string]interface{}{}
+	for name, property := range schema.Properties {
+		if property == nil {
+			continue
+		}
+		if value := exampleValue(property.Value, depth+1); value != nil {
+			object[name] = value
+		}
+	}
+
+	return object
+}
+
+func allOfExample(schema *openapi3.Schema, depth int) interface{} {
+	merged := map[string]interface{}{}
+	for _, subSchema := range schema.AllOf {
+		value := exampleValue(subSchema.Value, depth+1)
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			// allOf of non objects, like a string with extra constraints.
+			return value
+		}
+		for name, propertyValue := range object {
+			merged[name] = propertyValue
+		}
+	}
+
+	return merged
+}
+
+func isJSONContentType(contentType string) bool {
+	return strings.HasPrefix(contentType, consts.RequestBodyType) || strings.Contains(contentType, "+json")
+}
+
+// textContentType returns the content type of an override body, JSON or plain text.
+func textContentType(body string) string {
+	if json.Valid([]byte(body)) {
+		return consts.RequestBodyType
+	}
+
+	return textContentTypeHeader
+}
@@ -0,0 +1,136 @@
+package plugin
+
+import (
+	"net/http"
+	"strings"
+)
+
+// authenticator adds credentials to the requests of a connection, for auth strategies that can't be expressed as static headers.
+type authenticator interface {
+	// authenticate sets the credentials of the request right before it is sent.
+	authenticate(request *http.Request) error
+	// reauthenticate is called with the response of every authenticated request,
+	// it returns whether the credentials were renewed and the request should be sent again.
+	reauthenticate(request *http.Request, result Result) (bool, error)
+}
+
+// requestAuthenticator returns the authenticator of the connection's built in auth strategy, nil when it only has static headers.
+// the keys used by the authenticator are removed from the returned connection, so they aren't sent as headers.
+func requestAuthenticator(connection map[string]string, client *http.Client, opts requestOptions) (authenticator, map[string]string, error) {
+	// plugins with custom auth headers handle the whole authentication themselves.
+	if opts.setCustomHeaders != nil {
+		return nil, connection, nil
+	}
+
+	if signer := newSigV4Signer(connection); signer != nil {
+		return signer, withoutConnectionKeys(connection, sigV4ConnectionKeys), nil
+	}
+
+	signer, err := newHMACSigner(connection, opts.hmac)
+	if err != nil {
+		return nil, nil, err
+	}
+	if signer != nil {
+		return signer, withoutConnectionKeys(connection, []string{signer.config.SecretKey}), nil
+	}
+
+	if session := newSessionAuthenticator(connection, client, opts.session); session != nil {
+		return session, withoutConnectionKeys(connection, opts.session.connectionKeys()), nil
+	}
+
+	if digest := newDigestAuthenticator(connection, opts.digestAuth); digest != nil {
+		return digest, withoutConnectionKeys(connection, digestConnectionKeys), nil
+	}
+
+	jwt, err := newJWTAuthenticator(connection, client)
+	if err != nil {
+		return nil, nil, err
+	}
+	if jwt != nil {
+		return jwt, withoutConnectionKeys(connection, jwtConnectionKeys), nil
+	}
+
+	if auth := newOAuth2Authenticator(connection, client, opts.oauth2); auth != nil {
+		return auth, withoutConnectionKeys(connection, oauth2ConnectionKeys), nil
+	}
+
+	return nil, connection, nil
+}
+
+// sendAuthenticated sends the request with the authenticator's credentials,
+// it is sent once more when the authenticator renewed the credentials after the response.
+func (s sender) sendAuthenticated(request *http.Request) (Result, error) {
+	if err := makeBodyRewindable(request); err != nil {
+		return Result{}, err
+	}
+
+	if err := s.auth.authenticate(request); err != nil {
+		return Result{}, &authError{err: err}
+	}
+
+	result, err := sendRequest(s.client, request, s.sizeLimit, s.redact)
+	if err != nil {
+		return result, err
+	}
+
+	resend, err := s.auth.reauthenticate(request, result)
+	if err != nil {
+		return result, &authError{err: err}
+	}
+	if !resend {
+		return result, nil
+	}
+
+	if request.GetBody != nil {
+		if request.Body, err = request.GetBody(); err != nil {
+			return result, err
+		}
+	}
+
+	if err = s.limiter.wait(request.Context(), s.weight); err != nil {
+		return result, err
+	}
+
+	if err = s.auth.authenticate(request); err != nil {
+		return result, &authError{err: err}
+	}
+
+	return sendRequest(s.client, request, s.sizeLimit, s.redact)
+}
+
+// withoutConnectionKeys returns a copy of the connection without the keys, they are matched case insensitively.
+func withoutConnectionKeys(connection map[string]string, keys []string) map[string]string {
+	filtered := make(map[string]string, len(connection))
+	for key, value := range connection {
+		if !containsFold(keys, key) {
+			filtered[key] = value
+		}
+	}
+
+	return filtered
+}
+
+// connectionValue returns the value of the key, matched case insensitively like the connection headers.
+func connectionValue(connection map[string]string, key string) string {
+	if value, ok := connection[key]; ok {
+		return value
+	}
+
+	for name, value := range connection {
+		if strings.EqualFold(name, key) {
+			return value
+		}
+	}
+
+	return ""
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,171 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	"github.com/blinkops/blink-openapi-sdk/plugin/handlers"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/pkg/errors"
+)
+
+const rawBodyDescription = "The content to send, base64 encoded or a file:// reference to a local file"
+
+// rawBodySchema returns the schema of the raw body param, bodies without a schema are binary.
+func rawBodySchema(body handlers.RequestBodyDefinition) *openapi3.SchemaRef {
+	if body.Schema.OApiSchema != nil {
+		return &openapi3.SchemaRef{Value: body.Schema.OApiSchema}
+	}
+
+	return &openapi3.SchemaRef{Value: &openapi3.Schema{Type: "string", Format: consts.FormatBinary}}
+}
+
+// parseRawBody sends the content of the raw body param as is, for bodies like application/octet-stream.
+func parseRawBody(requestParameters map[string]string, defaultBody *handlers.RequestBodyDefinition, request *http.Request, fileReferenceDir string) error {
+	paramValue, ok := requestParameters[consts.RawBodyParam]
+	if !ok {
+		return nil
+	}
+
+	content, _, err := decodeBinaryParam(paramValue, fileReferenceDir)
+	if err != nil {
+		return errors.Wrapf(err, "invalid %s param", consts.RawBodyParam)
+	}
+
+	setRequestBody(request, content)
+	request.Header.Set(consts.ContentTypeHeader, defaultBody.ContentType)
+	return nil
+}
+
+// encodeMultipartBody writes every body property as a form field, binary properties are written as files.
+// it returns the body and its content type, which holds the boundary of the parts.
+func encodeMultipartBody(requestBody map[string]interface{}, bodySchema *openapi3.Schema, fileReferenceDir string) ([]byte, string, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	names := make([]string, 0, len(requestBody))
+	for name := range requestBody {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := writeMultipartField(writer, name, requestBody[name], bodySchema, fileReferenceDir); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return body.Bytes(), writer.FormDataContentType(), nil
+}
+
+func writeMultipartField(writer *multipart.Writer, name string, value interface{}, bodySchema *openapi3.Schema, fileReferenceDir string) error {
+	if bodySchema != nil {
+		if propertySchema := handlers.GetPropertyByName(name, bodySchema); propertySchema != nil && handlers.IsBinarySchema(propertySchema) {
+			content, filename, err := decodeBinaryParam(fmt.Sprintf("%v", value), fileReferenceDir)
+			if err != nil {
+				return errors.Wrapf(err, "invalid %s param", name)
+			}
+			if filename == "" {
+				filename = name
+			}
+
+			part, err := writer.CreateFormFile(name, filename)
+			if err != nil {
+				return err
+			}
+
+			_, err = part.Write(content)
+			return err
+		}
+	}
+
+	switch typedValue := value.(type) {
+	case []string:
+		// arrays are sent as a repeated field.
+		for _, item := range typedValue {
+			if err := writer.WriteField(name, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		marshaledValue, err := json.Marshal(typedValue)
+		if err != nil {
+			return err
+		}
+		return writer.WriteField(name, string(marshaledValue))
+	default:
+		return writer.WriteField(name, fmt.Sprintf("%v", typedValue))
+	}
+}
+
+// decodeBinaryParam returns the content of a binary param, which is either base64 content or a file:// reference to a local file.
+// files are only read under fileReferenceDir, references are rejected when it's empty. the filename is returned only for file references.
+func decodeBinaryParam(paramValue string, fileReferenceDir string) ([]byte, string, error) {
+	if strings.HasPrefix(paramValue, consts.FileReference) {
+		path, err := resolveFileReference(strings.TrimPrefix(paramValue, consts.FileReference), fileReferenceDir)
+		if err != nil {
+			return nil, "", err
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "failed to read the referenced file")
+		}
+
+		return content, filepath.Base(path), nil
+	}
+
+	content, err := base64.StdEncoding.DecodeString(paramValue)
+	if err != nil {
+		return nil, "", errors.New("the content must be base64 encoded or a file:// reference")
+	}
+
+	return content, "", nil
+}
+
+// resolveFileReference returns the path of a referenced file, relative references are relative to the directory.
+// the path must resolve inside the directory, after following symlinks, so params can't read other files of the plugin's host.
+func resolveFileReference(reference string, fileReferenceDir string) (string, error) {
+	if fileReferenceDir == "" {
+		return "", errors.New("file:// references are disabled, the plugin has no file reference directory")
+	}
+
+	dir, err := filepath.Abs(fileReferenceDir)
+	if err != nil {
+		return "", err
+	}
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		return "", errors.Wrap(err, "failed to resolve the file reference directory")
+	}
+
+	path := filepath.FromSlash(reference)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	path = filepath.Clean(path)
+
+	// a missing file is reported by the read, only existing paths can be resolved.
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	if !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+		return "", errors.Errorf("the referenced file %s is outside of the file reference directory", reference)
+	}
+
+	return path, nil
+}
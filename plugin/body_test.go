@@ -0,0 +1,201 @@
+package plugin
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	plugin_sdk "github.com/blinkops/blink-sdk/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const uploadsOpenApi = `
+openapi: 3.0.0
+info:
+  title: uploads
+  version: 1.0.0
+servers:
+  - url: https://api.example.com
+paths:
+  /files.upload:
+    post:
+      operationId: UploadFile
+      requestBody:
+        required: true
+        content:
+          multipart/form-data:
+            schema:
+              type: object
+              required: [file]
+              properties:
+                file:
+                  type: string
+                  format: binary
+                title:
+                  type: string
+                channels:
+                  type: array
+                  items:
+                    type: string
+                metadata:
+                  type: object
+                  properties:
+                    owner:
+                      type: string
+      responses:
+        "200":
+          description: uploaded
+  /issues/{issue}/attachments:
+    put:
+      operationId: PutAttachment
+      parameters:
+        - name: issue
+          in: path
+          required: true
+          schema:
+            type: string
+      requestBody:
+        content:
+          application/octet-stream: {}
+      responses:
+        "200":
+          description: attached
+`
+
+type BodyTestSuite struct {
+	suite.Suite
+	plugin *openApiPlugin
+	dir    string
+}
+
+func (suite *BodyTestSuite) SetupSuite() {
+	suite.dir = suite.T().TempDir()
+	openApiFile := filepath.Join(suite.dir, "uploads-openapi.yaml")
+	require.NoError(suite.T(), ioutil.WriteFile(openApiFile, []byte(uploadsOpenApi), 0600))
+
+	var err error
+	suite.plugin, err = NewOpenApiPlugin(nil, PluginMetadata{Name: "uploads", Provider: "uploads", OpenApiFile: openApiFile, FileReferenceDir: suite.dir}, Callbacks{})
+	require.NoError(suite.T(), err)
+}
+
+func (suite *BodyTestSuite) parseRequest(actionName string, parameters map[string]string) *http.Request {
+	request, err := suite.plugin.parseActionRequest(&plugin_sdk.ExecuteActionRequest{Name: actionName, Parameters: parameters}, suite.plugin.requestUrl)
+	require.NoError(suite.T(), err)
+	return request
+}
+
+func (suite *BodyTestSuite) TestMultipartBody() {
+	request := suite.parseRequest("UploadFile", map[string]string{
+		"file":           base64.StdEncoding.EncodeToString([]byte("hello world")),
+		"title":          "greeting",
+		"channels":       "general,random",
+		"metadata.owner": "jane",
+	})
+
+	mediaType, params, err := mime.ParseMediaType(request.Header.Get(consts.ContentTypeHeader))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), consts.MultipartFormData, mediaType)
+	assert.NotEmpty(suite.T(), params["boundary"])
+
+	require.NoError(suite.T(), request.ParseMultipartForm(1<<20))
+	assert.Equal(suite.T(), []string{"greeting"}, request.MultipartForm.Value["title"])
+	assert.Equal(suite.T(), []string{"general", "random"}, request.MultipartForm.Value["channels"])
+	assert.Equal(suite.T(), []string{`{"owner":"jane"}`}, request.MultipartForm.Value["metadata"])
+
+	files := request.MultipartForm.File["file"]
+	require.Len(suite.T(), files, 1)
+	assert.Equal(suite.T(), "file", files[0].Filename)
+	assert.Equal(suite.T(), "hello world", suite.readFile(files[0].Open))
+}
+
+func (suite *BodyTestSuite) TestMultipartFileReference() {
+	path := filepath.Join(suite.dir, "report.csv")
+	require.NoError(suite.T(), ioutil.WriteFile(path, []byte("a,b\n1,2\n"), 0600))
+
+	request := suite.parseRequest("UploadFile", map[string]string{"file": consts.FileReference + path})
+
+	require.NoError(suite.T(), request.ParseMultipartForm(1<<20))
+	files := request.MultipartForm.File["file"]
+	require.Len(suite.T(), files, 1)
+	assert.Equal(suite.T(), "report.csv", files[0].Filename)
+	assert.Equal(suite.T(), "a,b\n1,2\n", suite.readFile(files[0].Open))
+}
+
+func (suite *BodyTestSuite) TestInvalidBinaryParam() {
+	_, err := suite.plugin.parseActionRequest(&plugin_sdk.ExecuteActionRequest{Name: "UploadFile", Parameters: map[string]string{"file": "not base64!"}}, suite.plugin.requestUrl)
+	assert.EqualError(suite.T(), err, "invalid file param: the content must be base64 encoded or a file:// reference")
+
+	_, err = suite.plugin.parseActionRequest(&plugin_sdk.ExecuteActionRequest{Name: "UploadFile", Parameters: map[string]string{"file": consts.FileReference + filepath.Join(suite.dir, "missing")}}, suite.plugin.requestUrl)
+	assert.Error(suite.T(), err)
+}
+
+func (suite *BodyTestSuite) TestFileReferenceDir() {
+	outside := suite.T().TempDir()
+	secret := filepath.Join(outside, "secret.txt")
+	require.NoError(suite.T(), ioutil.WriteFile(secret, []byte("secret"), 0600))
+	require.NoError(suite.T(), ioutil.WriteFile(filepath.Join(suite.dir, "notes.txt"), []byte("notes"), 0600))
+	require.NoError(suite.T(), os.Symlink(secret, filepath.Join(suite.dir, "link.txt")))
+
+	// relative references are read from the directory.
+	request := suite.parseRequest("UploadFile", map[string]string{"file": consts.FileReference + "notes.txt"})
+	require.NoError(suite.T(), request.ParseMultipartForm(1<<20))
+	assert.Equal(suite.T(), "notes", suite.readFile(request.MultipartForm.File["file"][0].Open))
+
+	relative, err := filepath.Rel(suite.dir, secret)
+	require.NoError(suite.T(), err)
+
+	for _, reference := range []string{secret, relative, filepath.Join(suite.dir, "notes.txt") + "/../" + relative, "link.txt", suite.dir} {
+		_, err = suite.plugin.parseActionRequest(&plugin_sdk.ExecuteActionRequest{Name: "UploadFile", Parameters: map[string]string{"file": consts.FileReference + reference}}, suite.plugin.requestUrl)
+		require.Error(suite.T(), err, reference)
+		assert.Contains(suite.T(), err.Error(), "outside of the file reference directory", reference)
+	}
+
+	// plugins without the directory don't read files at all.
+	p, err := NewOpenApiPlugin(nil, PluginMetadata{Name: "uploads", Provider: "uploads", OpenApiFile: filepath.Join(suite.dir, "uploads-openapi.yaml")}, Callbacks{})
+	require.NoError(suite.T(), err)
+	_, err = p.parseActionRequest(&plugin_sdk.ExecuteActionRequest{Name: "UploadFile", Parameters: map[string]string{"file": consts.FileReference + filepath.Join(suite.dir, "notes.txt")}}, p.requestUrl)
+	require.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "file:// references are disabled")
+}
+
+func (suite *BodyTestSuite) TestRawBody() {
+	action := suite.plugin.getAction("PutAttachment")
+	require.NotNil(suite.T(), action)
+	assert.Contains(suite.T(), action.Parameters, consts.RawBodyParam)
+
+	content := []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}
+	request := suite.parseRequest("PutAttachment", map[string]string{
+		"issue":             "ISSUE-1",
+		consts.RawBodyParam: base64.StdEncoding.EncodeToString(content),
+	})
+
+	assert.Equal(suite.T(), consts.OctetStream, request.Header.Get(consts.ContentTypeHeader))
+	assert.Equal(suite.T(), int64(len(content)), request.ContentLength)
+	body, err := ioutil.ReadAll(request.Body)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), content, body)
+
+	assert.NoError(suite.T(), suite.plugin.validateParameters(&plugin_sdk.ExecuteActionRequest{Name: "PutAttachment", Parameters: map[string]string{"issue": "ISSUE-1", consts.RawBodyParam: "aGk="}}))
+}
+
+func (suite *BodyTestSuite) readFile(open func() (multipart.File, error)) string {
+	file, err := open()
+	require.NoError(suite.T(), err)
+	defer file.Close()
+
+	content, err := ioutil.ReadAll(file)
+	require.NoError(suite.T(), err)
+	return string(content)
+}
+
+func TestBodySuite(t *testing.T) {
+	suite.Run(t, new(BodyTestSuite))
+}
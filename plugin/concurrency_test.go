@@ -0,0 +1,123 @@
+package plugin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	plugin_sdk "github.com/blinkops/blink-sdk/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	parallelActions = 50
+
+	tenantOpenApi = `
+openapi: 3.0.0
+info:
+  title: tenants
+  version: 1.0.0
+servers:
+  - url: https://api.example.com
+paths:
+  /users/{userId}:
+    get:
+      operationId: GetUser
+      parameters:
+        - name: userId
+          in: path
+          required: true
+          schema:
+            type: string
+      responses:
+        "200":
+          description: user
+`
+)
+
+type ConcurrencyTestSuite struct {
+	suite.Suite
+	plugin *openApiPlugin
+}
+
+func (suite *ConcurrencyTestSuite) SetupSuite() {
+	openApiFile := filepath.Join(suite.T().TempDir(), "tenant-openapi.yaml")
+	require.NoError(suite.T(), ioutil.WriteFile(openApiFile, []byte(tenantOpenApi), 0600))
+
+	var err error
+	suite.plugin, err = NewOpenApiPlugin(nil, PluginMetadata{Name: "tenants", Provider: "tenants", OpenApiFile: openApiFile}, Callbacks{})
+	require.NoError(suite.T(), err)
+}
+
+// newTenantServer counts the requests that were sent with the token of another tenant.
+func newTenantServer(tenant string, misrouted *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Token") != tenant {
+			atomic.AddInt32(misrouted, 1)
+		}
+		_, _ = res.Write([]byte(fmt.Sprintf(`{"tenant":"%s"}`, tenant)))
+	}))
+}
+
+func (suite *ConcurrencyTestSuite) TestParallelActionsWithDifferentConnections() {
+	var misrouted int32
+	tenants := []string{"tenant-a", "tenant-b"}
+	servers := map[string]*httptest.Server{}
+	for _, tenant := range tenants {
+		servers[tenant] = newTenantServer(tenant, &misrouted)
+		defer servers[tenant].Close()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < parallelActions; i++ {
+		tenant := tenants[i%len(tenants)]
+		connection := map[string]string{consts.RequestUrlKey: servers[tenant].URL, "TOKEN": tenant}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := suite.plugin.executeActionWithCredentials(connection, &plugin_sdk.ExecuteActionRequest{
+				Name:       "GetUser",
+				Parameters: map[string]string{"userId": "1"},
+				Timeout:    10,
+			})
+
+			assert.Equal(suite.T(), int64(consts.OK), res.ErrorCode, string(res.Result))
+			assert.JSONEq(suite.T(), fmt.Sprintf(`{"tenant":"%s"}`, tenant), string(res.Result))
+			// the connection of the caller must not be modified
+			assert.Contains(suite.T(), connection, consts.RequestUrlKey)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(suite.T(), int32(0), atomic.LoadInt32(&misrouted))
+	// the plugin keeps the url of the openapi file
+	assert.Equal(suite.T(), "https://api.example.com", suite.plugin.requestUrl)
+}
+
+func (suite *ConcurrencyTestSuite) TestPluginsDontShareOperations() {
+	openApiFile := filepath.Join(suite.T().TempDir(), "other-openapi.yaml")
+	otherOpenApi := strings.ReplaceAll(tenantOpenApi, "GetUser", "GetAccount")
+	require.NoError(suite.T(), ioutil.WriteFile(openApiFile, []byte(otherOpenApi), 0600))
+
+	otherPlugin, err := NewOpenApiPlugin(nil, PluginMetadata{Name: "other", Provider: "other", OpenApiFile: openApiFile}, Callbacks{})
+	require.NoError(suite.T(), err)
+
+	assert.NotNil(suite.T(), suite.plugin.operations.Get("GetUser"))
+	assert.Nil(suite.T(), suite.plugin.operations.Get("GetAccount"))
+	assert.NotNil(suite.T(), otherPlugin.operations.Get("GetAccount"))
+	assert.Nil(suite.T(), otherPlugin.operations.Get("GetUser"))
+}
+
+func TestConcurrencySuite(t *testing.T) {
+	suite.Run(t, new(ConcurrencyTestSuite))
+}
@@ -0,0 +1,278 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	"github.com/pkg/errors"
+)
+
+// the clients of transport settings that aren't used anymore are evicted, their idle connections are closed.
+const connectionClientIdleTTL = 10 * time.Minute
+
+// reservedConnectionKeys configure the request instead of being sent as headers.
+var reservedConnectionKeys = []string{
+	consts.RequestUrlKey,
+	consts.ProxyUrlKey,
+	consts.NoProxyKey,
+	consts.CABundleKey,
+	consts.ClientCertKey,
+	consts.ClientKeyKey,
+	consts.InsecureSkipVerifyKey,
+	consts.AuthTypeKey,
+}
+
+type (
+	// connectionTransport is the proxy and TLS settings of a connection.
+	connectionTransport struct {
+		proxyURL           string
+		noProxy            string
+		caBundle           string
+		clientCert         string
+		clientKey          string
+		insecureSkipVerify string
+	}
+
+	// clientCache keeps a client per connection transport settings, so their connections are pooled between requests.
+	// idle clients are evicted, it is safe for concurrent use.
+	clientCache struct {
+		config    TransportConfig
+		client    *http.Client // the client of connections without transport settings
+		mutex     sync.Mutex
+		clients   map[string]*connectionClient
+		lastEvict time.Time
+	}
+
+	// connectionClient is a cached client, its transport is kept since the middleware may hide it.
+	connectionClient struct {
+		client    *http.Client
+		transport *http.Transport
+		lastUsed  time.Time
+	}
+)
+
+func isReservedConnectionKey(key string) bool {
+	return containsFold(reservedConnectionKeys, key)
+}
+
+func newConnectionTransport(connection map[string]string) connectionTransport {
+	return connectionTransport{
+		proxyURL:           strings.TrimSpace(connection[consts.ProxyUrlKey]),
+		noProxy:            connection[consts.NoProxyKey],
+		caBundle:           normalizePEM(connection[consts.CABundleKey]),
+		clientCert:         normalizePEM(connection[consts.ClientCertKey]),
+		clientKey:          normalizePEM(connection[consts.ClientKeyKey]),
+		insecureSkipVerify: strings.TrimSpace(connection[consts.InsecureSkipVerifyKey]),
+	}
+}
+
+// normalizePEM restores the newlines of PEMs that were saved as a single line, with escaped newlines.
+func normalizePEM(value string) string {
+	return strings.TrimSpace(strings.ReplaceAll(value, `\n`, "\n"))
+}
+
+func (t connectionTransport) isDefault() bool {
+	return t == connectionTransport{}
+}
+
+func (t connectionTransport) key() string {
+	hash := sha256.New()
+	for _, value := range []string{t.proxyURL, t.noProxy, t.caBundle, t.clientCert, t.clientKey, t.insecureSkipVerify} {
+		hash.Write([]byte(value))
+		hash.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// apply configures the proxy and the TLS settings of the transport.
+func (t connectionTransport) apply(transport *http.Transport) error {
+	if t.proxyURL != "" {
+		proxyURL, err := url.Parse(t.proxyURL)
+		if err != nil || proxyURL.Host == "" {
+			return errors.Errorf("invalid %s, expected http(s)://host:port", consts.ProxyUrlKey)
+		}
+		if proxyURL.Scheme != "http" && proxyURL.Scheme != "https" {
+			return errors.Errorf("unsupported %s scheme %s", consts.ProxyUrlKey, proxyURL.Scheme)
+		}
+
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	// NO_PROXY applies to the proxy of the connection, and to the proxy of the environment when the connection has none.
+	if t.noProxy != "" {
+		noProxy, proxy := parseNoProxy(t.noProxy), transport.Proxy
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			if proxy == nil || noProxy.matches(req.URL) {
+				return nil, nil
+			}
+			return proxy(req)
+		}
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if transport.TLSClientConfig != nil {
+		tlsConfig = transport.TLSClientConfig.Clone()
+	}
+
+	if t.caBundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(t.caBundle)) {
+			return errors.Errorf("%s doesn't contain PEM certificates", consts.CABundleKey)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if t.clientCert != "" || t.clientKey != "" {
+		certificate, err := tls.X509KeyPair([]byte(t.clientCert), []byte(t.clientKey))
+		if err != nil {
+			return errors.Wrapf(err, "invalid %s and %s", consts.ClientCertKey, consts.ClientKeyKey)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	if t.insecureSkipVerify != "" {
+		insecure, err := strconv.ParseBool(t.insecureSkipVerify)
+		if err != nil {
+			return errors.Errorf("invalid %s, expected true or false", consts.InsecureSkipVerifyKey)
+		}
+		tlsConfig.InsecureSkipVerify = insecure
+	}
+
+	transport.TLSClientConfig = tlsConfig
+	return nil
+}
+
+// noProxyRules are the hosts that bypass the proxy, in the format of the NO_PROXY environment variable.
+type noProxyRules struct {
+	all      bool
+	networks []*net.IPNet
+	hosts    []noProxyHost
+}
+
+type noProxyHost struct {
+	domain string // matches the domain and its subdomains
+	port   string // matches every port when empty
+}
+
+func parseNoProxy(value string) noProxyRules {
+	rules := noProxyRules{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+
+		if entry == "*" {
+			rules.all = true
+			continue
+		}
+
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			rules.networks = append(rules.networks, network)
+			continue
+		}
+
+		if ip := net.ParseIP(entry); ip != nil {
+			rules.networks = append(rules.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+
+		host := noProxyHost{domain: entry}
+		if domain, port, err := net.SplitHostPort(entry); err == nil {
+			host = noProxyHost{domain: domain, port: port}
+		}
+		host.domain = strings.TrimPrefix(strings.TrimPrefix(host.domain, "*"), ".")
+		rules.hosts = append(rules.hosts, host)
+	}
+
+	return rules
+}
+
+func (r noProxyRules) matches(requestURL *url.URL) bool {
+	if r.all {
+		return true
+	}
+
+	hostname, port := strings.ToLower(requestURL.Hostname()), requestURL.Port()
+	if ip := net.ParseIP(hostname); ip != nil {
+		for _, network := range r.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	for _, host := range r.hosts {
+		if host.port != "" && host.port != port {
+			continue
+		}
+		if hostname == host.domain || strings.HasSuffix(hostname, "."+host.domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func newClientCache(config TransportConfig) *clientCache {
+	return &clientCache{config: config, client: newHTTPClient(config), clients: map[string]*connectionClient{}}
+}
+
+// get returns the client of the connection's transport settings, the shared client when it has none.
+func (c *clientCache) get(connection map[string]string) (*http.Client, error) {
+	settings := newConnectionTransport(connection)
+	if settings.isDefault() {
+		return c.client, nil
+	}
+
+	key := settings.key()
+	now := time.Now()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if now.Sub(c.lastEvict) >= connectionClientIdleTTL {
+		c.evictIdle(now)
+	}
+
+	if cached, ok := c.clients[key]; ok {
+		cached.lastUsed = now
+		return cached.client, nil
+	}
+
+	transport := newHTTPTransport(c.config)
+	if err := settings.apply(transport); err != nil {
+		return nil, err
+	}
+
+	client := wrapTransport(c.config, transport)
+	c.clients[key] = &connectionClient{client: client, transport: transport, lastUsed: now}
+	return client, nil
+}
+
+// evictIdle removes the clients that weren't used for connectionClientIdleTTL and closes their idle connections,
+// requests that still use an evicted client close its connections when they're done. the caller must hold the lock.
+func (c *clientCache) evictIdle(now time.Time) {
+	for key, cached := range c.clients {
+		if now.Sub(cached.lastUsed) >= connectionClientIdleTTL {
+			cached.transport.CloseIdleConnections()
+			delete(c.clients, key)
+		}
+	}
+
+	c.lastEvict = now
+}
@@ -0,0 +1,341 @@
+package plugin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blinkops/blink-openapi-sdk/cassette"
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type ConnectionTransportTestSuite struct {
+	suite.Suite
+}
+
+// newTestCertificate creates a certificate signed by the parent, a self signed CA when the parent is nil.
+func newTestCertificate(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return certificate, key, string(certPEM), string(keyPEM)
+}
+
+func serverCertificatePEM(server *httptest.Server) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+}
+
+func (suite *ConnectionTransportTestSuite) send(connection map[string]string, requestUrl string) (Result, error) {
+	request, err := http.NewRequest(http.MethodGet, requestUrl, nil)
+	require.NoError(suite.T(), err)
+
+	return executeRequestWithCredentials(connection, request, requestOptions{clients: newClientCache(TransportConfig{}), timeout: 5})
+}
+
+func (suite *ConnectionTransportTestSuite) TestClientCertificate() {
+	ca, caKey, _, _ := newTestCertificate(suite.T(), &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	_, _, clientCert, clientKey := newTestCertificate(suite.T(), &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "blink"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		_, _ = res.Write([]byte(req.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	connection := map[string]string{consts.CABundleKey: serverCertificatePEM(server)}
+	_, err := suite.send(connection, server.URL)
+	assert.Error(suite.T(), err)
+
+	// PEMs saved as a single line have escaped newlines.
+	connection[consts.ClientCertKey] = strings.ReplaceAll(clientCert, "\n", `\n`)
+	connection[consts.ClientKeyKey] = clientKey
+	result, err := suite.send(connection, server.URL)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), http.StatusOK, result.StatusCode)
+	assert.Equal(suite.T(), "blink", string(result.Body))
+}
+
+func (suite *ConnectionTransportTestSuite) TestCABundle() {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+
+	_, err := suite.send(nil, server.URL)
+	assert.Error(suite.T(), err)
+
+	result, err := suite.send(map[string]string{consts.CABundleKey: serverCertificatePEM(server)}, server.URL)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), http.StatusOK, result.StatusCode)
+
+	result, err = suite.send(map[string]string{consts.InsecureSkipVerifyKey: "true"}, server.URL)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), http.StatusOK, result.StatusCode)
+
+	_, err = suite.send(map[string]string{consts.InsecureSkipVerifyKey: "false"}, server.URL)
+	assert.Error(suite.T(), err)
+}
+
+func (suite *ConnectionTransportTestSuite) TestProxy() {
+	target := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		_, _ = res.Write([]byte("direct"))
+	}))
+	defer target.Close()
+
+	var proxiedUrl string
+	proxy := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		proxiedUrl = req.URL.String()
+		_, _ = res.Write([]byte("proxied " + req.Header.Get("Proxy-Authorization")))
+	}))
+	defer proxy.Close()
+
+	proxyUrl, err := url.Parse(proxy.URL)
+	require.NoError(suite.T(), err)
+	proxyUrl.User = url.UserPassword("user", "password")
+
+	connection := map[string]string{consts.ProxyUrlKey: proxyUrl.String()}
+	result, err := suite.send(connection, target.URL+"/users")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "proxied "+constructBasicAuthHeader("user", "password"), string(result.Body))
+	assert.Equal(suite.T(), target.URL+"/users", proxiedUrl)
+
+	connection[consts.NoProxyKey] = "example.com, 127.0.0.0/8"
+	result, err = suite.send(connection, target.URL+"/users")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "direct", string(result.Body))
+}
+
+func (suite *ConnectionTransportTestSuite) TestNoProxy() {
+	rules := parseNoProxy(" .example.com, internal:8080,10.0.0.0/8, ::1 ")
+
+	cases := map[string]bool{
+		"https://example.com/users":      true,
+		"https://api.example.com/users":  true,
+		"https://notexample.com/users":   false,
+		"http://internal:8080":           true,
+		"http://internal:9090":           false,
+		"http://10.1.2.3":                true,
+		"http://11.1.2.3":                false,
+		"http://[::1]:8080":              true,
+		"https://API.EXAMPLE.COM/users":  true,
+		"https://example.com.evil/users": false,
+	}
+
+	for requestUrl, bypass := range cases {
+		parsed, err := url.Parse(requestUrl)
+		require.NoError(suite.T(), err)
+		assert.Equal(suite.T(), bypass, rules.matches(parsed), requestUrl)
+	}
+
+	all, err := url.Parse("https://anything.io")
+	require.NoError(suite.T(), err)
+	assert.True(suite.T(), parseNoProxy("*").matches(all))
+	assert.False(suite.T(), parseNoProxy("").matches(all))
+}
+
+func (suite *ConnectionTransportTestSuite) TestNoProxyEnvironment() {
+	environmentProxy, err := url.Parse("http://env-proxy:3128")
+	require.NoError(suite.T(), err)
+
+	// the transport's proxy stands for HTTPS_PROXY, the environment is only read once per process.
+	transport := newHTTPTransport(TransportConfig{})
+	transport.Proxy = http.ProxyURL(environmentProxy)
+	require.NoError(suite.T(), newConnectionTransport(map[string]string{consts.NoProxyKey: "internal.example.com"}).apply(transport))
+
+	for requestUrl, expected := range map[string]*url.URL{
+		"https://internal.example.com/users": nil,
+		"https://api.example.com/users":      environmentProxy,
+	} {
+		request, err := http.NewRequest(http.MethodGet, requestUrl, nil)
+		require.NoError(suite.T(), err)
+
+		proxy, err := transport.Proxy(request)
+		require.NoError(suite.T(), err)
+		assert.Equal(suite.T(), expected, proxy, requestUrl)
+	}
+}
+
+func (suite *ConnectionTransportTestSuite) TestInvalidSettings() {
+	connections := []map[string]string{
+		{consts.ProxyUrlKey: "proxy:8080"},
+		{consts.ProxyUrlKey: "ftp://proxy:8080"},
+		{consts.CABundleKey: "not a certificate"},
+		{consts.ClientCertKey: "not a certificate", consts.ClientKeyKey: "not a key"},
+		{consts.InsecureSkipVerifyKey: "sometimes"},
+	}
+
+	for _, connection := range connections {
+		_, err := suite.send(connection, "https://example.com")
+		assert.Error(suite.T(), err, connection)
+	}
+}
+
+func (suite *ConnectionTransportTestSuite) TestReservedKeysAreNotHeaders() {
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		headers = req.Header.Clone()
+	}))
+	defer server.Close()
+
+	connection := map[string]string{
+		consts.NoProxyKey:            "example.com",
+		consts.InsecureSkipVerifyKey: "false",
+		"token":                      "secret-token",
+	}
+	_, err := suite.send(connection, server.URL)
+	require.NoError(suite.T(), err)
+
+	assert.Equal(suite.T(), "secret-token", headers.Get("Token"))
+	assert.Empty(suite.T(), headers.Get(consts.NoProxyKey))
+	assert.Empty(suite.T(), headers.Get(consts.InsecureSkipVerifyKey))
+}
+
+func (suite *ConnectionTransportTestSuite) TestClientCache() {
+	clients := newClientCache(TransportConfig{})
+
+	defaultClient, err := clients.get(map[string]string{"TOKEN": "token"})
+	require.NoError(suite.T(), err)
+	assert.Same(suite.T(), clients.client, defaultClient)
+
+	proxied, err := clients.get(map[string]string{consts.ProxyUrlKey: "http://proxy:8080"})
+	require.NoError(suite.T(), err)
+	again, err := clients.get(map[string]string{consts.ProxyUrlKey: "http://proxy:8080", "TOKEN": "other"})
+	require.NoError(suite.T(), err)
+	other, err := clients.get(map[string]string{consts.ProxyUrlKey: "http://other:8080"})
+	require.NoError(suite.T(), err)
+
+	assert.Same(suite.T(), proxied, again)
+	assert.NotSame(suite.T(), proxied, other)
+	assert.NotSame(suite.T(), defaultClient, proxied)
+}
+
+func (suite *ConnectionTransportTestSuite) TestEvictIdleClients() {
+	closed := make(chan struct{}, 1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed <- struct{}{}
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	clients := newClientCache(TransportConfig{})
+	idle, err := clients.get(map[string]string{consts.NoProxyKey: "*"})
+	require.NoError(suite.T(), err)
+	response, err := idle.Get(server.URL)
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), response.Body.Close())
+	active, err := clients.get(map[string]string{consts.ProxyUrlKey: "http://proxy:8080"})
+	require.NoError(suite.T(), err)
+
+	// the client wasn't used for longer than the ttl, its pooled connection is closed.
+	now := time.Now()
+	clients.clients[newConnectionTransport(map[string]string{consts.NoProxyKey: "*"}).key()].lastUsed = now.Add(-connectionClientIdleTTL)
+	clients.lastEvict = now.Add(-connectionClientIdleTTL)
+	again, err := clients.get(map[string]string{consts.ProxyUrlKey: "http://proxy:8080"})
+	require.NoError(suite.T(), err)
+	assert.Same(suite.T(), active, again)
+	assert.Len(suite.T(), clients.clients, 1)
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		suite.Fail("the idle connection of the evicted client wasn't closed")
+	}
+}
+
+func (suite *ConnectionTransportTestSuite) TestMiddlewarePerConnection() {
+	// the servers have their own self signed certificates, the certificate of httptest servers is shared.
+	servers := make([]*httptest.Server, 2)
+	caBundles := make([]string, 2)
+	for i := range servers {
+		name := []string{"first", "second"}[i]
+		_, _, certPEM, keyPEM := newTestCertificate(suite.T(), &x509.Certificate{
+			Subject:               pkix.Name{CommonName: name},
+			IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}, nil, nil)
+		pair, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+		require.NoError(suite.T(), err)
+
+		servers[i] = httptest.NewUnstartedServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			_, _ = res.Write([]byte(name))
+		}))
+		servers[i].TLS = &tls.Config{Certificates: []tls.Certificate{pair}}
+		servers[i].StartTLS()
+		defer servers[i].Close()
+		caBundles[i] = certPEM
+	}
+
+	recorder, err := cassette.New(filepath.Join(suite.T().TempDir(), "connections.yaml"), cassette.Options{Mode: cassette.ModeRecord})
+	require.NoError(suite.T(), err)
+	clients := newClientCache(TransportConfig{Middleware: recorder.Wrap})
+
+	// each connection trusts only its own server, so a request sent with the transport of the other connection fails.
+	connections := []map[string]string{
+		{consts.CABundleKey: caBundles[0]},
+		{consts.CABundleKey: caBundles[1]},
+	}
+	for i := 0; i < 2; i++ {
+		for j, connection := range connections {
+			request, err := http.NewRequest(http.MethodGet, servers[j].URL, nil)
+			require.NoError(suite.T(), err)
+
+			result, err := executeRequestWithCredentials(connection, request, requestOptions{clients: clients, timeout: 5})
+			require.NoError(suite.T(), err)
+			assert.Equal(suite.T(), []string{"first", "second"}[j], string(result.Body))
+		}
+	}
+}
+
+func TestConnectionTransportSuite(t *testing.T) {
+	suite.Run(t, new(ConnectionTransportTestSuite))
+}
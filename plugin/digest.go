@@ -0,0 +1,448 @@
+package plugin
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	digestQopAuth    = "auth"
+	digestQopAuthInt = "auth-int"
+
+	digestSessionSuffix = "-sess"
+
+	// the nonces of connections that aren't used anymore are evicted, servers usually expire them by then.
+	digestNonceIdleTTL = 10 * time.Minute
+)
+
+// digestAlgorithms are the supported algorithms, the strongest one offered by the server is used.
+var digestAlgorithms = []struct {
+	name string
+	hash func() hash.Hash
+}{
+	{"SHA-512-256", sha512.New512_256},
+	{"SHA-256", sha256.New},
+	{"MD5", md5.New},
+}
+
+// digestConnectionKeys are the connection keys of Digest auth, they aren't sent as headers when Digest auth is used.
+var digestConnectionKeys = []string{consts.BasicAuthUsername, consts.BasicAuthPassword}
+
+// digestNonces caches the latest challenge of every protection space, so its nonce is reused by the next requests.
+// idle nonces are evicted.
+var digestNonces = &digestNonceCache{nonces: map[string]*digestNonce{}}
+
+type (
+	// digestAuthenticator answers the Digest challenges of RFC 7616 with the connection's USERNAME and PASSWORD.
+	// the first request of a connection is sent without credentials to get the challenge, later requests reuse its nonce.
+	digestAuthenticator struct {
+		username  string
+		password  string
+		cnonce    func() string
+		nonce     *digestNonce
+		sentNonce string // the nonce of the last request's credentials, empty when it was sent without credentials
+	}
+
+	digestChallenge struct {
+		realm     string
+		nonce     string
+		opaque    string
+		algorithm string // like MD5 or SHA-256-sess
+		qop       []string
+		stale     bool
+		userhash  bool
+	}
+
+	// digestNonce is the latest challenge of a connection and the number of requests sent with its nonce.
+	digestNonce struct {
+		mutex     sync.Mutex
+		challenge *digestChallenge
+		count     int
+		lastUsed  time.Time // guarded by the cache mutex
+	}
+
+	digestNonceCache struct {
+		mutex     sync.Mutex
+		nonces    map[string]*digestNonce
+		lastEvict time.Time
+	}
+)
+
+// newDigestAuthenticator returns the Digest authenticator of connections with a username and password,
+// when the plugin uses Digest auth or the connection's AUTH_TYPE is digest, nil otherwise.
+func newDigestAuthenticator(connection map[string]string, digestAuth bool) *digestAuthenticator {
+	authType := strings.TrimSpace(connectionValue(connection, consts.AuthTypeKey))
+	if authType != "" {
+		digestAuth = strings.EqualFold(authType, consts.AuthTypeDigest)
+	}
+
+	username, password := connectionValue(connection, consts.BasicAuthUsername), connectionValue(connection, consts.BasicAuthPassword)
+	if !digestAuth || username == "" || password == "" {
+		return nil
+	}
+
+	return &digestAuthenticator{username: username, password: password, cnonce: newDigestCnonce}
+}
+
+// authenticate sets the credentials of the connection's latest challenge, the request is sent without credentials before the first challenge.
+func (a *digestAuthenticator) authenticate(request *http.Request) error {
+	a.nonce = digestNonces.get(requestHost(request), a.username, a.password)
+	a.sentNonce = ""
+
+	challenge, count := a.nonce.next()
+	if challenge == nil {
+		request.Header.Del("Authorization")
+		return nil
+	}
+
+	authorization, err := a.authorization(request, challenge, count, a.cnonce())
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Authorization", authorization)
+	a.sentNonce = challenge.nonce
+	return nil
+}
+
+// reauthenticate keeps the challenge of 401 responses, the request is sent again when it had no credentials or its nonce was stale.
+func (a *digestAuthenticator) reauthenticate(request *http.Request, result Result) (bool, error) {
+	if result.StatusCode != http.StatusUnauthorized {
+		if nextNonce := parseAuthParams(result.Header.Get("Authentication-Info"))["nextnonce"]; nextNonce != "" {
+			a.nonce.replaceNonce(a.sentNonce, nextNonce)
+		}
+		return false, nil
+	}
+
+	challenge := selectDigestChallenge(result.Header.Values("WWW-Authenticate"))
+	if challenge == nil {
+		return false, nil
+	}
+
+	a.nonce.replace(a.sentNonce, challenge)
+
+	// a rejected request with a fresh nonce has wrong credentials, sending it again won't help.
+	if a.sentNonce != "" && !challenge.stale {
+		return false, nil
+	}
+
+	if a.sentNonce != "" {
+		log.Warnf("The Digest nonce was stale for %s %s, answering the new challenge", request.Method, request.URL.Path)
+	}
+	return true, nil
+}
+
+// authorization returns the Authorization header of the challenge, for the count'th request with its nonce.
+func (a *digestAuthenticator) authorization(request *http.Request, challenge *digestChallenge, count int, cnonce string) (string, error) {
+	algorithm := strings.TrimSuffix(strings.ToUpper(challenge.algorithm), strings.ToUpper(digestSessionSuffix))
+	newHash := digestHash(algorithm)
+	digest := func(values ...string) string {
+		h := newHash()
+		h.Write([]byte(strings.Join(values, ":")))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+
+	uri := request.URL.RequestURI()
+	ha1 := digest(a.username, challenge.realm, a.password)
+	if strings.HasSuffix(strings.ToLower(challenge.algorithm), digestSessionSuffix) {
+		ha1 = digest(ha1, challenge.nonce, cnonce)
+	}
+
+	qop, err := selectDigestQop(challenge.qop)
+	if err != nil {
+		return "", err
+	}
+
+	ha2 := digest(request.Method, uri)
+	if qop == digestQopAuthInt {
+		body, err := readRequestBody(request)
+		if err != nil {
+			return "", err
+		}
+		ha2 = digest(request.Method, uri, digest(string(body)))
+	}
+
+	nc := fmt.Sprintf("%08x", count)
+	response := digest(ha1, challenge.nonce, ha2)
+	if qop != "" {
+		response = digest(ha1, challenge.nonce, nc, cnonce, qop, ha2)
+	}
+
+	username := a.username
+	if challenge.userhash {
+		username = digest(a.username, challenge.realm)
+	}
+
+	params := []string{
+		"username=" + quoteAuthParam(username),
+		"realm=" + quoteAuthParam(challenge.realm),
+		"nonce=" + quoteAuthParam(challenge.nonce),
+		"uri=" + quoteAuthParam(uri),
+	}
+	if challenge.algorithm != "" {
+		params = append(params, "algorithm="+challenge.algorithm)
+	}
+	params = append(params, "response="+quoteAuthParam(response))
+	if challenge.opaque != "" {
+		params = append(params, "opaque="+quoteAuthParam(challenge.opaque))
+	}
+	if qop != "" {
+		params = append(params, "qop="+qop, "nc="+nc, "cnonce="+quoteAuthParam(cnonce))
+	}
+	if challenge.userhash {
+		params = append(params, "userhash=true")
+	}
+
+	return "Digest " + strings.Join(params, ", "), nil
+}
+
+// next returns the latest challenge and counts another request with its nonce.
+func (n *digestNonce) next() (*digestChallenge, int) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.challenge == nil {
+		return nil, 0
+	}
+
+	n.count++
+	return n.challenge, n.count
+}
+
+// replace keeps the challenge, unless another request already replaced the rejected nonce.
+func (n *digestNonce) replace(rejected string, challenge *digestChallenge) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.challenge != nil && n.challenge.nonce != rejected {
+		return
+	}
+
+	n.challenge, n.count = challenge, 0
+}
+
+// replaceNonce keeps the next nonce the server sent for the nonce of the request.
+func (n *digestNonce) replaceNonce(current string, nextNonce string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.challenge == nil || n.challenge.nonce != current {
+		return
+	}
+
+	challenge := *n.challenge
+	challenge.nonce = nextNonce
+	n.challenge, n.count = &challenge, 0
+}
+
+// get returns the nonce of the protection space, the same one for all the requests of the connection to the host.
+func (c *digestNonceCache) get(host string, username string, password string) *digestNonce {
+	key := connectionIdentity(map[string]string{"host": host, "username": username, "password": password})
+	now := time.Now()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if now.Sub(c.lastEvict) >= digestNonceIdleTTL {
+		c.evictIdle(now)
+	}
+
+	nonce, ok := c.nonces[key]
+	if !ok {
+		nonce = &digestNonce{}
+		c.nonces[key] = nonce
+	}
+	nonce.lastUsed = now
+
+	return nonce
+}
+
+// evictIdle removes the nonces that weren't used for digestNonceIdleTTL, so connections that are no longer used don't keep their nonces.
+// the caller must hold the lock.
+func (c *digestNonceCache) evictIdle(now time.Time) {
+	for key, nonce := range c.nonces {
+		if now.Sub(nonce.lastUsed) >= digestNonceIdleTTL {
+			delete(c.nonces, key)
+		}
+	}
+
+	c.lastEvict = now
+}
+
+// selectDigestChallenge returns the Digest challenge with the strongest supported algorithm, nil when there's none.
+func selectDigestChallenge(headers []string) *digestChallenge {
+	var (
+		selected *digestChallenge
+		strength = len(digestAlgorithms)
+	)
+
+	for _, header := range headers {
+		for _, challenge := range parseAuthChallenges(header) {
+			if !strings.EqualFold(challenge.scheme, "Digest") {
+				continue
+			}
+
+			algorithm := strings.TrimSuffix(strings.ToUpper(challenge.params["algorithm"]), strings.ToUpper(digestSessionSuffix))
+			if algorithm == "" {
+				algorithm = "MD5"
+			}
+
+			for i, supported := range digestAlgorithms {
+				if supported.name == algorithm && i < strength {
+					strength = i
+					selected = &digestChallenge{
+						realm:     challenge.params["realm"],
+						nonce:     challenge.params["nonce"],
+						opaque:    challenge.params["opaque"],
+						algorithm: challenge.params["algorithm"],
+						qop:       splitList(challenge.params["qop"]),
+						stale:     strings.EqualFold(challenge.params["stale"], "true"),
+						userhash:  strings.EqualFold(challenge.params["userhash"], "true"),
+					}
+				}
+			}
+		}
+	}
+
+	return selected
+}
+
+// selectDigestQop prefers auth, auth-int is used when it's the only one offered, and no qop for RFC 2069 servers.
+// servers that only offer unknown qops, like auth-conf, would reject both, so it returns an error.
+func selectDigestQop(offered []string) (string, error) {
+	switch {
+	case len(offered) == 0:
+		return "", nil
+	case containsFold(offered, digestQopAuth):
+		return digestQopAuth, nil
+	case containsFold(offered, digestQopAuthInt):
+		return digestQopAuthInt, nil
+	default:
+		return "", errors.Errorf("unsupported digest qop %s", strings.Join(offered, ", "))
+	}
+}
+
+func digestHash(algorithm string) func() hash.Hash {
+	for _, supported := range digestAlgorithms {
+		if supported.name == algorithm {
+			return supported.hash
+		}
+	}
+
+	return md5.New
+}
+
+func newDigestCnonce() string {
+	cnonce := make([]byte, 16)
+	if _, err := rand.Read(cnonce); err != nil {
+		log.Error(errors.Wrap(err, "failed to generate the Digest cnonce"))
+	}
+
+	return hex.EncodeToString(cnonce)
+}
+
+type authChallenge struct {
+	scheme string
+	params map[string]string
+}
+
+// parseAuthChallenges parses the challenges of a WWW-Authenticate header, a header can have several comma separated challenges.
+func parseAuthChallenges(header string) []authChallenge {
+	var challenges []authChallenge
+
+	for rest := strings.TrimSpace(header); rest != ""; {
+		var token string
+		token, rest = readAuthToken(rest)
+		rest = strings.TrimLeft(rest, " \t")
+
+		switch {
+		case token == "":
+			// skip the separators between the challenges and their params.
+			rest = strings.TrimLeft(rest[1:], " \t")
+		case strings.HasPrefix(rest, "=") && len(challenges) > 0:
+			var value string
+			value, rest = readAuthValue(strings.TrimLeft(rest[1:], " \t"))
+			challenges[len(challenges)-1].params[strings.ToLower(token)] = value
+		default:
+			challenges = append(challenges, authChallenge{scheme: token, params: map[string]string{}})
+		}
+	}
+
+	return challenges
+}
+
+// parseAuthParams parses comma separated auth params, like the Authentication-Info header.
+func parseAuthParams(header string) map[string]string {
+	challenges := parseAuthChallenges("params " + header)
+	if len(challenges) == 0 {
+		return nil
+	}
+
+	return challenges[0].params
+}
+
+// readAuthToken reads a scheme or a param name, up to a separator.
+func readAuthToken(value string) (string, string) {
+	end := strings.IndexAny(value, " \t,=\"")
+	if end == -1 {
+		return value, ""
+	}
+
+	return value[:end], value[end:]
+}
+
+// readAuthValue reads a token or a quoted string with escaped characters.
+func readAuthValue(value string) (string, string) {
+	if !strings.HasPrefix(value, `"`) {
+		end := strings.IndexAny(value, " \t,")
+		if end == -1 {
+			return value, ""
+		}
+		return value[:end], value[end:]
+	}
+
+	var unquoted strings.Builder
+	for i := 1; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			if i+1 < len(value) {
+				i++
+				unquoted.WriteByte(value[i])
+			}
+		case '"':
+			return unquoted.String(), value[i+1:]
+		default:
+			unquoted.WriteByte(value[i])
+		}
+	}
+
+	return unquoted.String(), ""
+}
+
+func quoteAuthParam(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
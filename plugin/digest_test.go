@@ -0,0 +1,281 @@
+package plugin
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type DigestTestSuite struct {
+	suite.Suite
+	server *httptest.Server
+
+	mutex     sync.Mutex
+	algorithm string
+	qop       string
+	nonce     string
+	nonces    int
+	counts    []int  // the nc of every authorized request
+	requests  int    // all the requests, including the challenged ones
+	body      string // the body of the last authorized request
+}
+
+func (suite *DigestTestSuite) SetupTest() {
+	suite.algorithm, suite.qop = "MD5", digestQopAuth
+	suite.nonce, suite.nonces, suite.counts, suite.requests, suite.body = "", 0, nil, 0, ""
+
+	suite.server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		suite.mutex.Lock()
+		defer suite.mutex.Unlock()
+		suite.requests++
+		body, _ := ioutil.ReadAll(req.Body)
+
+		authorization := req.Header.Get("Authorization")
+		if !strings.HasPrefix(authorization, "Digest ") {
+			suite.challenge(res, false)
+			return
+		}
+
+		params := parseAuthChallenges(authorization)[0].params
+		if params["nonce"] != suite.nonce {
+			suite.challenge(res, true)
+			return
+		}
+
+		newHash := md5.New
+		if suite.algorithm == "SHA-256" {
+			newHash = sha256.New
+		}
+
+		ha1 := hexHash(newHash, "jane:appliance:secret")
+		ha2 := hexHash(newHash, req.Method+":"+req.URL.RequestURI())
+		if suite.qop == digestQopAuthInt {
+			ha2 = hexHash(newHash, req.Method+":"+req.URL.RequestURI()+":"+hexHash(newHash, string(body)))
+		}
+		expected := hexHash(newHash, strings.Join([]string{ha1, suite.nonce, params["nc"], params["cnonce"], params["qop"], ha2}, ":"))
+
+		if params["username"] != "jane" || params["uri"] != req.URL.RequestURI() || params["opaque"] != "opaque-value" || params["response"] != expected {
+			suite.challenge(res, false)
+			return
+		}
+
+		count, _ := strconv.ParseInt(params["nc"], 16, 64)
+		suite.counts = append(suite.counts, int(count))
+		suite.body = string(body)
+		_, _ = res.Write([]byte(`{"ok": true}`))
+	}))
+}
+
+// challenge sends a new nonce, the previous one isn't accepted anymore.
+func (suite *DigestTestSuite) challenge(res http.ResponseWriter, stale bool) {
+	suite.nonces++
+	suite.nonce = fmt.Sprintf("nonce-%d", suite.nonces)
+
+	res.Header().Add("WWW-Authenticate", `Basic realm="appliance"`)
+	res.Header().Add("WWW-Authenticate", fmt.Sprintf(`Digest realm="appliance", qop="%s", algorithm=%s, nonce="%s", opaque="opaque-value", stale=%t`, suite.qop, suite.algorithm, suite.nonce, stale))
+	res.WriteHeader(http.StatusUnauthorized)
+}
+
+func (suite *DigestTestSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+func (suite *DigestTestSuite) send(connection map[string]string, method string, body string, digestAuth bool) Result {
+	request, err := http.NewRequest(method, suite.server.URL+"/api/status?verbose=true", strings.NewReader(body))
+	require.NoError(suite.T(), err)
+
+	result, err := executeRequestWithCredentials(connection, request, requestOptions{timeout: 5, digestAuth: digestAuth})
+	require.NoError(suite.T(), err)
+
+	return result
+}
+
+func (suite *DigestTestSuite) TestRFCExamples() {
+	examples := []struct {
+		password  string
+		challenge *digestChallenge
+		nonce     string
+		cnonce    string
+		response  string
+	}{
+		// RFC 2617 section 3.5
+		{
+			password:  "Circle Of Life",
+			challenge: &digestChallenge{realm: "testrealm@host.com", nonce: "dcd98b7102dd2f0e8b11d0f600bfb0c093", opaque: "5ccc069c403ebaf9f0171e9517f40e41", qop: []string{"auth", "auth-int"}},
+			cnonce:    "0a4f113b",
+			response:  "6629fae49393a05397450978507c4ef1",
+		},
+		// RFC 7616 section 3.9.1
+		{
+			password:  "Circle of Life",
+			challenge: &digestChallenge{realm: "http-auth@example.org", nonce: "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", opaque: "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS", algorithm: "MD5", qop: []string{"auth"}},
+			cnonce:    "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ",
+			response:  "8ca523f5e9506fed4657c9700eebdbec",
+		},
+		{
+			password:  "Circle of Life",
+			challenge: &digestChallenge{realm: "http-auth@example.org", nonce: "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", opaque: "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS", algorithm: "SHA-256", qop: []string{"auth"}},
+			cnonce:    "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ",
+			response:  "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1",
+		},
+	}
+
+	for _, example := range examples {
+		request, err := http.NewRequest(http.MethodGet, "http://www.example.org/dir/index.html", nil)
+		require.NoError(suite.T(), err)
+
+		auth := &digestAuthenticator{username: "Mufasa", password: example.password}
+		authorization, err := auth.authorization(request, example.challenge, 1, example.cnonce)
+		require.NoError(suite.T(), err)
+
+		assert.True(suite.T(), strings.HasPrefix(authorization, "Digest "), authorization)
+		params := parseAuthChallenges(authorization)[0].params
+		assert.Equal(suite.T(), example.response, params["response"], example.challenge.algorithm)
+		assert.Equal(suite.T(), "Mufasa", params["username"])
+		assert.Equal(suite.T(), "/dir/index.html", params["uri"])
+		assert.Equal(suite.T(), "00000001", params["nc"])
+		assert.Equal(suite.T(), digestQopAuth, params["qop"])
+		assert.Equal(suite.T(), example.challenge.opaque, params["opaque"])
+	}
+}
+
+func (suite *DigestTestSuite) TestParseChallenges() {
+	headers := []string{
+		`Newauth realm="apps", type=1, title="Login to \"apps\"", Basic realm="simple"`,
+		`Digest realm="a, b", nonce="n1", qop="auth,auth-int", algorithm=MD5, Digest realm="a, b", nonce="n2", qop="auth", algorithm=SHA-256, userhash=true`,
+	}
+
+	challenges := parseAuthChallenges(headers[0])
+	require.Len(suite.T(), challenges, 2)
+	assert.Equal(suite.T(), "Newauth", challenges[0].scheme)
+	assert.Equal(suite.T(), map[string]string{"realm": "apps", "type": "1", "title": `Login to "apps"`}, challenges[0].params)
+	assert.Equal(suite.T(), authChallenge{scheme: "Basic", params: map[string]string{"realm": "simple"}}, challenges[1])
+
+	// the strongest algorithm is selected.
+	challenge := selectDigestChallenge(headers)
+	require.NotNil(suite.T(), challenge)
+	assert.Equal(suite.T(), &digestChallenge{realm: "a, b", nonce: "n2", algorithm: "SHA-256", qop: []string{"auth"}, userhash: true}, challenge)
+
+	assert.Nil(suite.T(), selectDigestChallenge(headers[:1]))
+	assert.Nil(suite.T(), selectDigestChallenge([]string{`Digest realm="x", nonce="n", algorithm=SHA-1`}))
+
+	for offered, expected := range map[string]string{"auth-int,auth": digestQopAuth, "auth-int": digestQopAuthInt, "": ""} {
+		qop, err := selectDigestQop(splitList(offered))
+		require.NoError(suite.T(), err, offered)
+		assert.Equal(suite.T(), expected, qop, offered)
+	}
+	_, err := selectDigestQop([]string{"auth-conf"})
+	assert.Error(suite.T(), err)
+	assert.Equal(suite.T(), map[string]string{"nextnonce": "n3", "qop": "auth"}, parseAuthParams(`nextnonce="n3", qop=auth`))
+}
+
+func (suite *DigestTestSuite) TestNonceReuse() {
+	suite.algorithm = "SHA-256"
+	connection := map[string]string{consts.BasicAuthUsername: "jane", consts.BasicAuthPassword: "secret", consts.AuthTypeKey: "Digest"}
+
+	// only the first request is challenged, the nonce is counted by the next requests.
+	for i := 0; i < 3; i++ {
+		result := suite.send(connection, http.MethodGet, "", false)
+		require.Equal(suite.T(), http.StatusOK, result.StatusCode, string(result.Body))
+	}
+	assert.Equal(suite.T(), []int{1, 2, 3}, suite.counts)
+	assert.Equal(suite.T(), 4, suite.requests)
+
+	// a stale nonce is answered with the new one.
+	suite.mutex.Lock()
+	suite.nonce = "expired"
+	suite.mutex.Unlock()
+
+	result := suite.send(connection, http.MethodGet, "", false)
+	require.Equal(suite.T(), http.StatusOK, result.StatusCode)
+	assert.Equal(suite.T(), []int{1, 2, 3, 1}, suite.counts)
+	assert.Equal(suite.T(), 6, suite.requests)
+}
+
+func (suite *DigestTestSuite) TestAuthInt() {
+	suite.qop = digestQopAuthInt
+	connection := map[string]string{consts.BasicAuthUsername: "jane", consts.BasicAuthPassword: "secret", "X-Appliance": "rack-1"}
+
+	result := suite.send(connection, http.MethodPost, `{"reboot": true}`, true)
+	require.Equal(suite.T(), http.StatusOK, result.StatusCode, string(result.Body))
+	assert.Equal(suite.T(), `{"reboot": true}`, suite.body)
+	assert.Equal(suite.T(), []int{1}, suite.counts)
+}
+
+func (suite *DigestTestSuite) TestWrongPassword() {
+	connection := map[string]string{consts.BasicAuthUsername: "jane", consts.BasicAuthPassword: "wrong"}
+
+	// the request is answered once, a fresh nonce means the credentials are wrong.
+	result := suite.send(connection, http.MethodGet, "", true)
+	assert.Equal(suite.T(), http.StatusUnauthorized, result.StatusCode)
+	assert.Equal(suite.T(), 2, suite.requests)
+	assert.Empty(suite.T(), suite.counts)
+}
+
+func (suite *DigestTestSuite) TestSelection() {
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		header = req.Header.Clone()
+	}))
+	defer server.Close()
+
+	send := func(connection map[string]string, digestAuth bool) {
+		request, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		require.NoError(suite.T(), err)
+		_, err = executeRequestWithCredentials(connection, request, requestOptions{timeout: 5, digestAuth: digestAuth})
+		require.NoError(suite.T(), err)
+	}
+
+	// Basic auth is used by default and when the connection overrides the plugin.
+	send(map[string]string{consts.BasicAuthUsername: "jane", consts.BasicAuthPassword: "secret"}, false)
+	assert.Equal(suite.T(), constructBasicAuthHeader("jane", "secret"), header.Get("Authorization"))
+
+	send(map[string]string{consts.BasicAuthUsername: "jane", consts.BasicAuthPassword: "secret", consts.AuthTypeKey: "basic"}, true)
+	assert.Equal(suite.T(), constructBasicAuthHeader("jane", "secret"), header.Get("Authorization"))
+	assert.Empty(suite.T(), header.Get(consts.AuthTypeKey))
+
+	// without a challenge the Digest request is sent without credentials.
+	send(map[string]string{consts.BasicAuthUsername: "jane", consts.BasicAuthPassword: "secret", consts.AuthTypeKey: consts.AuthTypeDigest}, false)
+	assert.Empty(suite.T(), header.Get("Authorization"))
+	assert.Empty(suite.T(), header.Get(consts.BasicAuthUsername))
+	assert.Empty(suite.T(), header.Get(consts.AuthTypeKey))
+}
+
+func hexHash(newHash func() hash.Hash, value string) string {
+	h := newHash()
+	h.Write([]byte(value))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (suite *DigestTestSuite) TestEvictIdleNonces() {
+	nonces := &digestNonceCache{nonces: map[string]*digestNonce{}}
+	idle := nonces.get("idle.example.com", "Mufasa", "Circle of Life")
+	active := nonces.get("active.example.com", "Mufasa", "Circle of Life")
+
+	now := time.Now()
+	idle.lastUsed = now.Add(-digestNonceIdleTTL)
+	nonces.lastEvict = now.Add(-digestNonceIdleTTL)
+	nonces.get("other.example.com", "Mufasa", "Circle of Life")
+	assert.Len(suite.T(), nonces.nonces, 2)
+	assert.NotSame(suite.T(), idle, nonces.get("idle.example.com", "Mufasa", "Circle of Life"))
+	assert.Same(suite.T(), active, nonces.get("active.example.com", "Mufasa", "Circle of Life"))
+}
+
+func TestDigestSuite(t *testing.T) {
+	suite.Run(t, new(DigestTestSuite))
+}
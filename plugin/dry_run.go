@@ -0,0 +1,156 @@
+package plugin
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	"github.com/blinkops/blink-sdk/plugin"
+	"github.com/pkg/errors"
+)
+
+const (
+	base64BodyEncoding = "base64"
+	authNotPerformed   = " (not performed)"
+)
+
+// DryRunRequest is the result of dry run actions, the request that would have been sent.
+type DryRunRequest struct {
+	Method       string      `json:"method"`
+	URL          string      `json:"url"`
+	Headers      http.Header `json:"headers"` // secrets are redacted
+	Body         string      `json:"body,omitempty"`
+	BodyEncoding string      `json:"body_encoding,omitempty"` // base64 for binary bodies
+	Auth         string      `json:"auth,omitempty"`          // the auth strategy that sends requests of its own, like "oauth2 (not performed)"
+}
+
+// isDryRunContext returns whether the action context asks for a dry run.
+func isDryRunContext(actionContext *plugin.ActionContext) bool {
+	if actionContext == nil {
+		return false
+	}
+
+	switch value := actionContext.GetContextEntry(consts.DryRunKey).(type) {
+	case bool:
+		return value
+	case string:
+		dryRun, _ := strconv.ParseBool(value)
+		return dryRun
+	default:
+		return false
+	}
+}
+
+// popDryRunParam returns the request without the reserved dry run param, and whether it asked for a dry run.
+// the request itself isn't modified.
+func popDryRunParam(request *plugin.ExecuteActionRequest) (*plugin.ExecuteActionRequest, bool) {
+	value, ok := request.Parameters[consts.DryRunKey]
+	if !ok {
+		return request, false
+	}
+
+	parameters := make(map[string]string, len(request.Parameters)-1)
+	for name, paramValue := range request.Parameters {
+		if name != consts.DryRunKey {
+			parameters[name] = paramValue
+		}
+	}
+
+	requestCopy := *request
+	requestCopy.Parameters = parameters
+
+	dryRun, _ := strconv.ParseBool(value)
+	return &requestCopy, dryRun
+}
+
+// withDryRunParam returns a copy of the request with the reserved dry run param set.
+func withDryRunParam(request *plugin.ExecuteActionRequest) *plugin.ExecuteActionRequest {
+	parameters := make(map[string]string, len(request.Parameters)+1)
+	for name, value := range request.Parameters {
+		parameters[name] = value
+	}
+	parameters[consts.DryRunKey] = strconv.FormatBool(true)
+
+	requestCopy := *request
+	requestCopy.Parameters = parameters
+	return &requestCopy
+}
+
+// describeRequest returns the JSON description of a request that is ready to be sent.
+// requests of local signers are signed like they would be, the strategies that send requests of their own aren't performed.
+func describeRequest(request *http.Request, auth authenticator, redact redactor) (Result, error) {
+	// the body is read before signing, so the signers can read it again.
+	body, err := readRequestBody(request)
+	if err != nil {
+		return Result{}, err
+	}
+
+	dryRunRequest := DryRunRequest{Method: request.Method}
+	switch auth.(type) {
+	case nil:
+	case *sigV4Signer, *hmacSigner:
+		if err = auth.authenticate(request); err != nil {
+			return Result{}, &authError{err: err}
+		}
+	default:
+		dryRunRequest.Auth = authStrategy(auth) + authNotPerformed
+	}
+
+	dryRunRequest.URL = redact.url(request.URL)
+	dryRunRequest.Headers = redact.header(request.Header)
+
+	if utf8.Valid(body) {
+		dryRunRequest.Body = redact.value(string(body))
+	} else {
+		dryRunRequest.Body = base64.StdEncoding.EncodeToString(body)
+		dryRunRequest.BodyEncoding = base64BodyEncoding
+	}
+
+	description, err := json.Marshal(dryRunRequest)
+	if err != nil {
+		return Result{}, err
+	}
+
+	header := http.Header{}
+	header.Set(consts.ContentTypeHeader, consts.RequestBodyType)
+	return Result{StatusCode: http.StatusOK, Body: description, Header: header}, nil
+}
+
+// authStrategy returns the name of the auth strategy of the authenticator.
+func authStrategy(auth authenticator) string {
+	switch auth.(type) {
+	case *oauth2Authenticator:
+		return "oauth2"
+	case *jwtAuthenticator:
+		return "jwt"
+	case *sessionAuthenticator:
+		return "session"
+	case *digestAuthenticator:
+		return "digest"
+	default:
+		return "custom"
+	}
+}
+
+// readRequestBody reads the body without consuming it.
+func readRequestBody(request *http.Request) ([]byte, error) {
+	if request.Body == nil || request.Body == http.NoBody {
+		return nil, nil
+	}
+
+	if err := makeBodyRewindable(request); err != nil {
+		return nil, err
+	}
+
+	body, err := request.GetBody()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read request body")
+	}
+	defer body.Close()
+
+	return ioutil.ReadAll(body)
+}
@@ -0,0 +1,188 @@
+package plugin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	plugin_sdk "github.com/blinkops/blink-sdk/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	ticketsOpenApi = `
+openapi: 3.0.0
+info:
+  title: tickets
+  version: 1.0.0
+servers:
+  - url: https://api.example.com
+paths:
+  /projects/{project}/tickets:
+    post:
+      operationId: CreateTicket
+      parameters:
+        - name: project
+          in: path
+          required: true
+          schema:
+            type: string
+        - name: notify
+          in: query
+          schema:
+            type: boolean
+      requestBody:
+        content:
+          application/json:
+            schema:
+              type: object
+              properties:
+                title:
+                  type: string
+      responses:
+        "201":
+          description: created
+`
+
+	ticketsMask = `
+actions:
+  CreateTicket:
+    alias: Create Ticket
+    parameters:
+      project:
+        alias: Project
+      title:
+        alias: Title
+`
+)
+
+type DryRunTestSuite struct {
+	suite.Suite
+	plugin   *openApiPlugin
+	server   *httptest.Server
+	requests int32
+}
+
+func (suite *DryRunTestSuite) SetupSuite() {
+	suite.server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&suite.requests, 1)
+		res.WriteHeader(http.StatusCreated)
+	}))
+
+	dir := suite.T().TempDir()
+	openApiFile := filepath.Join(dir, "tickets-openapi.yaml")
+	maskFile := filepath.Join(dir, "tickets-mask.yaml")
+	require.NoError(suite.T(), ioutil.WriteFile(openApiFile, []byte(ticketsOpenApi), 0600))
+	require.NoError(suite.T(), ioutil.WriteFile(maskFile, []byte(ticketsMask), 0600))
+
+	var err error
+	suite.plugin, err = NewOpenApiPlugin(nil, PluginMetadata{
+		Name:                "tickets",
+		Provider:            "tickets",
+		OpenApiFile:         openApiFile,
+		MaskFile:            maskFile,
+		HeaderAlias:         HeaderAlias{"TOKEN": "AUTHORIZATION"},
+		HeaderValuePrefixes: HeaderValuePrefixes{"AUTHORIZATION": consts.BearerAuth},
+	}, Callbacks{})
+	require.NoError(suite.T(), err)
+}
+
+func (suite *DryRunTestSuite) TearDownSuite() {
+	suite.server.Close()
+}
+
+func (suite *DryRunTestSuite) TestDryRun() {
+	connection := map[string]string{consts.RequestUrlKey: suite.server.URL, "Token": "secret-token", "X-Tenant-Secret": "tenant-secret"}
+	parameters := map[string]string{"Project": "core", "notify": "true", "Title": "Broken build", consts.DryRunKey: "true"}
+
+	res := suite.plugin.executeActionWithCredentials(connection, &plugin_sdk.ExecuteActionRequest{Name: "Create Ticket", Parameters: parameters})
+	require.Equal(suite.T(), int64(consts.OK), res.ErrorCode, string(res.Result))
+	assert.Equal(suite.T(), int32(0), atomic.LoadInt32(&suite.requests))
+
+	var dryRunRequest DryRunRequest
+	require.NoError(suite.T(), json.Unmarshal(res.Result, &dryRunRequest))
+	assert.Equal(suite.T(), http.MethodPost, dryRunRequest.Method)
+	assert.Equal(suite.T(), suite.server.URL+"/projects/core/tickets?notify=true", dryRunRequest.URL)
+	assert.Equal(suite.T(), []string{redactedValue}, dryRunRequest.Headers["Authorization"])
+	assert.Equal(suite.T(), []string{redactedValue}, dryRunRequest.Headers["X-Tenant-Secret"])
+	assert.Equal(suite.T(), []string{consts.RequestBodyType}, dryRunRequest.Headers[consts.ContentTypeHeader])
+	assert.JSONEq(suite.T(), `{"title": "Broken build"}`, dryRunRequest.Body)
+	assert.NotContains(suite.T(), string(res.Result), "secret-token")
+
+	// the caller's parameters are left as is.
+	assert.Contains(suite.T(), parameters, consts.DryRunKey)
+
+	delete(parameters, consts.DryRunKey)
+	res = suite.plugin.executeActionWithCredentials(connection, &plugin_sdk.ExecuteActionRequest{Name: "Create Ticket", Parameters: parameters})
+	assert.Equal(suite.T(), int64(consts.OK), res.ErrorCode, string(res.Result))
+	assert.Equal(suite.T(), int32(1), atomic.LoadInt32(&suite.requests))
+}
+
+func (suite *DryRunTestSuite) TestDryRunAuth() {
+	parameters := map[string]string{"Project": "core", "Title": "Broken build", consts.DryRunKey: "true"}
+	requests := atomic.LoadInt32(&suite.requests)
+	dryRun := func(connection map[string]string) DryRunRequest {
+		connection[consts.RequestUrlKey] = suite.server.URL
+		res := suite.plugin.executeActionWithCredentials(connection, &plugin_sdk.ExecuteActionRequest{Name: "Create Ticket", Parameters: parameters})
+		require.Equal(suite.T(), int64(consts.OK), res.ErrorCode, string(res.Result))
+		assert.NotContains(suite.T(), string(res.Result), "secret")
+
+		var dryRunRequest DryRunRequest
+		require.NoError(suite.T(), json.Unmarshal(res.Result, &dryRunRequest))
+		return dryRunRequest
+	}
+
+	// local signers sign the request, their signature is redacted.
+	signed := dryRun(map[string]string{
+		consts.AwsAccessKeyIdKey:     "AKIDEXAMPLE",
+		consts.AwsSecretAccessKeyKey: "secret-access-key",
+		consts.AwsRegionKey:          "us-east-1",
+		consts.AwsServiceKey:         "tickets",
+	})
+	assert.Equal(suite.T(), []string{redactedValue}, signed.Headers["Authorization"])
+	assert.NotEmpty(suite.T(), signed.Headers.Get(amzDateHeader))
+	assert.Empty(suite.T(), signed.Auth)
+
+	// strategies that send requests of their own aren't performed.
+	oauth2 := dryRun(map[string]string{
+		consts.TokenUrlKey:     suite.server.URL + "/token",
+		consts.ClientIdKey:     "client",
+		consts.ClientSecretKey: "client-secret",
+	})
+	assert.Equal(suite.T(), "oauth2 (not performed)", oauth2.Auth)
+	assert.Empty(suite.T(), oauth2.Headers["Authorization"])
+	assert.Equal(suite.T(), requests, atomic.LoadInt32(&suite.requests))
+}
+
+func (suite *DryRunTestSuite) TestInvalidRequestFailsDryRun() {
+	res := suite.plugin.executeActionWithCredentials(map[string]string{consts.RequestUrlKey: suite.server.URL}, &plugin_sdk.ExecuteActionRequest{
+		Name:       "Create Ticket",
+		Parameters: map[string]string{"Project": "core", "notify": "maybe", consts.DryRunKey: "true"},
+	})
+
+	assert.Equal(suite.T(), int64(consts.Error), res.ErrorCode)
+	assert.Equal(suite.T(), ErrorCategoryValidation, decodeActionError(suite.T(), res.Result).Category)
+}
+
+func (suite *DryRunTestSuite) TestDryRunContext() {
+	assert.True(suite.T(), isDryRunContext(plugin_sdk.NewActionContext(map[string]interface{}{consts.DryRunKey: true}, nil)))
+	assert.True(suite.T(), isDryRunContext(plugin_sdk.NewActionContext(map[string]interface{}{consts.DryRunKey: "true"}, nil)))
+	assert.False(suite.T(), isDryRunContext(plugin_sdk.NewActionContext(map[string]interface{}{consts.DryRunKey: "no"}, nil)))
+	assert.False(suite.T(), isDryRunContext(plugin_sdk.NewActionContext(map[string]interface{}{}, nil)))
+
+	request := &plugin_sdk.ExecuteActionRequest{Name: "Create Ticket", Parameters: map[string]string{"Project": "core"}}
+	_, dryRun := popDryRunParam(withDryRunParam(request))
+	assert.True(suite.T(), dryRun)
+	assert.NotContains(suite.T(), request.Parameters, consts.DryRunKey)
+}
+
+func TestDryRunSuite(t *testing.T) {
+	suite.Run(t, new(DryRunTestSuite))
+}
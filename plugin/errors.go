@@ -0,0 +1,207 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	"github.com/blinkops/blink-sdk/plugin"
+	"github.com/pkg/errors"
+)
+
+// ErrorCategory tells workflows why an action failed, so they can react to auth failures differently than to timeouts.
+type ErrorCategory string
+
+const (
+	ErrorCategoryAuth        ErrorCategory = "auth"         // 401 and 403
+	ErrorCategoryNotFound    ErrorCategory = "not_found"    // 404 and 410
+	ErrorCategoryRateLimited ErrorCategory = "rate_limited" // 429
+	ErrorCategoryValidation  ErrorCategory = "validation"   // invalid action parameters, 400 and 422
+	ErrorCategoryTimeout     ErrorCategory = "timeout"      // the action timed out, 408 and 504
+	ErrorCategoryNetwork     ErrorCategory = "network"      // the provider couldn't be reached
+	ErrorCategoryServer      ErrorCategory = "server"       // 5xx and responses that don't match the spec
+	ErrorCategoryClient      ErrorCategory = "client"       // any other failure
+)
+
+// maxProviderMessageLength caps provider messages taken from non JSON error bodies, like html error pages.
+const maxProviderMessageLength = 500
+
+var (
+	// requestIDHeaders are the headers providers use to identify a request in their logs, in order of preference.
+	requestIDHeaders = []string{
+		"X-Request-Id",
+		"X-Amzn-RequestId",
+		"X-Amz-Request-Id",
+		"X-Github-Request-Id",
+		"X-Correlation-Id",
+		"Request-Id",
+		"Cf-Ray",
+	}
+
+	// providerMessageKeys are the keys of the message in common error body shapes, like {"message": ...},
+	// {"error": {"message": ...}}, {"errors": [{"message": ...}]} and {"error": "...", "error_description": ...}.
+	providerMessageKeys = []string{"message", "error_description", "error", "errors", "detail", "title", "description", "msg", "reason"}
+)
+
+// ActionError is the result of failed actions.
+type ActionError struct {
+	Category        ErrorCategory `json:"category"`
+	Message         string        `json:"message"`
+	Details         []string      `json:"details,omitempty"` // the invalid parameters of validation errors
+	StatusCode      int           `json:"status_code,omitempty"`
+	ProviderMessage string        `json:"provider_message,omitempty"` // the message extracted from the provider's error body
+	RequestID       string        `json:"request_id,omitempty"`
+	Body            string        `json:"body,omitempty"` // the provider's error body
+}
+
+func (e *ActionError) Error() string {
+	return e.Message
+}
+
+// authError is a failure of the connection's auth strategy, like a token, JWT or login request that failed.
+type authError struct {
+	err error
+}
+
+func (e *authError) Error() string {
+	return e.err.Error()
+}
+
+func (e *authError) Unwrap() error {
+	return e.err
+}
+
+// transportError is a failure of the connection's proxy or TLS settings.
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transportError) Unwrap() error {
+	return e.err
+}
+
+// response returns the action response of the error, its result is the JSON encoded error.
+func (e *ActionError) response() *plugin.ExecuteActionResponse {
+	result, err := json.Marshal(e)
+	if err != nil {
+		result = []byte(e.Message)
+	}
+
+	return &plugin.ExecuteActionResponse{ErrorCode: consts.Error, Result: result}
+}
+
+// newActionError classifies an error returned before a response was received, or while reading it.
+// the category is used for errors that aren't recognized.
+func newActionError(err error, category ErrorCategory) *ActionError {
+	var (
+		actionErr     *ActionError
+		parametersErr *ParametersError
+		tooLargeErr   *ResponseTooLargeError
+		authErr       *authError
+		transportErr  *transportError
+		netErr        net.Error
+	)
+
+	switch {
+	case errors.As(err, &actionErr):
+		return actionErr
+	case errors.As(err, &parametersErr):
+		return &ActionError{Category: ErrorCategoryValidation, Message: err.Error(), Details: parametersErr.Problems}
+	case errors.As(err, &tooLargeErr):
+		return &ActionError{Category: ErrorCategoryServer, Message: err.Error()}
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return &ActionError{Category: ErrorCategoryTimeout, Message: err.Error()}
+	case errors.As(err, &authErr):
+		return &ActionError{Category: ErrorCategoryAuth, Message: err.Error()}
+	case errors.As(err, &transportErr), errors.As(err, &netErr):
+		return &ActionError{Category: ErrorCategoryNetwork, Message: err.Error()}
+	default:
+		return &ActionError{Category: category, Message: err.Error()}
+	}
+}
+
+// newStatusError classifies a failed response by its status, with the provider's message and request id.
+func newStatusError(result Result, body []byte) *ActionError {
+	return &ActionError{
+		Category:        statusErrorCategory(result.StatusCode),
+		Message:         fmt.Sprintf("The request failed with status %d %s", result.StatusCode, http.StatusText(result.StatusCode)),
+		StatusCode:      result.StatusCode,
+		ProviderMessage: providerMessage(result.Body),
+		RequestID:       requestID(result.Header),
+		Body:            string(body),
+	}
+}
+
+func statusErrorCategory(statusCode int) ErrorCategory {
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return ErrorCategoryAuth
+	case statusCode == http.StatusNotFound || statusCode == http.StatusGone:
+		return ErrorCategoryNotFound
+	case statusCode == http.StatusTooManyRequests:
+		return ErrorCategoryRateLimited
+	case statusCode == http.StatusBadRequest || statusCode == http.StatusUnprocessableEntity:
+		return ErrorCategoryValidation
+	case statusCode == http.StatusRequestTimeout || statusCode == http.StatusGatewayTimeout:
+		return ErrorCategoryTimeout
+	case statusCode >= 500:
+		return ErrorCategoryServer
+	default:
+		return ErrorCategoryClient
+	}
+}
+
+// providerMessage extracts the error message from common JSON error bodies, short text bodies are returned as is.
+func providerMessage(body []byte) string {
+	var decoded interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		message := strings.TrimSpace(string(body))
+		if len(message) > maxProviderMessageLength || strings.HasPrefix(message, "<") {
+			return ""
+		}
+		return message
+	}
+
+	return findProviderMessage(decoded)
+}
+
+func findProviderMessage(value interface{}) string {
+	switch typedValue := value.(type) {
+	case string:
+		return typedValue
+	case []interface{}:
+		var messages []string
+		for _, item := range typedValue {
+			if message := findProviderMessage(item); message != "" {
+				messages = append(messages, message)
+			}
+		}
+		return strings.Join(messages, "; ")
+	case map[string]interface{}:
+		for _, key := range providerMessageKeys {
+			if message := findProviderMessage(typedValue[key]); message != "" {
+				return message
+			}
+		}
+	}
+
+	return ""
+}
+
+func requestID(header http.Header) string {
+	for _, name := range requestIDHeaders {
+		if id := header.Get(name); id != "" {
+			return id
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,200 @@
+package plugin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	plugin_sdk "github.com/blinkops/blink-sdk/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+func decodeActionError(t *testing.T, result []byte) ActionError {
+	var actionErr ActionError
+	require.NoError(t, json.Unmarshal(result, &actionErr), string(result))
+	return actionErr
+}
+
+type ErrorsTestSuite struct {
+	suite.Suite
+	server      *httptest.Server
+	openApiFile string
+}
+
+func (suite *ErrorsTestSuite) SetupSuite() {
+	suite.server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch filepath.Base(req.URL.Path) {
+		case "unauthorized":
+			res.Header().Set(consts.ContentTypeHeader, "application/json")
+			res.Header().Set("X-Request-Id", "req-1")
+			res.WriteHeader(http.StatusUnauthorized)
+			_, _ = res.Write([]byte(`{"error": {"code": 401, "message": "Bad credentials"}}`))
+		case "missing":
+			res.Header().Set(consts.ContentTypeHeader, "application/json")
+			res.WriteHeader(http.StatusNotFound)
+			_, _ = res.Write([]byte(`{"errors": [{"message": "Not Found"}]}`))
+		case "slow":
+			time.Sleep(2 * time.Second)
+		case "large":
+			_, _ = res.Write([]byte(`{"rows": [1, 2, 3, 4, 5, 6, 7, 8, 9]}`))
+		case "token":
+			_, _ = res.Write([]byte("<html>Sign in</html>"))
+		}
+	}))
+
+	suite.openApiFile = filepath.Join(suite.T().TempDir(), "exports-openapi.yaml")
+	require.NoError(suite.T(), ioutil.WriteFile(suite.openApiFile, []byte(exportsOpenApi), 0600))
+}
+
+func (suite *ErrorsTestSuite) TearDownSuite() {
+	suite.server.Close()
+}
+
+func (suite *ErrorsTestSuite) execute(requestUrl string, callbacks Callbacks, request *plugin_sdk.ExecuteActionRequest) *plugin_sdk.ExecuteActionResponse {
+	return suite.executeWith(PluginMetadata{}, map[string]string{consts.RequestUrlKey: requestUrl}, callbacks, request)
+}
+
+func (suite *ErrorsTestSuite) executeWith(meta PluginMetadata, connection map[string]string, callbacks Callbacks, request *plugin_sdk.ExecuteActionRequest) *plugin_sdk.ExecuteActionResponse {
+	meta.Name, meta.Provider, meta.OpenApiFile = "exports", "exports", suite.openApiFile
+	p, err := NewOpenApiPlugin(nil, meta, callbacks)
+	require.NoError(suite.T(), err)
+
+	return p.executeActionWithCredentials(connection, request)
+}
+
+func (suite *ErrorsTestSuite) TestStatusErrors() {
+	var callbackResult Result
+	callbacks := Callbacks{ValidateResponse: func(result Result) (bool, []byte) {
+		callbackResult = result
+		return validateDefault(result)
+	}}
+
+	res := suite.execute(suite.server.URL, callbacks, &plugin_sdk.ExecuteActionRequest{Name: "GetExport", Parameters: map[string]string{"export": "unauthorized"}})
+	assert.Equal(suite.T(), int64(consts.Error), res.ErrorCode)
+	assert.Equal(suite.T(), ActionError{
+		Category:        ErrorCategoryAuth,
+		Message:         "The request failed with status 401 Unauthorized",
+		StatusCode:      http.StatusUnauthorized,
+		ProviderMessage: "Bad credentials",
+		RequestID:       "req-1",
+		Body:            `{"error": {"code": 401, "message": "Bad credentials"}}`,
+	}, decodeActionError(suite.T(), res.Result))
+	require.NotNil(suite.T(), callbackResult.Error)
+	assert.Equal(suite.T(), ErrorCategoryAuth, callbackResult.Error.Category)
+
+	res = suite.execute(suite.server.URL, callbacks, &plugin_sdk.ExecuteActionRequest{Name: "GetExport", Parameters: map[string]string{"export": "missing"}})
+	actionErr := decodeActionError(suite.T(), res.Result)
+	assert.Equal(suite.T(), ErrorCategoryNotFound, actionErr.Category)
+	assert.Equal(suite.T(), "Not Found", actionErr.ProviderMessage)
+
+	res = suite.execute(suite.server.URL, callbacks, &plugin_sdk.ExecuteActionRequest{Name: "GetExport", Parameters: map[string]string{"export": "found"}})
+	assert.Equal(suite.T(), int64(consts.OK), res.ErrorCode)
+	assert.Nil(suite.T(), callbackResult.Error)
+}
+
+func (suite *ErrorsTestSuite) TestRequestErrors() {
+	res := suite.execute(suite.server.URL, Callbacks{}, &plugin_sdk.ExecuteActionRequest{Name: "GetExport", Parameters: map[string]string{"export": "slow"}, Timeout: 1})
+	assert.Equal(suite.T(), ErrorCategoryTimeout, decodeActionError(suite.T(), res.Result).Category)
+
+	// nothing listens on the port of a closed server.
+	closedServer := httptest.NewServer(http.NotFoundHandler())
+	closedServer.Close()
+	res = suite.execute(closedServer.URL, Callbacks{}, &plugin_sdk.ExecuteActionRequest{Name: "GetExport", Parameters: map[string]string{"export": "report"}})
+	assert.Equal(suite.T(), ErrorCategoryNetwork, decodeActionError(suite.T(), res.Result).Category)
+
+	res = suite.execute(suite.server.URL, Callbacks{}, &plugin_sdk.ExecuteActionRequest{Name: "GetExport"})
+	actionErr := decodeActionError(suite.T(), res.Result)
+	assert.Equal(suite.T(), ErrorCategoryValidation, actionErr.Category)
+	assert.Equal(suite.T(), []string{"export: required parameter is missing"}, actionErr.Details)
+}
+
+func (suite *ErrorsTestSuite) TestResponseTooLargeError() {
+	meta := PluginMetadata{ResponseSizeLimit: ResponseSizeLimit{MaxBytes: 8}}
+	res := suite.executeWith(meta, map[string]string{consts.RequestUrlKey: suite.server.URL}, Callbacks{}, &plugin_sdk.ExecuteActionRequest{Name: "GetExport", Parameters: map[string]string{"export": "large"}})
+	actionErr := decodeActionError(suite.T(), res.Result)
+	assert.Equal(suite.T(), ErrorCategoryServer, actionErr.Category)
+	assert.Equal(suite.T(), "The response is larger than the limit of 8 bytes", actionErr.Message)
+}
+
+func (suite *ErrorsTestSuite) TestAuthErrors() {
+	request := &plugin_sdk.ExecuteActionRequest{Name: "GetExport", Parameters: map[string]string{"export": "found"}}
+
+	// the token endpoint answers with a sign in page instead of a token.
+	connection := map[string]string{
+		consts.RequestUrlKey:   suite.server.URL,
+		consts.TokenUrlKey:     suite.server.URL + "/token",
+		consts.ClientIdKey:     "client",
+		consts.ClientSecretKey: "secret",
+	}
+	res := suite.executeWith(PluginMetadata{}, connection, Callbacks{}, request)
+	actionErr := decodeActionError(suite.T(), res.Result)
+	assert.Equal(suite.T(), ErrorCategoryAuth, actionErr.Category)
+	assert.Contains(suite.T(), actionErr.Message, "failed to parse the OAuth2 token response")
+
+	res = suite.executeWith(PluginMetadata{}, map[string]string{consts.RequestUrlKey: suite.server.URL, consts.JwtPrivateKeyKey: "not a key"}, Callbacks{}, request)
+	assert.Equal(suite.T(), ErrorCategoryAuth, decodeActionError(suite.T(), res.Result).Category)
+}
+
+func (suite *ErrorsTestSuite) TestTransportErrors() {
+	request := &plugin_sdk.ExecuteActionRequest{Name: "GetExport", Parameters: map[string]string{"export": "found"}}
+
+	// the certificate of the server isn't trusted.
+	tlsServer := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tlsServer.Close()
+	res := suite.executeWith(PluginMetadata{}, map[string]string{consts.RequestUrlKey: tlsServer.URL}, Callbacks{}, request)
+	assert.Equal(suite.T(), ErrorCategoryNetwork, decodeActionError(suite.T(), res.Result).Category)
+
+	res = suite.executeWith(PluginMetadata{}, map[string]string{consts.RequestUrlKey: suite.server.URL, consts.ProxyUrlKey: "ftp://proxy:21"}, Callbacks{}, request)
+	actionErr := decodeActionError(suite.T(), res.Result)
+	assert.Equal(suite.T(), ErrorCategoryNetwork, actionErr.Category)
+	assert.Contains(suite.T(), actionErr.Message, "unsupported PROXY_URL scheme")
+}
+
+func (suite *ErrorsTestSuite) TestStatusErrorCategory() {
+	cases := map[int]ErrorCategory{
+		http.StatusBadRequest:          ErrorCategoryValidation,
+		http.StatusUnauthorized:        ErrorCategoryAuth,
+		http.StatusForbidden:           ErrorCategoryAuth,
+		http.StatusNotFound:            ErrorCategoryNotFound,
+		http.StatusGone:                ErrorCategoryNotFound,
+		http.StatusConflict:            ErrorCategoryClient,
+		http.StatusRequestTimeout:      ErrorCategoryTimeout,
+		http.StatusUnprocessableEntity: ErrorCategoryValidation,
+		http.StatusTooManyRequests:     ErrorCategoryRateLimited,
+		http.StatusInternalServerError: ErrorCategoryServer,
+		http.StatusGatewayTimeout:      ErrorCategoryTimeout,
+	}
+
+	for statusCode, category := range cases {
+		assert.Equal(suite.T(), category, statusErrorCategory(statusCode), statusCode)
+	}
+}
+
+func (suite *ErrorsTestSuite) TestProviderMessage() {
+	cases := map[string]string{
+		`{"message": "Bad credentials"}`:                                         "Bad credentials",
+		`{"error": "invalid_grant", "error_description": "Token expired"}`:       "Token expired",
+		`{"error": {"message": "Rate limit exceeded"}}`:                          "Rate limit exceeded",
+		`{"errors": [{"message": "name is required"}, {"message": "too long"}]}`: "name is required; too long",
+		`{"errors": ["first", "second"]}`:                                        "first; second",
+		`{"detail": "Not found."}`:                                               "Not found.",
+		`{"ok": false}`:                                                          "",
+		"Service Unavailable\n":                                                  "Service Unavailable",
+		"<html><body>Bad Gateway</body></html>":                                  "",
+	}
+
+	for body, message := range cases {
+		assert.Equal(suite.T(), message, providerMessage([]byte(body)), body)
+	}
+}
+
+func TestErrorsSuite(t *testing.T) {
+	suite.Run(t, new(ErrorsTestSuite))
+}
@@ -12,8 +12,16 @@ import (
 
 var pathParamRE = regexp.MustCompile(`{[.;?]?([^{}*]+)\\*?}`)
 
-// DefineOperations returns all operations for an openApi definition.
+// DefineOperations adds all operations of an openApi definition to the global OperationDefinitions.
+//
+// Deprecated: use NewOperationRegistry and OperationRegistry.Define.
 func DefineOperations(openApi *openapi3.T) error {
+	registry := &OperationRegistry{operations: OperationDefinitions}
+	return registry.Define(openApi)
+}
+
+// Define adds all operations of an openApi definition to the registry.
+func (r *OperationRegistry) Define(openApi *openapi3.T) error {
 	for _, requestPath := range sortedPathsKeys(openApi.Paths) {
 		pathItem := openApi.Paths[requestPath]
 		// These are parameters defined for all methods on a given path. They
@@ -94,7 +102,7 @@ func DefineOperations(openApi *openapi3.T) error {
 			// Generate all the type definitions needed for this operation
 			opDef.TypeDefinitions = append(opDef.TypeDefinitions, generateTypeDefsForOperation(opDef)...)
 
-			OperationDefinitions[opDef.OperationId] = &opDef
+			r.operations[opDef.OperationId] = &opDef
 		}
 	}
 
@@ -192,10 +200,10 @@ func orderedParamsFromUri(uri string) []string {
 func describeSecurityDefinition(securityRequirements openapi3.SecurityRequirements) []securityDefinition {
 	outDefs := make([]securityDefinition, 0)
 
-	for _, sr := range securityRequirements {
+	for i, sr := range securityRequirements {
 		for _, k := range sortedSecurityRequirementKeys(sr) {
 			v := sr[k]
-			outDefs = append(outDefs, securityDefinition{providerName: k, scopes: v})
+			outDefs = append(outDefs, securityDefinition{ProviderName: k, Scopes: v, Requirement: i})
 		}
 	}
 
@@ -348,6 +356,11 @@ func generateParamsTypes(op OperationDefinition) []typeDefinition {
 	return append(typeDefs, td)
 }
 
+// IsBinarySchema returns whether the schema describes file content, which is sent as is.
+func IsBinarySchema(propertySchema *openapi3.Schema) bool {
+	return propertySchema.Type == "string" && propertySchema.Format == consts.FormatBinary
+}
+
 func GetPropertyByName(name string, propertySchema *openapi3.Schema) *openapi3.Schema {
 	var subPropertySchema *openapi3.Schema
 	allSchemas := []openapi3.SchemaRefs{propertySchema.AllOf, propertySchema.OneOf, propertySchema.AnyOf}
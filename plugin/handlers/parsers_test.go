@@ -290,6 +290,44 @@ func (suite *ParsersTestSuite) TestDefineOperations() {
 	}
 }
 
+func (suite *ParsersTestSuite) TestOperationRegistry() {
+	specVersion := func(version string, operationId string) string {
+		return `
+openapi: 3.0.0
+info:
+  title: users
+  version: ` + version + `
+paths:
+  /users:
+    get:
+      operationId: ` + operationId + `
+      responses:
+        "200":
+          description: users
+`
+	}
+
+	registries := map[string]*OperationRegistry{}
+	for version, operationId := range map[string]string{"1.0.0": "ListUsers", "2.0.0": "ListAllUsers"} {
+		openApi, err := openapi3.NewLoader().LoadFromData([]byte(specVersion(version, operationId)))
+		require.NoError(suite.T(), err)
+
+		registries[version] = NewOperationRegistry()
+		require.NoError(suite.T(), registries[version].Define(openApi))
+	}
+
+	// both versions are loaded side by side without overwriting each other
+	assert.NotNil(suite.T(), registries["1.0.0"].Get("ListUsers"))
+	assert.Nil(suite.T(), registries["1.0.0"].Get("ListAllUsers"))
+	assert.NotNil(suite.T(), registries["2.0.0"].Get("ListAllUsers"))
+	assert.Nil(suite.T(), registries["2.0.0"].Get("ListUsers"))
+	assert.Len(suite.T(), registries["2.0.0"].Operations(), 1)
+	assert.NotContains(suite.T(), OperationDefinitions, "ListUsers")
+
+	var nilRegistry *OperationRegistry
+	assert.Nil(suite.T(), nilRegistry.Get("ListUsers"))
+}
+
 func (suite *ParsersTestSuite) TestGetPropertyByName() {
 	schemaByte := []byte(`{
  "description": "Folder details",
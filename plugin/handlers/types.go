@@ -1,9 +1,53 @@
 package handlers
 
-import "github.com/getkin/kin-openapi/openapi3"
+import (
+	"sort"
 
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+// OperationDefinitions is the global registry used by DefineOperations.
+//
+// Deprecated: every plugin owns its OperationRegistry.
 var OperationDefinitions = map[string]*OperationDefinition{}
 
+// OperationRegistry holds the operations of a single openApi definition by their operation id.
+type OperationRegistry struct {
+	operations map[string]*OperationDefinition
+}
+
+func NewOperationRegistry() *OperationRegistry {
+	return &OperationRegistry{operations: map[string]*OperationDefinition{}}
+}
+
+// Get returns an operation by its operation id, or nil when it doesn't exist.
+func (r *OperationRegistry) Get(operationId string) *OperationDefinition {
+	if r == nil {
+		return nil
+	}
+
+	return r.operations[operationId]
+}
+
+// Operations returns all the operations sorted by their operation id.
+func (r *OperationRegistry) Operations() []*OperationDefinition {
+	if r == nil {
+		return nil
+	}
+
+	operations := make([]*OperationDefinition, 0, len(r.operations))
+	for _, operation := range r.operations {
+		operations = append(operations, operation)
+	}
+
+	sort.Slice(operations, func(i, j int) bool {
+		return operations[i].OperationId < operations[j].OperationId
+	})
+
+	return operations
+}
+
 // OperationDefinition This structure describes an Operation
 type OperationDefinition struct {
 	OperationId         string                // The operation_id description from OpenApi, used to generate function names
@@ -24,14 +68,17 @@ type OperationDefinition struct {
 // params Returns the list of all parameters except path parameters. path parameters
 // are handled differently from the rest, since they're mandatory.
 func (o *OperationDefinition) params() []parameterDefinition {
-	result := append(o.QueryParams, o.HeaderParams...)
+	result := append([]parameterDefinition{}, o.QueryParams...)
+	result = append(result, o.HeaderParams...)
 	result = append(result, o.CookieParams...)
 	return result
 }
 
 // AllParams Returns all parameters
 func (o *OperationDefinition) AllParams() []parameterDefinition {
-	result := append(o.QueryParams, o.HeaderParams...)
+	// copy the query params so the operation isn't modified, it's shared between concurrent actions.
+	result := append([]parameterDefinition{}, o.QueryParams...)
+	result = append(result, o.HeaderParams...)
 	result = append(result, o.CookieParams...)
 	result = append(result, o.PathParams...)
 	return result
@@ -47,16 +94,6 @@ func (o OperationDefinition) GetDefaultBody() *RequestBodyDefinition {
 	return nil
 }
 
-func (o OperationDefinition) GetDefaultBodyType() string {
-	defaultBody := o.GetDefaultBody()
-
-	if o.GetDefaultBody() != nil {
-		return defaultBody.ContentType
-	}
-
-	return ""
-}
-
 // parameterDefinition describes the various request parameters
 type parameterDefinition struct {
 	ParamName string // The original json parameter name, eg param_name
@@ -105,8 +142,9 @@ type schema struct {
 
 // securityDefinition describes required authentication headers
 type securityDefinition struct {
-	providerName string
-	scopes       []string
+	ProviderName string // The name of the security scheme in the components
+	Scopes       []string
+	Requirement  int // The index of the security requirement, the schemes of a requirement are used together
 }
 
 // RequestBodyDefinition This describes a request body
@@ -129,6 +167,11 @@ type RequestBodyDefinition struct {
 	DefaultBody bool
 }
 
+// IsRaw returns whether the whole body is a binary payload, like application/octet-stream, that is sent as is.
+func (b RequestBodyDefinition) IsRaw() bool {
+	return b.ContentType == consts.OctetStream || (b.Schema.OApiSchema != nil && IsBinarySchema(b.Schema.OApiSchema))
+}
+
 // property describes a request body key
 type property struct {
 	description    string
@@ -0,0 +1,219 @@
+package plugin
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	HMACSHA1   = "sha1"
+	HMACSHA256 = "sha256"
+	HMACSHA512 = "sha512"
+
+	HMACEncodingHex    = "hex"
+	HMACEncodingBase64 = "base64"
+
+	HMACTimestampUnix      = "unix"
+	HMACTimestampUnixMilli = "unix_milli"
+	HMACTimestampRFC3339   = "rfc3339"
+
+	// the components of the signed string.
+	HMACComponentMethod    = "method"     // upper case, like GET
+	HMACComponentPath      = "path"       // the escaped path, like /v2/users
+	HMACComponentPathQuery = "path_query" // the path with the query, like /v2/users?limit=10
+	HMACComponentQuery     = "query"      // the raw query, without the ?
+	HMACComponentHost      = "host"
+	HMACComponentTimestamp = "timestamp"
+	HMACComponentBody      = "body" // empty for requests without a body
+
+	defaultHMACSecretKey = "HMAC_SECRET"
+)
+
+var hmacAlgorithms = map[string]func() hash.Hash{
+	HMACSHA1:   sha1.New,
+	HMACSHA256: sha256.New,
+	HMACSHA512: sha512.New,
+}
+
+// HMACConfig signs the requests of the plugin with an HMAC of their method, path, timestamp and body.
+// The zero value doesn't sign requests, they are signed when SignatureHeader is set and the connection has the secret.
+type HMACConfig struct {
+	Algorithm       string   // sha256 by default, sha1 or sha512
+	Components      []string // the components of the signed string in order, defaults to timestamp, method, path_query and body
+	Separator       string   // joins the components, they are concatenated by default
+	Encoding        string   // hex by default or base64
+	SignatureHeader string
+	SignaturePrefix string // prepended to the signature, like sha256=
+	TimestampHeader string // the timestamp isn't sent when it's empty
+	TimestampFormat string // unix seconds by default, unix_milli or rfc3339
+	SecretKey       string // the connection field of the secret, HMAC_SECRET by default
+	SecretBase64    bool   // the secret is base64 encoded, it is decoded before signing
+}
+
+type hmacSigner struct {
+	config HMACConfig
+	secret []byte
+	now    func() time.Time
+}
+
+func (c HMACConfig) enabled() bool {
+	return c.SignatureHeader != ""
+}
+
+// withDefaults returns the config with its defaults, the algorithm, components, encoding and timestamp format are lower cased
+// so they match the constants when they are signed.
+func (c HMACConfig) withDefaults() HMACConfig {
+	c.Algorithm = strings.ToLower(c.Algorithm)
+	c.Encoding = strings.ToLower(c.Encoding)
+	c.TimestampFormat = strings.ToLower(c.TimestampFormat)
+
+	components := make([]string, 0, len(c.Components))
+	for _, component := range c.Components {
+		components = append(components, strings.ToLower(component))
+	}
+	c.Components = components
+
+	if c.Algorithm == "" {
+		c.Algorithm = HMACSHA256
+	}
+	if len(c.Components) == 0 {
+		c.Components = []string{HMACComponentTimestamp, HMACComponentMethod, HMACComponentPathQuery, HMACComponentBody}
+	}
+	if c.Encoding == "" {
+		c.Encoding = HMACEncodingHex
+	}
+	if c.TimestampFormat == "" {
+		c.TimestampFormat = HMACTimestampUnix
+	}
+	if c.SecretKey == "" {
+		c.SecretKey = defaultHMACSecretKey
+	}
+
+	return c
+}
+
+// validate returns an error for unknown algorithms, components, encodings and timestamp formats.
+func (c HMACConfig) validate() error {
+	if !c.enabled() {
+		return nil
+	}
+
+	c = c.withDefaults()
+	if _, ok := hmacAlgorithms[c.Algorithm]; !ok {
+		return errors.Errorf("unsupported HMAC algorithm %s", c.Algorithm)
+	}
+	if c.Encoding != HMACEncodingHex && c.Encoding != HMACEncodingBase64 {
+		return errors.Errorf("unsupported HMAC encoding %s", c.Encoding)
+	}
+	if c.TimestampFormat != HMACTimestampUnix && c.TimestampFormat != HMACTimestampUnixMilli && c.TimestampFormat != HMACTimestampRFC3339 {
+		return errors.Errorf("unsupported HMAC timestamp format %s", c.TimestampFormat)
+	}
+
+	for _, component := range c.Components {
+		switch component {
+		case HMACComponentMethod, HMACComponentPath, HMACComponentPathQuery, HMACComponentQuery, HMACComponentHost, HMACComponentTimestamp, HMACComponentBody:
+		default:
+			return errors.Errorf("unsupported HMAC component %s", component)
+		}
+	}
+
+	return nil
+}
+
+// newHMACSigner returns the signer of the config when the connection has the secret, nil otherwise.
+func newHMACSigner(connection map[string]string, config HMACConfig) (*hmacSigner, error) {
+	if !config.enabled() {
+		return nil, nil
+	}
+
+	config = config.withDefaults()
+	secret := connectionValue(connection, config.SecretKey)
+	if secret == "" {
+		return nil, nil
+	}
+
+	signer := &hmacSigner{config: config, secret: []byte(secret), now: time.Now}
+	if config.SecretBase64 {
+		decoded, err := base64.StdEncoding.DecodeString(secret)
+		if err != nil {
+			return nil, errors.Errorf("%s isn't base64 encoded", config.SecretKey)
+		}
+		signer.secret = decoded
+	}
+
+	return signer, nil
+}
+
+// authenticate signs the request as it is sent, it is signed again on every attempt with the current time.
+func (s *hmacSigner) authenticate(request *http.Request) error {
+	return s.sign(request, s.now())
+}
+
+func (s *hmacSigner) reauthenticate(*http.Request, Result) (bool, error) {
+	return false, nil
+}
+
+func (s *hmacSigner) sign(request *http.Request, signingTime time.Time) error {
+	body, err := readRequestBody(request)
+	if err != nil {
+		return err
+	}
+
+	timestamp := s.formatTimestamp(signingTime)
+	components := make([]string, 0, len(s.config.Components))
+	for _, component := range s.config.Components {
+		switch component {
+		case HMACComponentMethod:
+			components = append(components, strings.ToUpper(request.Method))
+		case HMACComponentPath:
+			components = append(components, request.URL.EscapedPath())
+		case HMACComponentPathQuery:
+			components = append(components, request.URL.RequestURI())
+		case HMACComponentQuery:
+			components = append(components, request.URL.RawQuery)
+		case HMACComponentHost:
+			components = append(components, requestHost(request))
+		case HMACComponentTimestamp:
+			components = append(components, timestamp)
+		case HMACComponentBody:
+			components = append(components, string(body))
+		}
+	}
+
+	mac := hmac.New(hmacAlgorithms[s.config.Algorithm], s.secret)
+	mac.Write([]byte(strings.Join(components, s.config.Separator)))
+
+	signature := hex.EncodeToString(mac.Sum(nil))
+	if s.config.Encoding == HMACEncodingBase64 {
+		signature = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+
+	request.Header.Set(s.config.SignatureHeader, s.config.SignaturePrefix+signature)
+	if s.config.TimestampHeader != "" {
+		request.Header.Set(s.config.TimestampHeader, timestamp)
+	}
+
+	return nil
+}
+
+func (s *hmacSigner) formatTimestamp(signingTime time.Time) string {
+	switch s.config.TimestampFormat {
+	case HMACTimestampUnixMilli:
+		return strconv.FormatInt(signingTime.UnixNano()/int64(time.Millisecond), 10)
+	case HMACTimestampRFC3339:
+		return signingTime.UTC().Format(time.RFC3339)
+	default:
+		return strconv.FormatInt(signingTime.Unix(), 10)
+	}
+}
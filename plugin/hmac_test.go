@@ -0,0 +1,205 @@
+package plugin
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	plugin_sdk "github.com/blinkops/blink-sdk/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const ordersOpenApi = `
+openapi: 3.0.0
+info:
+  title: orders
+  version: 1.0.0
+servers:
+  - url: https://api.example.com
+paths:
+  /orders:
+    post:
+      operationId: CreateOrder
+      parameters:
+        - name: dry
+          in: query
+          schema:
+            type: string
+      requestBody:
+        content:
+          application/json:
+            schema:
+              type: object
+              properties:
+                product:
+                  type: string
+      responses:
+        "200":
+          description: order
+`
+
+type HMACTestSuite struct {
+	suite.Suite
+	signingTime time.Time
+}
+
+func (suite *HMACTestSuite) SetupTest() {
+	suite.signingTime = time.Unix(1600000000, 123000000)
+}
+
+func (suite *HMACTestSuite) sign(config HMACConfig, connection map[string]string, method string, requestUrl string, body string) http.Header {
+	require.NoError(suite.T(), config.validate())
+
+	signer, err := newHMACSigner(connection, config)
+	require.NoError(suite.T(), err)
+	require.NotNil(suite.T(), signer)
+
+	request, err := http.NewRequest(method, requestUrl, strings.NewReader(body))
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), signer.sign(request, suite.signingTime))
+
+	// the body is still sent.
+	sentBody, err := ioutil.ReadAll(request.Body)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), body, string(sentBody))
+
+	return request.Header
+}
+
+func (suite *HMACTestSuite) TestWebhookStyle() {
+	config := HMACConfig{Components: []string{HMACComponentBody}, SignatureHeader: "X-Hub-Signature-256", SignaturePrefix: "sha256="}
+
+	header := suite.sign(config, map[string]string{"HMAC_SECRET": "key"}, http.MethodPost, "https://example.com/hook", "The quick brown fox jumps over the lazy dog")
+	assert.Equal(suite.T(), "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8", header.Get("X-Hub-Signature-256"))
+}
+
+func (suite *HMACTestSuite) TestDefaultComponents() {
+	secret := base64.StdEncoding.EncodeToString([]byte("exchange-secret"))
+	config := HMACConfig{Encoding: HMACEncodingBase64, SignatureHeader: "CB-ACCESS-SIGN", TimestampHeader: "CB-ACCESS-TIMESTAMP", SecretKey: "API_SECRET", SecretBase64: true}
+
+	header := suite.sign(config, map[string]string{"api_secret": secret}, http.MethodPost, "https://example.com/orders?limit=10", `{"size": 1}`)
+
+	mac := hmac.New(sha256.New, []byte("exchange-secret"))
+	mac.Write([]byte(`1600000000POST/orders?limit=10{"size": 1}`))
+	assert.Equal(suite.T(), base64.StdEncoding.EncodeToString(mac.Sum(nil)), header.Get("CB-ACCESS-SIGN"))
+	assert.Equal(suite.T(), "1600000000", header.Get("CB-ACCESS-TIMESTAMP"))
+}
+
+func (suite *HMACTestSuite) TestComponentsOrder() {
+	config := HMACConfig{
+		Components:      []string{HMACComponentMethod, HMACComponentHost, HMACComponentPath, HMACComponentQuery, HMACComponentTimestamp},
+		Separator:       "\n",
+		SignatureHeader: "X-Signature",
+		TimestampHeader: "X-Timestamp",
+		TimestampFormat: HMACTimestampUnixMilli,
+	}
+
+	header := suite.sign(config, map[string]string{"HMAC_SECRET": "secret"}, http.MethodGet, "https://example.com:443/v2/users?limit=10", "")
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("GET\nexample.com\n/v2/users\nlimit=10\n1600000000123"))
+	assert.Equal(suite.T(), hex.EncodeToString(mac.Sum(nil)), header.Get("X-Signature"))
+	assert.Equal(suite.T(), "1600000000123", header.Get("X-Timestamp"))
+
+	config.TimestampFormat = HMACTimestampRFC3339
+	header = suite.sign(config, map[string]string{"HMAC_SECRET": "secret"}, http.MethodGet, "https://example.com/v2/users", "")
+	assert.Equal(suite.T(), "2020-09-13T12:26:40Z", header.Get("X-Timestamp"))
+}
+
+func (suite *HMACTestSuite) TestMixedCaseConfig() {
+	// the config values are matched case insensitively, like when they're validated.
+	components := []string{"Method", "PATH", "Timestamp"}
+	config := HMACConfig{Algorithm: "SHA512", Components: components, Encoding: "Base64", SignatureHeader: "X-Signature", TimestampHeader: "X-Timestamp", TimestampFormat: "RFC3339"}
+
+	header := suite.sign(config, map[string]string{"HMAC_SECRET": "secret"}, http.MethodGet, "https://example.com/v2/users", "")
+
+	mac := hmac.New(sha512.New, []byte("secret"))
+	mac.Write([]byte("GET/v2/users2020-09-13T12:26:40Z"))
+	assert.Equal(suite.T(), base64.StdEncoding.EncodeToString(mac.Sum(nil)), header.Get("X-Signature"))
+	assert.Equal(suite.T(), "2020-09-13T12:26:40Z", header.Get("X-Timestamp"))
+	assert.Equal(suite.T(), []string{"Method", "PATH", "Timestamp"}, components)
+}
+
+func (suite *HMACTestSuite) TestInvalidConfig() {
+	configs := []HMACConfig{
+		{SignatureHeader: "X-Signature", Algorithm: "md5"},
+		{SignatureHeader: "X-Signature", Encoding: "base32"},
+		{SignatureHeader: "X-Signature", TimestampFormat: "iso"},
+		{SignatureHeader: "X-Signature", Components: []string{HMACComponentMethod, "nonce"}},
+	}
+
+	for _, config := range configs {
+		assert.Error(suite.T(), config.validate(), config)
+	}
+
+	// the zero value doesn't sign requests.
+	assert.NoError(suite.T(), HMACConfig{Algorithm: "md5"}.validate())
+	signer, err := newHMACSigner(map[string]string{"HMAC_SECRET": "secret"}, HMACConfig{})
+	assert.NoError(suite.T(), err)
+	assert.Nil(suite.T(), signer)
+
+	_, err = newHMACSigner(map[string]string{"HMAC_SECRET": "not base64!"}, HMACConfig{SignatureHeader: "X-Signature", SecretBase64: true})
+	assert.Error(suite.T(), err)
+}
+
+func (suite *HMACTestSuite) TestSignedAction() {
+	var (
+		header http.Header
+		body   []byte
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		header = req.Header.Clone()
+		body, _ = ioutil.ReadAll(req.Body)
+		res.Header().Set(consts.ContentTypeHeader, consts.RequestBodyType)
+		_, _ = res.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	openApiFile := filepath.Join(suite.T().TempDir(), "orders-openapi.yaml")
+	require.NoError(suite.T(), ioutil.WriteFile(openApiFile, []byte(ordersOpenApi), 0600))
+
+	config := HMACConfig{SignatureHeader: "X-Signature", TimestampHeader: "X-Timestamp"}
+	p, err := NewOpenApiPlugin(nil, PluginMetadata{Name: "orders", Provider: "orders", OpenApiFile: openApiFile, HMAC: config}, Callbacks{})
+	require.NoError(suite.T(), err)
+
+	connection := map[string]string{consts.RequestUrlKey: server.URL, "HMAC_SECRET": "secret", "X-Api-Key": "key"}
+	res := p.executeActionWithCredentials(connection, &plugin_sdk.ExecuteActionRequest{Name: "CreateOrder", Parameters: map[string]string{"product": "book", "dry": "true"}})
+	require.Equal(suite.T(), int64(consts.OK), res.ErrorCode, string(res.Result))
+
+	// the body built from the action params is signed, the secret isn't sent.
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(header.Get("X-Timestamp") + "POST/orders?dry=true" + string(body)))
+	assert.JSONEq(suite.T(), `{"product": "book"}`, string(body))
+	assert.Equal(suite.T(), hex.EncodeToString(mac.Sum(nil)), header.Get("X-Signature"))
+	assert.Equal(suite.T(), "key", header.Get("X-Api-Key"))
+	assert.Empty(suite.T(), header.Get("HMAC_SECRET"))
+
+	// dry runs are signed too, the signature is redacted.
+	res = p.executeActionWithCredentials(connection, &plugin_sdk.ExecuteActionRequest{Name: "CreateOrder", Parameters: map[string]string{"product": "book", consts.DryRunKey: "true"}})
+	require.Equal(suite.T(), int64(consts.OK), res.ErrorCode, string(res.Result))
+	var dryRunRequest DryRunRequest
+	require.NoError(suite.T(), json.Unmarshal(res.Result, &dryRunRequest))
+	assert.Equal(suite.T(), []string{redactedValue}, dryRunRequest.Headers["X-Signature"])
+	assert.NotEmpty(suite.T(), dryRunRequest.Headers.Get("X-Timestamp"))
+	assert.JSONEq(suite.T(), `{"product": "book"}`, dryRunRequest.Body)
+
+	_, err = NewOpenApiPlugin(nil, PluginMetadata{Name: "orders", Provider: "orders", OpenApiFile: openApiFile, HMAC: HMACConfig{SignatureHeader: "X-Signature", Algorithm: "md5"}}, Callbacks{})
+	assert.Error(suite.T(), err)
+}
+
+func TestHMACSuite(t *testing.T) {
+	suite.Run(t, new(HMACTestSuite))
+}
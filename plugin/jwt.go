@@ -0,0 +1,274 @@
+package plugin
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	jwtAlgorithmRS256 = "RS256"
+	jwtAlgorithmES256 = "ES256"
+
+	jwtBearerGrant = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+	// GitHub rejects assertions that live longer than 10 minutes, the issue time is backdated for clock drift.
+	defaultJWTLifetime = 5 * time.Minute
+	jwtClockSkew       = 30 * time.Second
+)
+
+// jwtConnectionKeys are the connection keys of the JWT assertion, they aren't sent as headers when JWTs are used.
+var jwtConnectionKeys = []string{
+	consts.JwtPrivateKeyKey,
+	consts.JwtKeyIdKey,
+	consts.JwtIssuerKey,
+	consts.JwtSubjectKey,
+	consts.JwtAudienceKey,
+	consts.JwtScopesKey,
+	consts.JwtTokenUrlKey,
+	consts.JwtLifetimeKey,
+}
+
+type (
+	// jwtAuthenticator sends a JWT signed with the connection's private key as a bearer token,
+	// or exchanges it for an access token at the token url, like Google service accounts.
+	jwtAuthenticator struct {
+		client    *http.Client
+		key       crypto.Signer
+		algorithm string
+		keyID     string
+		issuer    string
+		subject   string
+		audience  string
+		scopes    string
+		tokenURL  string
+		lifetime  time.Duration
+		token     *oauth2Token
+		now       func() time.Time
+	}
+
+	// serviceAccountKey is the JSON key file of Google service accounts, it can be used as the private key field.
+	serviceAccountKey struct {
+		PrivateKey   string `json:"private_key"`
+		PrivateKeyID string `json:"private_key_id"`
+		ClientEmail  string `json:"client_email"`
+		TokenURI     string `json:"token_uri"`
+	}
+
+	jwtHeader struct {
+		Algorithm string `json:"alg"`
+		Type      string `json:"typ"`
+		KeyID     string `json:"kid,omitempty"`
+	}
+
+	jwtClaims struct {
+		Issuer    string `json:"iss,omitempty"`
+		Subject   string `json:"sub,omitempty"`
+		Audience  string `json:"aud,omitempty"`
+		Scope     string `json:"scope,omitempty"`
+		IssuedAt  int64  `json:"iat"`
+		ExpiresAt int64  `json:"exp"`
+	}
+)
+
+// newJWTAuthenticator returns the JWT authenticator of connections with a private key, nil for other connections.
+func newJWTAuthenticator(connection map[string]string, client *http.Client) (*jwtAuthenticator, error) {
+	privateKey := connectionValue(connection, consts.JwtPrivateKeyKey)
+	if privateKey == "" {
+		return nil, nil
+	}
+
+	a := &jwtAuthenticator{
+		client:   client,
+		keyID:    connectionValue(connection, consts.JwtKeyIdKey),
+		issuer:   connectionValue(connection, consts.JwtIssuerKey),
+		subject:  connectionValue(connection, consts.JwtSubjectKey),
+		audience: connectionValue(connection, consts.JwtAudienceKey),
+		scopes:   strings.Join(strings.FieldsFunc(connectionValue(connection, consts.JwtScopesKey), func(r rune) bool { return r == ',' || r == ' ' }), " "),
+		tokenURL: connectionValue(connection, consts.JwtTokenUrlKey),
+		lifetime: defaultJWTLifetime,
+		now:      time.Now,
+	}
+
+	// the service account key file has the key, the issuer and the token url.
+	if strings.HasPrefix(strings.TrimSpace(privateKey), "{") {
+		account := serviceAccountKey{}
+		if err := json.Unmarshal([]byte(privateKey), &account); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse the %s key file", consts.JwtPrivateKeyKey)
+		}
+
+		privateKey = account.PrivateKey
+		a.keyID = valueOrDefault(a.keyID, account.PrivateKeyID)
+		a.issuer = valueOrDefault(a.issuer, account.ClientEmail)
+		a.tokenURL = valueOrDefault(a.tokenURL, account.TokenURI)
+	}
+
+	if lifetime := connectionValue(connection, consts.JwtLifetimeKey); lifetime != "" {
+		seconds, err := strconv.Atoi(lifetime)
+		if err != nil {
+			return nil, errors.Errorf("invalid %s, expected seconds", consts.JwtLifetimeKey)
+		}
+
+		// the assertion is backdated by the clock skew and renewed before the expiry leeway, shorter lifetimes would never be valid.
+		a.lifetime = time.Duration(seconds) * time.Second
+		if minLifetime := jwtClockSkew + oauth2ExpiryLeeway; a.lifetime <= minLifetime {
+			return nil, errors.Errorf("invalid %s, expected more than %d seconds", consts.JwtLifetimeKey, int(minLifetime/time.Second))
+		}
+	}
+
+	// token endpoints are the audience of the assertions they exchange.
+	if a.audience == "" {
+		a.audience = a.tokenURL
+	}
+
+	var err error
+	if a.key, a.algorithm, err = parseJWTSigningKey(normalizePEM(privateKey)); err != nil {
+		return nil, err
+	}
+
+	a.token = oauth2Tokens.get(jwtBearerGrant, privateKey, a.keyID, a.issuer, a.subject, a.audience, a.scopes, a.tokenURL)
+	return a, nil
+}
+
+// parseJWTSigningKey parses PKCS #1, PKCS #8 and EC private keys, RSA keys sign with RS256 and P-256 keys with ES256.
+func parseJWTSigningKey(privateKey string) (crypto.Signer, string, error) {
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return nil, "", errors.Errorf("%s isn't a PEM private key", consts.JwtPrivateKeyKey)
+	}
+
+	var (
+		key interface{}
+		err error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "failed to parse %s", consts.JwtPrivateKeyKey)
+	}
+
+	switch signingKey := key.(type) {
+	case *rsa.PrivateKey:
+		return signingKey, jwtAlgorithmRS256, nil
+	case *ecdsa.PrivateKey:
+		if signingKey.Curve != elliptic.P256() {
+			return nil, "", errors.Errorf("%s must be a P-256 key to sign with ES256", consts.JwtPrivateKeyKey)
+		}
+		return signingKey, jwtAlgorithmES256, nil
+	default:
+		return nil, "", errors.Errorf("%s must be an RSA or EC key", consts.JwtPrivateKeyKey)
+	}
+}
+
+func (a *jwtAuthenticator) authenticate(request *http.Request) error {
+	accessToken, err := a.token.get(request.Context(), "", a.requestToken)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Authorization", consts.BearerAuth+accessToken)
+	return nil
+}
+
+// reauthenticate signs or exchanges a new token when the token was rejected before its expiry.
+func (a *jwtAuthenticator) reauthenticate(request *http.Request, result Result) (bool, error) {
+	if result.StatusCode != http.StatusUnauthorized {
+		return false, nil
+	}
+
+	log.Warnf("The JWT token was rejected by %s %s, creating a new token", request.Method, request.URL.Path)
+	return renewBearerToken(request, a.token, a.requestToken)
+}
+
+// requestToken returns the signed assertion, or the access token it was exchanged for when there's a token url.
+func (a *jwtAuthenticator) requestToken(ctx context.Context, _ string) (oauth2TokenResponse, error) {
+	assertion, err := a.sign(a.now())
+	if err != nil {
+		return oauth2TokenResponse{}, err
+	}
+
+	if a.tokenURL == "" {
+		expiresIn := strconv.FormatInt(int64((a.lifetime-jwtClockSkew)/time.Second), 10)
+		return oauth2TokenResponse{AccessToken: assertion, ExpiresIn: json.Number(expiresIn)}, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", jwtBearerGrant)
+	form.Set("assertion", assertion)
+
+	return postTokenRequest(ctx, a.client, a.tokenURL, form, "", "")
+}
+
+// sign returns the compact serialization of the assertion.
+func (a *jwtAuthenticator) sign(now time.Time) (string, error) {
+	header, err := json.Marshal(jwtHeader{Algorithm: a.algorithm, Type: "JWT", KeyID: a.keyID})
+	if err != nil {
+		return "", err
+	}
+
+	issuedAt := now.Add(-jwtClockSkew)
+	claims, err := json.Marshal(jwtClaims{
+		Issuer:    a.issuer,
+		Subject:   a.subject,
+		Audience:  a.audience,
+		Scope:     a.scopes,
+		IssuedAt:  issuedAt.Unix(),
+		ExpiresAt: issuedAt.Add(a.lifetime).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch key := a.key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		// JWS signatures are the fixed size r and s, not the ASN.1 encoding of crypto.Signer.
+		r, s, signErr := ecdsa.Sign(rand.Reader, key, digest[:])
+		signature, err = make([]byte, 64), signErr
+		if err == nil {
+			r.FillBytes(signature[:32])
+			s.FillBytes(signature[32:])
+		}
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign the JWT")
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func valueOrDefault(value string, defaultValue string) string {
+	if value != "" {
+		return value
+	}
+
+	return defaultValue
+}
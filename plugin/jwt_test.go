@@ -0,0 +1,235 @@
+package plugin
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type JWTTestSuite struct {
+	suite.Suite
+	rsaKey        *rsa.PrivateKey
+	ecKey         *ecdsa.PrivateKey
+	apiServer     *httptest.Server
+	tokenServer   *httptest.Server
+	tokenRequests int32
+	assertions    chan string
+	authorization chan string
+	rejectAll     bool
+}
+
+func (suite *JWTTestSuite) SetupSuite() {
+	var err error
+	suite.rsaKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(suite.T(), err)
+	suite.ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(suite.T(), err)
+}
+
+func (suite *JWTTestSuite) SetupTest() {
+	suite.tokenRequests = 0
+	suite.rejectAll = false
+	suite.assertions = make(chan string, 100)
+	suite.authorization = make(chan string, 100)
+
+	suite.tokenServer = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		count := atomic.AddInt32(&suite.tokenRequests, 1)
+		_ = req.ParseForm()
+		if req.PostForm.Get("grant_type") != jwtBearerGrant {
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		suite.assertions <- req.PostForm.Get("assertion")
+		res.Header().Set(consts.ContentTypeHeader, consts.RequestBodyType)
+		_, _ = fmt.Fprintf(res, `{"access_token": "token-%d", "token_type": "Bearer", "expires_in": 3600}`, count)
+	}))
+
+	suite.apiServer = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		suite.authorization <- req.Header.Get("Authorization")
+		if suite.rejectAll {
+			res.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+}
+
+func (suite *JWTTestSuite) TearDownTest() {
+	suite.tokenServer.Close()
+	suite.apiServer.Close()
+}
+
+func (suite *JWTTestSuite) send(connection map[string]string) (Result, error) {
+	request, err := http.NewRequest(http.MethodGet, suite.apiServer.URL+"/installations", nil)
+	require.NoError(suite.T(), err)
+
+	return executeRequestWithCredentials(connection, request, requestOptions{timeout: 5})
+}
+
+// verify checks the signature of the JWT with the public key and returns its header and claims.
+func (suite *JWTTestSuite) verify(token string, publicKey crypto.PublicKey) (jwtHeader, jwtClaims) {
+	parts := strings.Split(token, ".")
+	require.Len(suite.T(), parts, 3)
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(suite.T(), err)
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		assert.NoError(suite.T(), rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature))
+	case *ecdsa.PublicKey:
+		require.Len(suite.T(), signature, 64)
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		assert.True(suite.T(), ecdsa.Verify(key, digest[:], r, s))
+	}
+
+	header, claims := jwtHeader{}, jwtClaims{}
+	for i, value := range []interface{}{&header, &claims} {
+		decoded, err := base64.RawURLEncoding.DecodeString(parts[i])
+		require.NoError(suite.T(), err)
+		require.NoError(suite.T(), json.Unmarshal(decoded, value))
+	}
+
+	return header, claims
+}
+
+func (suite *JWTTestSuite) TestDirectRS256() {
+	connection := map[string]string{
+		consts.JwtPrivateKeyKey: escapedPEM("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(suite.rsaKey)),
+		consts.JwtIssuerKey:     "123456",
+		consts.JwtLifetimeKey:   "600",
+		"X-GitHub-Api-Version":  "2022-11-28",
+	}
+
+	_, err := suite.send(connection)
+	require.NoError(suite.T(), err)
+	authorization := <-suite.authorization
+	require.True(suite.T(), strings.HasPrefix(authorization, consts.BearerAuth))
+
+	header, claims := suite.verify(strings.TrimPrefix(authorization, consts.BearerAuth), &suite.rsaKey.PublicKey)
+	assert.Equal(suite.T(), jwtHeader{Algorithm: jwtAlgorithmRS256, Type: "JWT"}, header)
+	assert.Equal(suite.T(), "123456", claims.Issuer)
+	assert.Empty(suite.T(), claims.Audience)
+	assert.Equal(suite.T(), int64(600), claims.ExpiresAt-claims.IssuedAt)
+	assert.InDelta(suite.T(), time.Now().Add(-jwtClockSkew).Unix(), claims.IssuedAt, 5)
+
+	// the JWT is reused until it expires, no token endpoint is called.
+	_, err = suite.send(connection)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), authorization, <-suite.authorization)
+	assert.Equal(suite.T(), int32(0), atomic.LoadInt32(&suite.tokenRequests))
+}
+
+func (suite *JWTTestSuite) TestExchangeES256() {
+	der, err := x509.MarshalPKCS8PrivateKey(suite.ecKey)
+	require.NoError(suite.T(), err)
+
+	connection := map[string]string{
+		consts.JwtPrivateKeyKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		consts.JwtKeyIdKey:      "key-1",
+		consts.JwtIssuerKey:     "client",
+		consts.JwtSubjectKey:    "user@example.com",
+		consts.JwtScopesKey:     "read,write",
+		consts.JwtTokenUrlKey:   suite.tokenServer.URL + "/token",
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = suite.send(connection)
+		require.NoError(suite.T(), err)
+		assert.Equal(suite.T(), consts.BearerAuth+"token-1", <-suite.authorization)
+	}
+	require.Equal(suite.T(), int32(1), atomic.LoadInt32(&suite.tokenRequests))
+
+	header, claims := suite.verify(<-suite.assertions, &suite.ecKey.PublicKey)
+	assert.Equal(suite.T(), jwtHeader{Algorithm: jwtAlgorithmES256, Type: "JWT", KeyID: "key-1"}, header)
+	assert.Equal(suite.T(), jwtClaims{
+		Issuer:    "client",
+		Subject:   "user@example.com",
+		Audience:  suite.tokenServer.URL + "/token",
+		Scope:     "read write",
+		IssuedAt:  claims.IssuedAt,
+		ExpiresAt: claims.IssuedAt + int64(defaultJWTLifetime/time.Second),
+	}, claims)
+
+	// a rejected token is exchanged again once.
+	suite.rejectAll = true
+	result, err := suite.send(connection)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), http.StatusUnauthorized, result.StatusCode)
+	assert.Equal(suite.T(), consts.BearerAuth+"token-1", <-suite.authorization)
+	assert.Equal(suite.T(), consts.BearerAuth+"token-2", <-suite.authorization)
+	assert.Equal(suite.T(), int32(2), atomic.LoadInt32(&suite.tokenRequests))
+}
+
+func (suite *JWTTestSuite) TestServiceAccountKey() {
+	account, err := json.Marshal(serviceAccountKey{
+		PrivateKey:   escapedPEM("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(suite.rsaKey)),
+		PrivateKeyID: "abc",
+		ClientEmail:  "robot@project.iam.gserviceaccount.com",
+		TokenURI:     suite.tokenServer.URL,
+	})
+	require.NoError(suite.T(), err)
+
+	connection := map[string]string{consts.JwtPrivateKeyKey: string(account), consts.JwtScopesKey: "https://www.googleapis.com/auth/cloud-platform"}
+	_, err = suite.send(connection)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), consts.BearerAuth+"token-1", <-suite.authorization)
+
+	header, claims := suite.verify(<-suite.assertions, &suite.rsaKey.PublicKey)
+	assert.Equal(suite.T(), "abc", header.KeyID)
+	assert.Equal(suite.T(), "robot@project.iam.gserviceaccount.com", claims.Issuer)
+	assert.Equal(suite.T(), suite.tokenServer.URL, claims.Audience)
+	assert.Equal(suite.T(), "https://www.googleapis.com/auth/cloud-platform", claims.Scope)
+}
+
+func (suite *JWTTestSuite) TestInvalidKeys() {
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(suite.T(), err)
+	p384Der, err := x509.MarshalECPrivateKey(p384Key)
+	require.NoError(suite.T(), err)
+
+	connections := []map[string]string{
+		{consts.JwtPrivateKeyKey: "not a key"},
+		{consts.JwtPrivateKeyKey: "{not json"},
+		{consts.JwtPrivateKeyKey: string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: []byte("garbage")}))},
+		{consts.JwtPrivateKeyKey: string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: p384Der}))},
+		{consts.JwtPrivateKeyKey: escapedPEM("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(suite.rsaKey)), consts.JwtLifetimeKey: "soon"},
+		{consts.JwtPrivateKeyKey: escapedPEM("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(suite.rsaKey)), consts.JwtLifetimeKey: "0"},
+		{consts.JwtPrivateKeyKey: escapedPEM("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(suite.rsaKey)), consts.JwtLifetimeKey: "60"},
+	}
+
+	for _, connection := range connections {
+		_, err = suite.send(connection)
+		assert.Error(suite.T(), err, connection)
+	}
+	assert.Empty(suite.T(), suite.authorization)
+}
+
+// escapedPEM encodes the key like connection fields, with escaped new lines.
+func escapedPEM(blockType string, der []byte) string {
+	return strings.ReplaceAll(string(pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})), "\n", `\n`)
+}
+
+func TestJWTSuite(t *testing.T) {
+	suite.Run(t, new(JWTTestSuite))
+}
@@ -0,0 +1,387 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	oauth2GrantClientCredentials = "client_credentials"
+	oauth2GrantRefreshToken      = "refresh_token"
+
+	// tokens are renewed a bit before they expire, so they don't expire while the request is sent.
+	oauth2ExpiryLeeway = 30 * time.Second
+
+	// token responses are small, a larger response isn't a token.
+	maxTokenResponseSize = 1 << 20
+
+	// tokens of connections that aren't used anymore are evicted, a token that holds a refresh token rotated by the provider
+	// is kept longer since the connection's refresh token may have been revoked by the rotation.
+	oauth2TokenIdleTTL        = time.Hour
+	oauth2RotatedTokenIdleTTL = 30 * 24 * time.Hour
+)
+
+// oauth2ConnectionKeys are the connection keys of the OAuth2 flows, they aren't sent as headers when OAuth2 is used.
+var oauth2ConnectionKeys = []string{
+	consts.TokenUrlKey,
+	consts.ClientIdKey,
+	consts.ClientSecretKey,
+	consts.RefreshTokenKey,
+	consts.ScopesKey,
+}
+
+// oauth2Tokens caches the access tokens of all connections in memory, idle tokens are evicted.
+var oauth2Tokens = &oauth2TokenCache{tokens: map[string]*oauth2Token{}}
+
+type (
+	// OAuth2Config configures the OAuth2 flows of connections with a client id and secret, or a refresh token.
+	// the connection's TOKEN_URL and SCOPES override it.
+	OAuth2Config struct {
+		TokenURL         string   // defaults to the token url of the spec's oauth2 security scheme
+		Scopes           []string // not sent by default
+		ClientAuthInBody bool     // send the client id and secret as form params instead of basic auth
+	}
+
+	// oauth2Grant is the token request of a connection.
+	oauth2Grant struct {
+		tokenURL         string
+		clientID         string
+		clientSecret     string
+		refreshToken     string
+		scopes           string
+		clientAuthInBody bool
+	}
+
+	oauth2Authenticator struct {
+		client *http.Client
+		grant  oauth2Grant
+		token  *oauth2Token
+	}
+
+	// oauth2Token is the cached token of a grant, its mutex makes concurrent requests wait for a single token request.
+	oauth2Token struct {
+		mutex        sync.Mutex
+		accessToken  string
+		refreshToken string    // providers may rotate the refresh token on every refresh
+		expiry       time.Time // zero when the provider didn't say, the token is used until it's rejected
+		rotated      int32     // set atomically when the provider returned a refresh token, read by the eviction
+		lastUsed     time.Time // guarded by the cache mutex
+	}
+
+	oauth2TokenCache struct {
+		mutex     sync.Mutex
+		tokens    map[string]*oauth2Token
+		lastEvict time.Time
+	}
+
+	// tokenSource requests a new token, with the latest refresh token of the cached token when there's one.
+	tokenSource func(ctx context.Context, refreshToken string) (oauth2TokenResponse, error)
+
+	oauth2TokenResponse struct {
+		AccessToken  string      `json:"access_token"`
+		RefreshToken string      `json:"refresh_token"`
+		ExpiresIn    json.Number `json:"expires_in"`
+	}
+)
+
+// specOAuth2Config returns the token url of the spec's first oauth2 security scheme,
+// client credentials flows are preferred over flows that only refresh tokens.
+func specOAuth2Config(openApi *openapi3.T, requestUrl string) OAuth2Config {
+	names := make([]string, 0, len(openApi.Components.SecuritySchemes))
+	for name := range openApi.Components.SecuritySchemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		scheme := openApi.Components.SecuritySchemes[name]
+		if scheme == nil || scheme.Value == nil || scheme.Value.Type != "oauth2" || scheme.Value.Flows == nil {
+			continue
+		}
+
+		flows := scheme.Value.Flows
+		for _, flow := range []*openapi3.OAuthFlow{flows.ClientCredentials, flows.AuthorizationCode, flows.Password} {
+			if flow != nil && flow.TokenURL != "" {
+				return OAuth2Config{TokenURL: resolveURL(requestUrl, flow.TokenURL)}
+			}
+		}
+	}
+
+	return OAuth2Config{}
+}
+
+// resolveURL resolves urls that are relative to the server url, like /oauth/token.
+func resolveURL(baseUrl string, reference string) string {
+	base, err := url.Parse(baseUrl)
+	if err != nil {
+		return reference
+	}
+
+	resolved, err := base.Parse(reference)
+	if err != nil {
+		return reference
+	}
+
+	return resolved.String()
+}
+
+// withDefaults returns the config, completed by the spec config.
+func (c OAuth2Config) withDefaults(specConfig OAuth2Config) OAuth2Config {
+	if c.TokenURL == "" {
+		c.TokenURL = specConfig.TokenURL
+	}
+
+	return c
+}
+
+// newOAuth2Authenticator returns the OAuth2 authenticator of the connection,
+// nil when there's no token url or the connection has neither a refresh token nor client credentials.
+func newOAuth2Authenticator(connection map[string]string, client *http.Client, config OAuth2Config) *oauth2Authenticator {
+	grant := oauth2Grant{
+		tokenURL:         config.TokenURL,
+		clientID:         connectionValue(connection, consts.ClientIdKey),
+		clientSecret:     connectionValue(connection, consts.ClientSecretKey),
+		refreshToken:     connectionValue(connection, consts.RefreshTokenKey),
+		scopes:           strings.Join(config.Scopes, " "),
+		clientAuthInBody: config.ClientAuthInBody,
+	}
+
+	if tokenURL := connectionValue(connection, consts.TokenUrlKey); tokenURL != "" {
+		grant.tokenURL = tokenURL
+	}
+	if scopes := connectionValue(connection, consts.ScopesKey); scopes != "" {
+		grant.scopes = strings.Join(strings.FieldsFunc(scopes, func(r rune) bool { return r == ',' || r == ' ' }), " ")
+	}
+
+	if grant.tokenURL == "" || (grant.refreshToken == "" && (grant.clientID == "" || grant.clientSecret == "")) {
+		return nil
+	}
+
+	token := oauth2Tokens.get(grant.tokenURL, grant.clientID, grant.clientSecret, grant.refreshToken, grant.scopes)
+	return &oauth2Authenticator{client: client, grant: grant, token: token}
+}
+
+func (a *oauth2Authenticator) authenticate(request *http.Request) error {
+	accessToken, err := a.token.get(request.Context(), "", a.requestToken)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Authorization", consts.BearerAuth+accessToken)
+	return nil
+}
+
+// reauthenticate renews the token when it was rejected before its expiry, like a revoked token.
+func (a *oauth2Authenticator) reauthenticate(request *http.Request, result Result) (bool, error) {
+	if result.StatusCode != http.StatusUnauthorized {
+		return false, nil
+	}
+
+	log.Warnf("The OAuth2 token was rejected by %s %s, requesting a new token", request.Method, request.URL.Path)
+	return renewBearerToken(request, a.token, a.requestToken)
+}
+
+// renewBearerToken replaces the rejected bearer token of the request, it returns whether the request should be sent again.
+func renewBearerToken(request *http.Request, token *oauth2Token, fetch tokenSource) (bool, error) {
+	rejected := strings.TrimPrefix(request.Header.Get("Authorization"), consts.BearerAuth)
+	if _, err := token.get(request.Context(), rejected, fetch); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// get returns the cached token, a new token is requested when it expired or it is the rejected token.
+// when another request already replaced the rejected token, the new token is used as is.
+func (t *oauth2Token) get(ctx context.Context, rejected string, fetch tokenSource) (string, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.accessToken != "" && t.accessToken != rejected && (t.expiry.IsZero() || time.Now().Add(oauth2ExpiryLeeway).Before(t.expiry)) {
+		return t.accessToken, nil
+	}
+
+	response, err := fetch(ctx, t.refreshToken)
+	if err != nil {
+		return "", err
+	}
+
+	t.accessToken = response.AccessToken
+	t.expiry = time.Time{}
+	if seconds, err := response.ExpiresIn.Int64(); err == nil && seconds > 0 {
+		t.expiry = time.Now().Add(time.Duration(seconds) * time.Second)
+	}
+	if response.RefreshToken != "" {
+		t.refreshToken = response.RefreshToken
+		atomic.StoreInt32(&t.rotated, 1)
+	}
+
+	return t.accessToken, nil
+}
+
+// requestToken requests a token with the latest refresh token, or with the client credentials when there's none.
+func (a *oauth2Authenticator) requestToken(ctx context.Context, refreshToken string) (oauth2TokenResponse, error) {
+	if refreshToken == "" {
+		refreshToken = a.grant.refreshToken
+	}
+
+	form := url.Values{}
+	if refreshToken != "" {
+		form.Set("grant_type", oauth2GrantRefreshToken)
+		form.Set("refresh_token", refreshToken)
+	} else {
+		form.Set("grant_type", oauth2GrantClientCredentials)
+	}
+	if a.grant.scopes != "" {
+		form.Set("scope", a.grant.scopes)
+	}
+
+	// public clients only have a client id, it is sent in the body.
+	if a.grant.clientAuthInBody || a.grant.clientSecret == "" {
+		if a.grant.clientID != "" {
+			form.Set("client_id", a.grant.clientID)
+		}
+		if a.grant.clientSecret != "" {
+			form.Set("client_secret", a.grant.clientSecret)
+		}
+	}
+
+	var username, password string
+	if !a.grant.clientAuthInBody && a.grant.clientSecret != "" {
+		username, password = url.QueryEscape(a.grant.clientID), url.QueryEscape(a.grant.clientSecret)
+	}
+
+	return postTokenRequest(ctx, a.client, a.grant.tokenURL, form, username, password)
+}
+
+// postTokenRequest posts the form to the token endpoint, with basic auth when there's a username.
+func postTokenRequest(ctx context.Context, client *http.Client, tokenURL string, form url.Values, username string, password string) (oauth2TokenResponse, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return oauth2TokenResponse{}, errors.Wrap(err, "invalid OAuth2 token url")
+	}
+	request.Header.Set(consts.ContentTypeHeader, consts.URLEncoded)
+	request.Header.Set("Accept", consts.RequestBodyType)
+	if username != "" {
+		request.SetBasicAuth(username, password)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return oauth2TokenResponse{}, errors.Wrap(err, "failed to request an OAuth2 token")
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxTokenResponseSize))
+	if err != nil {
+		return oauth2TokenResponse{}, errors.Wrap(err, "failed to read the OAuth2 token response")
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return oauth2TokenResponse{}, &ActionError{
+			Category:        ErrorCategoryAuth,
+			Message:         "Failed to get an OAuth2 token, status " + strconv.Itoa(response.StatusCode),
+			StatusCode:      response.StatusCode,
+			ProviderMessage: providerMessage(body),
+		}
+	}
+
+	tokenResponse, err := parseTokenResponse(response.Header.Get(consts.ContentTypeHeader), body)
+	if err != nil {
+		return oauth2TokenResponse{}, err
+	}
+
+	if tokenResponse.AccessToken == "" {
+		return oauth2TokenResponse{}, &ActionError{Category: ErrorCategoryAuth, Message: "The OAuth2 token response doesn't have an access token"}
+	}
+
+	return tokenResponse, nil
+}
+
+// parseTokenResponse parses JSON token responses, and the form encoded responses of older providers.
+func parseTokenResponse(contentType string, body []byte) (oauth2TokenResponse, error) {
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == consts.URLEncoded || mediaType == "text/plain" {
+		if values, err := url.ParseQuery(string(body)); err == nil && values.Get("access_token") != "" {
+			return oauth2TokenResponse{
+				AccessToken:  values.Get("access_token"),
+				RefreshToken: values.Get("refresh_token"),
+				ExpiresIn:    json.Number(values.Get("expires_in")),
+			}, nil
+		}
+	}
+
+	tokenResponse := oauth2TokenResponse{}
+	if err := json.Unmarshal(body, &tokenResponse); err != nil {
+		return tokenResponse, errors.Wrap(err, "failed to parse the OAuth2 token response")
+	}
+
+	return tokenResponse, nil
+}
+
+// get returns the token of the credentials, the same one for all the requests of the connection.
+func (c *oauth2TokenCache) get(credentials ...string) *oauth2Token {
+	hash := sha256.New()
+	for _, value := range credentials {
+		hash.Write([]byte(value))
+		hash.Write([]byte{0})
+	}
+	key := hex.EncodeToString(hash.Sum(nil))
+	now := time.Now()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if now.Sub(c.lastEvict) >= oauth2TokenIdleTTL {
+		c.evictIdle(now)
+	}
+
+	token, ok := c.tokens[key]
+	if !ok {
+		token = &oauth2Token{}
+		c.tokens[key] = token
+	}
+	token.lastUsed = now
+
+	return token
+}
+
+// evictIdle removes the tokens that are idle at now, so credentials that are no longer used don't keep their tokens.
+// the caller must hold the lock.
+func (c *oauth2TokenCache) evictIdle(now time.Time) {
+	for key, token := range c.tokens {
+		if token.idle(now) {
+			delete(c.tokens, key)
+		}
+	}
+
+	c.lastEvict = now
+}
+
+// idle returns whether the token wasn't used for its idle ttl, the cache lock must be held.
+// the token mutex isn't locked since it's held while a new token is requested.
+func (t *oauth2Token) idle(now time.Time) bool {
+	ttl := oauth2TokenIdleTTL
+	if atomic.LoadInt32(&t.rotated) == 1 {
+		ttl = oauth2RotatedTokenIdleTTL
+	}
+
+	return now.Sub(t.lastUsed) >= ttl
+}
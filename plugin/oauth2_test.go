@@ -0,0 +1,246 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type OAuth2TestSuite struct {
+	suite.Suite
+	tokenServer   *httptest.Server
+	apiServer     *httptest.Server
+	tokenRequests int32
+	expiresIn     int
+	tokenForms    chan map[string]string
+	rejectedToken string // the api server rejects this token like a revoked token, * rejects all the tokens
+}
+
+func (suite *OAuth2TestSuite) SetupTest() {
+	suite.tokenRequests = 0
+	suite.expiresIn = 3600
+	suite.rejectedToken = ""
+	suite.tokenForms = make(chan map[string]string, 100)
+
+	suite.tokenServer = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		count := atomic.AddInt32(&suite.tokenRequests, 1)
+		_ = req.ParseForm()
+
+		form := map[string]string{}
+		for name := range req.PostForm {
+			form[name] = req.PostForm.Get(name)
+		}
+		if username, password, ok := req.BasicAuth(); ok {
+			form["basic"] = username + ":" + password
+		}
+		suite.tokenForms <- form
+
+		res.Header().Set(consts.ContentTypeHeader, consts.RequestBodyType)
+		if form["client_secret"] == "wrong" || strings.HasSuffix(form["basic"], ":wrong") {
+			res.WriteHeader(http.StatusUnauthorized)
+			_, _ = res.Write([]byte(`{"error": "invalid_client", "error_description": "Client authentication failed"}`))
+			return
+		}
+
+		_, _ = fmt.Fprintf(res, `{"access_token": "token-%d", "token_type": "bearer", "expires_in": %d, "refresh_token": "refresh-%d"}`, count, suite.expiresIn, count)
+	}))
+
+	suite.apiServer = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		authorization := req.Header.Get("Authorization")
+		if authorization == "" || suite.rejectedToken == "*" || authorization == consts.BearerAuth+suite.rejectedToken {
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(req.Body)
+		_, _ = fmt.Fprintf(res, `{"authorization": %q, "body": %q, "client_id": %q}`, authorization, body, req.Header.Get(consts.ClientIdKey))
+	}))
+}
+
+func (suite *OAuth2TestSuite) TearDownTest() {
+	suite.tokenServer.Close()
+	suite.apiServer.Close()
+}
+
+func (suite *OAuth2TestSuite) send(connection map[string]string, config OAuth2Config, body string) Result {
+	request, err := http.NewRequest(http.MethodPost, suite.apiServer.URL+"/users", strings.NewReader(body))
+	require.NoError(suite.T(), err)
+
+	result, err := executeRequestWithCredentials(connection, request, requestOptions{oauth2: config, timeout: 5})
+	require.NoError(suite.T(), err)
+	return result
+}
+
+func (suite *OAuth2TestSuite) nextTokenForm() map[string]string {
+	select {
+	case form := <-suite.tokenForms:
+		return form
+	default:
+		suite.T().Fatal("no token request was sent")
+		return nil
+	}
+}
+
+func (suite *OAuth2TestSuite) TestClientCredentials() {
+	connection := map[string]string{consts.ClientIdKey: "client", consts.ClientSecretKey: "secret", consts.ScopesKey: "read, write"}
+	config := OAuth2Config{TokenURL: suite.tokenServer.URL}
+
+	for i := 0; i < 3; i++ {
+		result := suite.send(connection, config, "jane")
+		assert.Equal(suite.T(), http.StatusOK, result.StatusCode)
+		assert.JSONEq(suite.T(), `{"authorization": "Bearer token-1", "body": "jane", "client_id": ""}`, string(result.Body))
+	}
+
+	// the token is cached until it expires.
+	assert.Equal(suite.T(), int32(1), atomic.LoadInt32(&suite.tokenRequests))
+	assert.Equal(suite.T(), map[string]string{"grant_type": "client_credentials", "scope": "read write", "basic": "client:secret"}, suite.nextTokenForm())
+}
+
+func (suite *OAuth2TestSuite) TestClientAuthInBody() {
+	connection := map[string]string{consts.ClientIdKey: "client", consts.ClientSecretKey: "secret", consts.TokenUrlKey: suite.tokenServer.URL}
+
+	suite.send(connection, OAuth2Config{ClientAuthInBody: true, Scopes: []string{"users"}}, "")
+	assert.Equal(suite.T(), map[string]string{"grant_type": "client_credentials", "scope": "users", "client_id": "client", "client_secret": "secret"}, suite.nextTokenForm())
+}
+
+func (suite *OAuth2TestSuite) TestExpiredToken() {
+	// tokens that expire within the leeway are renewed before every request.
+	suite.expiresIn = 10
+	connection := map[string]string{consts.ClientIdKey: "client", consts.ClientSecretKey: "expiring"}
+
+	suite.send(connection, OAuth2Config{TokenURL: suite.tokenServer.URL}, "")
+	result := suite.send(connection, OAuth2Config{TokenURL: suite.tokenServer.URL}, "")
+	assert.Contains(suite.T(), string(result.Body), "token-2")
+}
+
+func (suite *OAuth2TestSuite) TestRefreshOnUnauthorized() {
+	connection := map[string]string{consts.RefreshTokenKey: "initial-refresh", consts.ClientIdKey: "client", consts.ClientSecretKey: "secret"}
+	config := OAuth2Config{TokenURL: suite.tokenServer.URL}
+
+	result := suite.send(connection, config, "")
+	assert.Contains(suite.T(), string(result.Body), "token-1")
+	assert.Equal(suite.T(), map[string]string{"grant_type": "refresh_token", "refresh_token": "initial-refresh", "basic": "client:secret"}, suite.nextTokenForm())
+
+	// the token was revoked, it is refreshed once with the rotated refresh token and the request is sent again with its body.
+	suite.rejectedToken = "token-1"
+	result = suite.send(connection, config, "jane")
+	assert.Equal(suite.T(), http.StatusOK, result.StatusCode)
+	assert.JSONEq(suite.T(), `{"authorization": "Bearer token-2", "body": "jane", "client_id": ""}`, string(result.Body))
+	assert.Equal(suite.T(), "refresh-1", suite.nextTokenForm()["refresh_token"])
+
+	// a single refresh is attempted, the second 401 is returned as is.
+	suite.rejectedToken = "*"
+	result = suite.send(connection, config, "")
+	assert.Equal(suite.T(), http.StatusUnauthorized, result.StatusCode)
+	assert.Equal(suite.T(), int32(3), atomic.LoadInt32(&suite.tokenRequests))
+}
+
+func (suite *OAuth2TestSuite) TestPublicClient() {
+	connection := map[string]string{consts.RefreshTokenKey: "public-refresh", consts.ClientIdKey: "public"}
+
+	suite.send(connection, OAuth2Config{TokenURL: suite.tokenServer.URL}, "")
+	assert.Equal(suite.T(), map[string]string{"grant_type": "refresh_token", "refresh_token": "public-refresh", "client_id": "public"}, suite.nextTokenForm())
+}
+
+func (suite *OAuth2TestSuite) TestTokenFailure() {
+	request, err := http.NewRequest(http.MethodGet, suite.apiServer.URL, nil)
+	require.NoError(suite.T(), err)
+
+	connection := map[string]string{consts.ClientIdKey: "client", consts.ClientSecretKey: "wrong"}
+	_, err = executeRequestWithCredentials(connection, request, requestOptions{oauth2: OAuth2Config{TokenURL: suite.tokenServer.URL}})
+	require.Error(suite.T(), err)
+
+	actionErr := newActionError(err, ErrorCategoryClient)
+	assert.Equal(suite.T(), ErrorCategoryAuth, actionErr.Category)
+	assert.Equal(suite.T(), http.StatusUnauthorized, actionErr.StatusCode)
+	assert.Equal(suite.T(), "Client authentication failed", actionErr.ProviderMessage)
+}
+
+func (suite *OAuth2TestSuite) TestStaticHeaders() {
+	// without a token url the connection keys are sent as headers, like before.
+	result := suite.send(map[string]string{consts.ClientIdKey: "client", "Authorization": "Bearer static"}, OAuth2Config{}, "")
+	assert.JSONEq(suite.T(), `{"authorization": "Bearer static", "body": "", "client_id": "client"}`, string(result.Body))
+	assert.Equal(suite.T(), int32(0), atomic.LoadInt32(&suite.tokenRequests))
+}
+
+func (suite *OAuth2TestSuite) TestConcurrentRequests() {
+	connection := map[string]string{consts.ClientIdKey: "client", consts.ClientSecretKey: "concurrent"}
+	config := OAuth2Config{TokenURL: suite.tokenServer.URL}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			request, err := http.NewRequest(http.MethodGet, suite.apiServer.URL, nil)
+			if err != nil {
+				return
+			}
+			_, _ = executeRequestWithCredentials(connection, request, requestOptions{oauth2: config, timeout: 5})
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(suite.T(), int32(1), atomic.LoadInt32(&suite.tokenRequests))
+}
+
+func (suite *OAuth2TestSuite) TestEvictIdleTokens() {
+	tokens := &oauth2TokenCache{tokens: map[string]*oauth2Token{}}
+	idle := tokens.get("https://example.com/token", "idle")
+	rotated := tokens.get("https://example.com/token", "rotated")
+	active := tokens.get("https://example.com/token", "active")
+
+	// the idle and rotated tokens weren't used for an hour, the rotated one holds a refresh token of the provider.
+	now := time.Now()
+	idle.lastUsed = now.Add(-oauth2TokenIdleTTL)
+	rotated.lastUsed = now.Add(-oauth2TokenIdleTTL)
+	_, err := rotated.get(context.Background(), "", func(ctx context.Context, refreshToken string) (oauth2TokenResponse, error) {
+		return oauth2TokenResponse{AccessToken: "token", RefreshToken: "rotated-refresh"}, nil
+	})
+	require.NoError(suite.T(), err)
+
+	tokens.lastEvict = now.Add(-oauth2TokenIdleTTL)
+	tokens.get("https://example.com/token", "other")
+	assert.Len(suite.T(), tokens.tokens, 3)
+	assert.NotSame(suite.T(), idle, tokens.get("https://example.com/token", "idle"))
+	assert.Same(suite.T(), rotated, tokens.get("https://example.com/token", "rotated"))
+	assert.Same(suite.T(), active, tokens.get("https://example.com/token", "active"))
+
+	// a rotated refresh token is dropped once it's unused for its longer ttl.
+	rotated.lastUsed = now.Add(-oauth2RotatedTokenIdleTTL)
+	tokens.evictIdle(now)
+	assert.NotSame(suite.T(), rotated, tokens.get("https://example.com/token", "rotated"))
+}
+
+func (suite *OAuth2TestSuite) TestSpecTokenURL() {
+	openApi := &openapi3.T{Components: openapi3.Components{SecuritySchemes: openapi3.SecuritySchemes{
+		"apiKey": {Value: &openapi3.SecurityScheme{Type: "apiKey", Name: "X-Api-Key", In: "header"}},
+		"oauth": {Value: &openapi3.SecurityScheme{Type: "oauth2", Flows: &openapi3.OAuthFlows{
+			AuthorizationCode: &openapi3.OAuthFlow{AuthorizationURL: "https://example.com/authorize", TokenURL: "https://example.com/code/token"},
+			ClientCredentials: &openapi3.OAuthFlow{TokenURL: "/oauth/token"},
+		}}},
+	}}}
+
+	config := specOAuth2Config(openApi, "https://api.example.com/v1")
+	assert.Equal(suite.T(), "https://api.example.com/oauth/token", config.TokenURL)
+	assert.Equal(suite.T(), "https://api.example.com/oauth/token", OAuth2Config{}.withDefaults(config).TokenURL)
+	assert.Equal(suite.T(), "https://other.com/token", OAuth2Config{TokenURL: "https://other.com/token"}.withDefaults(config).TokenURL)
+	assert.Equal(suite.T(), OAuth2Config{}, specOAuth2Config(&openapi3.T{}, ""))
+}
+
+func TestOAuth2Suite(t *testing.T) {
+	suite.Run(t, new(OAuth2TestSuite))
+}
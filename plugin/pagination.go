@@ -0,0 +1,372 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	"github.com/blinkops/blink-openapi-sdk/mask"
+	"github.com/blinkops/blink-openapi-sdk/plugin/handlers"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultStartPage = 1
+
+	// defaultMaxPages stops endless pagination, like APIs that keep returning a next cursor, when the mask sets no max_pages.
+	defaultMaxPages = 1000
+)
+
+var (
+	linkNextRe = regexp.MustCompile(`<([^>]*)>\s*;[^,]*rel="?next"?`)
+
+	// common query param names, used when the mask doesn't name the pagination params explicitly.
+	cursorParamNames = []string{"cursor", "after", "page_token", "pageToken", "next_token", "nextToken", "starting_after"}
+	offsetParamNames = []string{"offset", "startAt", "start", "skip"}
+	pageParamNames   = []string{"page", "page_number", "pageNumber"}
+	limitParamNames  = []string{"limit", "per_page", "page_size", "pageSize", "maxResults", "count"}
+)
+
+// paginator follows the pages of a list endpoint and merges their results into one response.
+type paginator struct {
+	mask.Pagination
+}
+
+// getPaginator returns the paginator of an action, or nil when the action is not paginated.
+// pagination params that aren't set in the mask are looked up in the operation's query params.
+func (p *openApiPlugin) getPaginator(actionName string) *paginator {
+	maskedAction := p.mask.GetAction(actionName)
+	if maskedAction == nil || maskedAction.Pagination == nil {
+		return nil
+	}
+
+	pg := &paginator{Pagination: *maskedAction.Pagination}
+	operation := p.operations.Get(p.mask.ReplaceActionAlias(actionName))
+	if operation != nil {
+		pg.resolveParams(operation)
+	}
+
+	if err := pg.validate(); err != nil {
+		log.Warnf("Pagination of %s is disabled: %v", actionName, err)
+		return nil
+	}
+
+	return pg
+}
+
+func (pg *paginator) resolveParams(operation *handlers.OperationDefinition) {
+	if pg.CursorParam == "" {
+		pg.CursorParam = findQueryParam(operation, cursorParamNames)
+	}
+	if pg.OffsetParam == "" {
+		pg.OffsetParam = findQueryParam(operation, offsetParamNames)
+	}
+	if pg.PageParam == "" {
+		pg.PageParam = findQueryParam(operation, pageParamNames)
+	}
+	if pg.LimitParam == "" {
+		pg.LimitParam = findQueryParam(operation, limitParamNames)
+	}
+}
+
+func (pg *paginator) validate() error {
+	switch pg.Type {
+	case mask.PaginationLink:
+	case mask.PaginationCursor:
+		if pg.CursorParam == "" || pg.CursorPath == "" {
+			return errors.New("cursor pagination requires a cursor param and a cursor path")
+		}
+	case mask.PaginationOffset:
+		if pg.OffsetParam == "" {
+			return errors.New("offset pagination requires an offset param")
+		}
+	case mask.PaginationPage:
+		if pg.PageParam == "" {
+			return errors.New("page pagination requires a page param")
+		}
+	default:
+		return errors.Errorf("unknown pagination type: %s", pg.Type)
+	}
+
+	return nil
+}
+
+// findQueryParam returns the name of the first operation query param that matches one of the candidates.
+func findQueryParam(operation *handlers.OperationDefinition, candidates []string) string {
+	for _, candidate := range candidates {
+		for _, queryParam := range operation.QueryParams {
+			if strings.EqualFold(queryParam.ParamName, candidate) {
+				return queryParam.ParamName
+			}
+		}
+	}
+
+	return ""
+}
+
+// execute sends the request and keeps requesting the next page until there are no more pages,
+// or the max_pages/max_items limits are reached, max_pages defaults to defaultMaxPages.
+// a failed page is returned as is, so it will be handled by the response validation.
+func (pg *paginator) execute(requestSender sender, request *http.Request) (Result, error) {
+	var (
+		firstBody interface{}
+		items     []interface{}
+		result    Result
+		err       error
+	)
+
+	pg.prepareFirstRequest(request)
+
+	maxPages := pg.MaxPages
+	if maxPages <= 0 {
+		maxPages = defaultMaxPages
+	}
+
+	for page, nextRequest := 0, request; nextRequest != nil; page++ {
+		if page >= maxPages {
+			if pg.MaxPages <= 0 {
+				log.Warnf("Stopped paginating after %d pages", defaultMaxPages)
+			}
+			break
+		}
+
+		if result, err = requestSender.send(nextRequest); err != nil {
+			return result, err
+		}
+
+		// a truncated page can't be parsed, it is returned as is like a failed page.
+		if result.StatusCode < 200 || result.StatusCode > 299 || result.Truncated {
+			return result, nil
+		}
+
+		body, err := decodeJSON(result.Body)
+		if err != nil {
+			return result, errors.Wrap(err, "failed to parse paginated response")
+		}
+
+		pageItems, err := pg.getItems(body)
+		if err != nil {
+			return result, err
+		}
+
+		if firstBody == nil {
+			firstBody = body
+		}
+		items = append(items, pageItems...)
+
+		if pg.MaxItems > 0 && len(items) >= pg.MaxItems {
+			items = items[:pg.MaxItems]
+			break
+		}
+
+		if nextRequest, err = pg.nextRequest(nextRequest, result, body, len(pageItems)); err != nil {
+			return result, err
+		}
+	}
+
+	result.Body, err = pg.mergeItems(firstBody, items)
+	return result, err
+}
+
+// prepareFirstRequest sets the page size and the first page/offset when the user didn't set them.
+func (pg *paginator) prepareFirstRequest(request *http.Request) {
+	query := request.URL.Query()
+
+	if pg.PageSize > 0 && pg.LimitParam != "" && query.Get(pg.LimitParam) == "" {
+		query.Set(pg.LimitParam, strconv.Itoa(pg.PageSize))
+	}
+
+	if pg.Type == mask.PaginationPage && query.Get(pg.PageParam) == "" {
+		query.Set(pg.PageParam, strconv.Itoa(pg.startPage()))
+	}
+
+	request.URL.RawQuery = query.Encode()
+}
+
+// nextRequest builds the request of the next page, it returns nil when there are no more pages.
+func (pg *paginator) nextRequest(request *http.Request, result Result, body interface{}, pageItemsCount int) (*http.Request, error) {
+	switch pg.Type {
+	case mask.PaginationLink:
+		match := linkNextRe.FindStringSubmatch(result.Header.Get("Link"))
+		if match == nil {
+			return nil, nil
+		}
+
+		nextUrl, err := request.URL.Parse(match[1])
+		if err != nil {
+			return nil, err
+		}
+
+		// the request has the connection's credentials, they are only sent to the origin of the first page.
+		if !strings.EqualFold(nextUrl.Scheme, request.URL.Scheme) || !strings.EqualFold(nextUrl.Host, request.URL.Host) {
+			return nil, errors.Errorf("the next page %s://%s isn't on the host of the request, it isn't followed with the connection's credentials", nextUrl.Scheme, nextUrl.Host)
+		}
+
+		return cloneRequest(request, nextUrl)
+
+	case mask.PaginationCursor:
+		cursor := getValueByPath(body, pg.CursorPath)
+		if cursor == nil || cursor == "" || cursor == false {
+			return nil, nil
+		}
+
+		// a cursor that points at the page itself would return it forever.
+		nextCursor := fmt.Sprintf("%v", cursor)
+		if nextCursor == request.URL.Query().Get(pg.CursorParam) {
+			return nil, nil
+		}
+
+		return pg.withQueryParam(request, pg.CursorParam, nextCursor)
+
+	case mask.PaginationOffset:
+		if pg.isLastPage(request, pageItemsCount) {
+			return nil, nil
+		}
+
+		offset, _ := strconv.Atoi(request.URL.Query().Get(pg.OffsetParam))
+		return pg.withQueryParam(request, pg.OffsetParam, strconv.Itoa(offset+pageItemsCount))
+
+	case mask.PaginationPage:
+		if pg.isLastPage(request, pageItemsCount) {
+			return nil, nil
+		}
+
+		page, err := strconv.Atoi(request.URL.Query().Get(pg.PageParam))
+		if err != nil {
+			page = pg.startPage()
+		}
+		return pg.withQueryParam(request, pg.PageParam, strconv.Itoa(page+1))
+	}
+
+	return nil, nil
+}
+
+// isLastPage checks if a page is the last one by comparing the amount of items in it to the requested page size.
+func (pg *paginator) isLastPage(request *http.Request, pageItemsCount int) bool {
+	if pageItemsCount == 0 {
+		return true
+	}
+
+	if pg.LimitParam == "" {
+		return false
+	}
+
+	limit, err := strconv.Atoi(request.URL.Query().Get(pg.LimitParam))
+	return err == nil && pageItemsCount < limit
+}
+
+func (pg *paginator) startPage() int {
+	if pg.StartPage != 0 {
+		return pg.StartPage
+	}
+
+	return defaultStartPage
+}
+
+func (pg *paginator) withQueryParam(request *http.Request, paramName string, paramValue string) (*http.Request, error) {
+	nextUrl := *request.URL
+	query := nextUrl.Query()
+	query.Set(paramName, paramValue)
+	nextUrl.RawQuery = query.Encode()
+
+	return cloneRequest(request, &nextUrl)
+}
+
+// getItems returns the results array of a page.
+func (pg *paginator) getItems(body interface{}) ([]interface{}, error) {
+	value := getValueByPath(body, pg.ResultsPath)
+	if value == nil {
+		return nil, nil
+	}
+
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("paginated results at '%s' are not an array", pg.ResultsPath)
+	}
+
+	return items, nil
+}
+
+// mergeItems puts all the collected items in place of the results of the first page.
+func (pg *paginator) mergeItems(firstBody interface{}, items []interface{}) ([]byte, error) {
+	if items == nil {
+		items = []interface{}{}
+	}
+
+	if pg.ResultsPath == "" {
+		return json.Marshal(items)
+	}
+
+	setValueByPath(firstBody, pg.ResultsPath, items)
+	return json.Marshal(firstBody)
+}
+
+// cloneRequest copies a request with a new url, the body is rewound when possible.
+func cloneRequest(request *http.Request, requestUrl *url.URL) (*http.Request, error) {
+	clone := request.Clone(request.Context())
+	clone.URL = requestUrl
+	clone.Host = requestUrl.Host
+
+	if request.GetBody != nil {
+		body, err := request.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		clone.Body = body
+	}
+
+	return clone, nil
+}
+
+func decodeJSON(data []byte) (interface{}, error) {
+	var body interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&body); err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+// getValueByPath returns the value at a "." delimited path of a decoded json body.
+func getValueByPath(body interface{}, path string) interface{} {
+	if path == "" {
+		return body
+	}
+
+	for _, key := range strings.Split(path, consts.BodyParamDelimiter) {
+		object, ok := body.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		body = object[key]
+	}
+
+	return body
+}
+
+// setValueByPath sets the value at a "." delimited path of a decoded json body.
+func setValueByPath(body interface{}, path string, value interface{}) {
+	keys := strings.Split(path, consts.BodyParamDelimiter)
+
+	for _, key := range keys[:len(keys)-1] {
+		object, ok := body.(map[string]interface{})
+		if !ok {
+			return
+		}
+		body = object[key]
+	}
+
+	if object, ok := body.(map[string]interface{}); ok {
+		object[keys[len(keys)-1]] = value
+	}
+}
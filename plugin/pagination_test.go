@@ -0,0 +1,239 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/blinkops/blink-openapi-sdk/mask"
+	"github.com/blinkops/blink-openapi-sdk/plugin/handlers"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	totalTestItems = 7
+
+	paginatedOpenApi = `
+openapi: 3.0.0
+info:
+  title: items
+  version: 1.0.0
+paths:
+  /items:
+    get:
+      operationId: ListItems
+      parameters:
+        - name: per_page
+          in: query
+          schema:
+            type: integer
+        - name: page
+          in: query
+          schema:
+            type: integer
+      responses:
+        "200":
+          description: items
+`
+)
+
+type PaginationTestSuite struct {
+	suite.Suite
+	server   *httptest.Server
+	requests int
+}
+
+// the test server holds 7 items and serves them with every pagination strategy.
+func (suite *PaginationTestSuite) SetupTest() {
+	suite.requests = 0
+	suite.server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		suite.requests++
+		query := req.URL.Query()
+		limit, err := strconv.Atoi(query.Get("limit"))
+		if err != nil {
+			limit = 3
+		}
+
+		var start int
+		switch req.URL.Path {
+		case "/link", "/cursor":
+			start, _ = strconv.Atoi(query.Get("cursor"))
+		case "/offset":
+			start, _ = strconv.Atoi(query.Get("offset"))
+		case "/page":
+			page, _ := strconv.Atoi(query.Get("page"))
+			start = (page - 1) * limit
+		case "/error":
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		case "/other-host":
+			res.Header().Set("Link", `<https://other.example.com/link?cursor=3>; rel="next"`)
+		case "/repeated-cursor":
+			_, _ = res.Write([]byte(`{"items": [1], "next": "same"}`))
+			return
+		case "/endless":
+			cursor, _ := strconv.Atoi(query.Get("cursor"))
+			_, _ = fmt.Fprintf(res, `{"items": [%d], "next": %d}`, cursor, cursor+1)
+			return
+		}
+
+		end := start + limit
+		if end > totalTestItems {
+			end = totalTestItems
+		}
+
+		var items []int
+		for i := start; i < end; i++ {
+			items = append(items, i)
+		}
+
+		body := map[string]interface{}{"data": map[string]interface{}{"items": items}, "total": totalTestItems}
+		if end < totalTestItems && req.URL.Path != "/other-host" {
+			body["next"] = strconv.Itoa(end)
+			res.Header().Set("Link", fmt.Sprintf(`<%s/link?cursor=%d&limit=%d>; rel="next", <%s/link>; rel="first"`, suite.server.URL, end, limit, suite.server.URL))
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		if req.URL.Path == "/link" || req.URL.Path == "/other-host" {
+			_ = json.NewEncoder(res).Encode(items)
+			return
+		}
+		_ = json.NewEncoder(res).Encode(body)
+	}))
+}
+
+func (suite *PaginationTestSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+func (suite *PaginationTestSuite) execute(path string, pagination mask.Pagination) Result {
+	request, err := http.NewRequest(http.MethodGet, suite.server.URL+path, nil)
+	require.NoError(suite.T(), err)
+
+	result, err := (&paginator{Pagination: pagination}).execute(sender{client: &http.Client{}}, request)
+	require.NoError(suite.T(), err)
+
+	return result
+}
+
+func (suite *PaginationTestSuite) TestLinkPagination() {
+	result := suite.execute("/link", mask.Pagination{Type: mask.PaginationLink})
+
+	assert.Equal(suite.T(), http.StatusOK, result.StatusCode)
+	assert.JSONEq(suite.T(), `[0,1,2,3,4,5,6]`, string(result.Body))
+	assert.Equal(suite.T(), 3, suite.requests)
+}
+
+func (suite *PaginationTestSuite) TestCursorPagination() {
+	result := suite.execute("/cursor", mask.Pagination{
+		Type:        mask.PaginationCursor,
+		ResultsPath: "data.items",
+		CursorPath:  "next",
+		CursorParam: "cursor",
+	})
+
+	assert.JSONEq(suite.T(), `{"data":{"items":[0,1,2,3,4,5,6]},"total":7,"next":"3"}`, string(result.Body))
+	assert.Equal(suite.T(), 3, suite.requests)
+}
+
+func (suite *PaginationTestSuite) TestOffsetPagination() {
+	result := suite.execute("/offset", mask.Pagination{
+		Type:        mask.PaginationOffset,
+		ResultsPath: "data.items",
+		OffsetParam: "offset",
+		LimitParam:  "limit",
+		PageSize:    2,
+	})
+
+	assert.JSONEq(suite.T(), `{"data":{"items":[0,1,2,3,4,5,6]},"total":7,"next":"2"}`, string(result.Body))
+	assert.Equal(suite.T(), 4, suite.requests)
+}
+
+func (suite *PaginationTestSuite) TestPagePagination() {
+	result := suite.execute("/page", mask.Pagination{
+		Type:        mask.PaginationPage,
+		ResultsPath: "data.items",
+		PageParam:   "page",
+		LimitParam:  "limit",
+		PageSize:    3,
+	})
+
+	assert.JSONEq(suite.T(), `{"data":{"items":[0,1,2,3,4,5,6]},"total":7,"next":"3"}`, string(result.Body))
+	assert.Equal(suite.T(), 3, suite.requests)
+}
+
+func (suite *PaginationTestSuite) TestMaxPages() {
+	result := suite.execute("/link", mask.Pagination{Type: mask.PaginationLink, MaxPages: 2})
+
+	assert.JSONEq(suite.T(), `[0,1,2,3,4,5]`, string(result.Body))
+	assert.Equal(suite.T(), 2, suite.requests)
+}
+
+func (suite *PaginationTestSuite) TestMaxItems() {
+	result := suite.execute("/link", mask.Pagination{Type: mask.PaginationLink, MaxItems: 4})
+
+	assert.JSONEq(suite.T(), `[0,1,2,3]`, string(result.Body))
+	assert.Equal(suite.T(), 2, suite.requests)
+}
+
+func (suite *PaginationTestSuite) TestFailedPage() {
+	result := suite.execute("/error", mask.Pagination{Type: mask.PaginationLink})
+
+	assert.Equal(suite.T(), http.StatusInternalServerError, result.StatusCode)
+	assert.Equal(suite.T(), 1, suite.requests)
+}
+
+func (suite *PaginationTestSuite) TestOtherHostLink() {
+	request, err := http.NewRequest(http.MethodGet, suite.server.URL+"/other-host", nil)
+	require.NoError(suite.T(), err)
+	request.Header.Set("Authorization", "Bearer token")
+
+	_, err = (&paginator{Pagination: mask.Pagination{Type: mask.PaginationLink}}).execute(sender{client: &http.Client{}}, request)
+	require.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "https://other.example.com")
+	assert.Equal(suite.T(), 1, suite.requests)
+}
+
+func (suite *PaginationTestSuite) TestRepeatedCursor() {
+	result := suite.execute("/repeated-cursor", mask.Pagination{Type: mask.PaginationCursor, ResultsPath: "items", CursorPath: "next", CursorParam: "cursor"})
+
+	assert.JSONEq(suite.T(), `{"items": [1, 1], "next": "same"}`, string(result.Body))
+	assert.Equal(suite.T(), 2, suite.requests)
+}
+
+func (suite *PaginationTestSuite) TestDefaultMaxPages() {
+	result := suite.execute("/endless", mask.Pagination{Type: mask.PaginationCursor, ResultsPath: "items", CursorPath: "next", CursorParam: "cursor"})
+
+	assert.Equal(suite.T(), http.StatusOK, result.StatusCode)
+	assert.Equal(suite.T(), defaultMaxPages, suite.requests)
+}
+
+func (suite *PaginationTestSuite) TestGetPaginator() {
+	openApi, err := openapi3.NewLoader().LoadFromData([]byte(paginatedOpenApi))
+	require.NoError(suite.T(), err)
+	operations := handlers.NewOperationRegistry()
+	require.NoError(suite.T(), operations.Define(openApi))
+
+	p := &openApiPlugin{operations: operations, mask: mask.Mask{Actions: map[string]*mask.MaskedAction{
+		"ListItems":  {Pagination: &mask.Pagination{Type: mask.PaginationPage}},
+		"ListOthers": {Pagination: &mask.Pagination{Type: mask.PaginationCursor}},
+	}}}
+
+	pg := p.getPaginator("ListItems")
+	require.NotNil(suite.T(), pg)
+	assert.Equal(suite.T(), "page", pg.PageParam)
+	assert.Equal(suite.T(), "per_page", pg.LimitParam)
+
+	assert.Nil(suite.T(), p.getPaginator("ListOthers"))
+	assert.Nil(suite.T(), p.getPaginator("NotMasked"))
+}
+
+func TestPaginationSuite(t *testing.T) {
+	suite.Run(t, new(PaginationTestSuite))
+}
@@ -1,8 +1,8 @@
 package plugin
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
@@ -32,8 +32,12 @@ type (
 	JSONMap              interface{}
 	SetCustomAuthHeaders func(connection map[string]string, request *http.Request) error
 	Result               struct {
-		StatusCode int
-		Body       []byte
+		StatusCode     int
+		Body           []byte
+		Header         http.Header
+		SchemaWarnings []string     // problems found by the response validation in warn mode
+		Truncated      bool         // the body was cut at the response size limit
+		Error          *ActionError // the classified failure of unsuccessful responses, nil for 2xx responses
 	}
 )
 
@@ -45,6 +49,19 @@ type openApiPlugin struct {
 	headerAlias         HeaderAlias
 	mask                mask.Mask
 	callbacks           Callbacks
+	retryPolicy         RetryPolicy
+	rateLimiters        *rateLimiters
+	responseValidation  ResponseValidationMode
+	convertXMLResponses bool
+	responseSizeLimit   ResponseSizeLimit
+	clients             *clientCache
+	oauth2              OAuth2Config
+	securitySchemes     openapi3.SecuritySchemes
+	hmac                HMACConfig
+	session             *sessionLogin
+	digestAuth          bool
+	fileReferenceDir    string
+	operations          *handlers.OperationRegistry
 }
 
 type PluginMetadata struct {
@@ -55,6 +72,17 @@ type PluginMetadata struct {
 	Tags                []string
 	HeaderValuePrefixes HeaderValuePrefixes
 	HeaderAlias         HeaderAlias
+	RetryPolicy         RetryPolicy
+	RateLimit           RateLimit
+	Transport           TransportConfig
+	ResponseValidation  ResponseValidationMode
+	ConvertXMLResponses bool // return XML responses as JSON
+	ResponseSizeLimit   ResponseSizeLimit
+	OAuth2              OAuth2Config
+	HMAC                HMACConfig
+	Session             SessionConfig // overrides the session of the mask
+	DigestAuth          bool          // send USERNAME and PASSWORD with Digest auth instead of Basic auth
+	FileReferenceDir    string        // binary params can reference files under this directory with file://, references are rejected when it's empty
 }
 
 type bodyMetadata struct {
@@ -63,9 +91,34 @@ type bodyMetadata struct {
 }
 
 type parsedOpenApi struct {
-	requestUrl  string
-	description string
-	actions     []plugin.Action
+	requestUrl      string
+	description     string
+	actions         []plugin.Action
+	operations      *handlers.OperationRegistry
+	oauth2          OAuth2Config
+	securitySchemes openapi3.SecuritySchemes
+}
+
+// requestOptions are the per request settings used by executeRequestWithCredentials.
+type requestOptions struct {
+	headerValuePrefixes HeaderValuePrefixes
+	headerAlias         HeaderAlias
+	setCustomHeaders    SetCustomAuthHeaders
+	timeout             int32
+	client              *http.Client // overrides the client of the connection, used by tests
+	clients             *clientCache
+	paginator           *paginator
+	retryPolicy         RetryPolicy
+	rateLimiter         *tokenBucket
+	rateLimitWeight     int
+	responseSizeLimit   ResponseSizeLimit
+	dryRun              bool // return the request instead of sending it
+	oauth2              OAuth2Config
+	security            []securityRequirement // the security requirements of the operation, applied when there are no custom auth headers
+	hmac                HMACConfig
+	session             *sessionLogin
+	digestAuth          bool
+	secretParams        []string // the values of the action's password params, redacted from the logs
 }
 
 type Callbacks struct {
@@ -109,6 +162,15 @@ func NewOpenApiPlugin(connectionTypes map[string]connections.Connection, meta Pl
 		return nil, err
 	}
 
+	if err = meta.HMAC.validate(); err != nil {
+		return nil, err
+	}
+
+	session, err := newSessionLogin(sessionConfig(meta.Session, maskData.Session), parsedFile.operations, maskData, parsedFile.requestUrl)
+	if err != nil {
+		return nil, err
+	}
+
 	// if no validate function was passed, the default one will be used
 	if callbacks.ValidateResponse == nil {
 		callbacks.ValidateResponse = validateDefault
@@ -137,6 +199,19 @@ func NewOpenApiPlugin(connectionTypes map[string]connections.Connection, meta Pl
 		headerAlias:         meta.HeaderAlias,
 		mask:                maskData,
 		callbacks:           callbacks,
+		retryPolicy:         meta.RetryPolicy,
+		rateLimiters:        newRateLimiters(meta.RateLimit),
+		responseValidation:  meta.ResponseValidation,
+		convertXMLResponses: meta.ConvertXMLResponses,
+		responseSizeLimit:   meta.ResponseSizeLimit,
+		clients:             newClientCache(meta.Transport),
+		oauth2:              meta.OAuth2.withDefaults(parsedFile.oauth2),
+		securitySchemes:     parsedFile.securitySchemes,
+		hmac:                meta.HMAC,
+		session:             session,
+		digestAuth:          meta.DigestAuth,
+		fileReferenceDir:    meta.FileReferenceDir,
+		operations:          parsedFile.operations,
 	}, nil
 }
 
@@ -161,7 +236,6 @@ func (p *openApiPlugin) ExecuteAction(actionContext *plugin.ActionContext, reque
 		return p.callbacks.CustomActions.Execute(actionContext, request)
 	}
 	connection, err := GetCredentials(actionContext, p.Describe().Provider)
-	p.requestUrl = getRequestUrlFromConnection(p.requestUrl, connection)
 
 	// Sometimes it's fine when there's no connection (like GitHub public repos) so we will not return an error
 	if err != nil {
@@ -172,22 +246,77 @@ func (p *openApiPlugin) ExecuteAction(actionContext *plugin.ActionContext, reque
 		}
 	}
 
+	if isDryRunContext(actionContext) {
+		request = withDryRunParam(request)
+	}
+
+	return p.executeActionWithCredentials(connection, request), nil
+}
+
+// executeActionWithCredentials runs an openapi action with the given connection.
+// the plugin is not modified, so actions of different connections can run concurrently.
+func (p *openApiPlugin) executeActionWithCredentials(connection map[string]string, request *plugin.ExecuteActionRequest) *plugin.ExecuteActionResponse {
 	res := &plugin.ExecuteActionResponse{ErrorCode: consts.OK}
-	openApiRequest, err := p.parseActionRequest(request)
+	requestUrl := getRequestUrlFromConnection(p.requestUrl, connection)
+	request, dryRun := popDryRunParam(request)
+
+	if err := p.validateParameters(request); err != nil {
+		return newActionError(err, ErrorCategoryValidation).response()
+	}
+
+	openApiRequest, err := p.parseActionRequest(request, requestUrl)
 	if err != nil {
-		res.ErrorCode = consts.Error
-		res.Result = []byte(err.Error())
-		return res, nil
+		return newActionError(err, ErrorCategoryValidation).response()
+	}
+
+	result, err := executeRequestWithCredentials(connection, openApiRequest, requestOptions{
+		headerValuePrefixes: p.headerValuePrefixes,
+		headerAlias:         p.headerAlias,
+		setCustomHeaders:    p.callbacks.SetCustomAuthHeaders,
+		timeout:             request.Timeout,
+		clients:             p.clients,
+		paginator:           p.getPaginator(request.Name),
+		retryPolicy:         p.getRetryPolicy(request.Name),
+		rateLimiter:         p.rateLimiters.get(p.description.Provider, connection),
+		rateLimitWeight:     p.getRateLimitWeight(request.Name),
+		responseSizeLimit:   p.getResponseSizeLimit(request.Name),
+		dryRun:              dryRun,
+		secretParams:        p.getSecretParams(request),
+		oauth2:              p.oauth2,
+		security:            p.getSecurityRequirements(request.Name),
+		hmac:                p.hmac,
+		session:             p.session,
+		digestAuth:          p.digestAuth,
+	})
+
+	if err != nil {
+		return newActionError(err, ErrorCategoryClient).response()
 	}
 
-	result, err := executeRequestWithCredentials(connection, openApiRequest, p.headerValuePrefixes, p.headerAlias, p.callbacks.SetCustomAuthHeaders, request.Timeout)
+	if dryRun {
+		res.Result = result.Body
+		return res
+	}
 
-	res.Result = result.Body
+	decodeResultCharset(&result)
 
-	if err != nil {
-		res.ErrorCode = consts.Error
-		res.Result = []byte(err.Error())
-		return res, nil
+	if err = p.validateResponseSchema(request.Name, openApiRequest, &result); err != nil {
+		actionErr := newActionError(err, ErrorCategoryServer)
+		actionErr.StatusCode, actionErr.RequestID = result.StatusCode, requestID(result.Header)
+		return actionErr.response()
+	}
+
+	// a truncated XML document can't be parsed.
+	if p.convertXMLResponses && !result.Truncated {
+		convertXMLResult(&result)
+	}
+
+	if res.Result, err = formatResultBody(result); err != nil {
+		return newActionError(err, ErrorCategoryServer).response()
+	}
+
+	if result.StatusCode < 200 || result.StatusCode > 299 {
+		result.Error = newStatusError(result, res.Result)
 	}
 
 	if valid, msg := p.callbacks.ValidateResponse(result); !valid {
@@ -195,7 +324,7 @@ func (p *openApiPlugin) ExecuteAction(actionContext *plugin.ActionContext, reque
 		res.Result = msg
 	}
 
-	return res, nil
+	return res
 }
 
 func fixRequestURL(r *http.Request) error {
@@ -224,34 +353,94 @@ func ExecuteRequest(actionContext *plugin.ActionContext, httpRequest *http.Reque
 		}
 	}
 
-	return executeRequestWithCredentials(connection, httpRequest, headerValuePrefixes, headerAlias, setCustomHeaders, timeout)
+	return executeRequestWithCredentials(connection, httpRequest, requestOptions{
+		headerValuePrefixes: headerValuePrefixes,
+		headerAlias:         headerAlias,
+		setCustomHeaders:    setCustomHeaders,
+		timeout:             timeout,
+	})
 }
 
-func executeRequestWithCredentials(connection map[string]string, httpRequest *http.Request, headerValuePrefixes HeaderValuePrefixes, headerAlias HeaderAlias, setCustomHeaders SetCustomAuthHeaders, timeout int32) (Result, error) {
-	client := &http.Client{
-		Timeout: time.Duration(timeout) * time.Second,
+// getClient returns the client of the connection, configured by its proxy and TLS keys.
+func (opts requestOptions) getClient(connection map[string]string) (*http.Client, error) {
+	if opts.client != nil {
+		return opts.client, nil
+	}
+
+	if opts.clients != nil {
+		return opts.clients.get(connection)
+	}
+
+	return defaultClients.get(connection)
+}
+
+func executeRequestWithCredentials(connection map[string]string, httpRequest *http.Request, opts requestOptions) (Result, error) {
+	redact := newRedactor(connection, opts)
+
+	client, err := opts.getClient(connection)
+	if err != nil {
+		log.Error(redact.error(err))
+		return Result{}, &transportError{err: err}
+	}
+
+	// the timeout covers the whole action, including retries, rate limiting and following pages.
+	if opts.timeout > 0 {
+		ctx, cancel := context.WithTimeout(httpRequest.Context(), time.Duration(opts.timeout)*time.Second)
+		defer cancel()
+		httpRequest = httpRequest.WithContext(ctx)
+	}
+
+	auth, headersConnection, err := requestAuthenticator(connection, client, opts)
+	if err != nil {
+		log.Error(redact.error(err))
+		return Result{}, &authError{err: err}
 	}
 
 	result := Result{}
-	log.Info(httpRequest.Method + ": " + httpRequest.URL.String())
-	if setCustomHeaders != nil {
-		if err := setCustomHeaders(connection, httpRequest); err != nil {
-			log.Error(err)
+	log.Info(httpRequest.Method + ": " + redact.url(httpRequest.URL))
+	if opts.setCustomHeaders != nil {
+		if err := opts.setCustomHeaders(connection, httpRequest); err != nil {
+			log.Error(redact.error(err))
 			return result, fmt.Errorf("failed to set custom headers: %w", err)
 		}
-	} else if err := setAuthenticationHeaders(connection, httpRequest, headerValuePrefixes, headerAlias); err != nil {
-		log.Error(err)
-		return result, err
+	} else {
+		credentials, headersConnection := resolveSecurity(headersConnection, opts.security)
+		if err := setAuthenticationHeaders(headersConnection, httpRequest, opts.headerValuePrefixes, opts.headerAlias); err != nil {
+			log.Error(redact.error(err))
+			return result, err
+		}
+		applySecurityCredentials(httpRequest, credentials)
 	}
 
 	if err := fixRequestURL(httpRequest); err != nil {
-		log.Error(err)
+		log.Error(redact.error(err))
 		return result, err
 	}
 
+	if opts.dryRun {
+		return describeRequest(httpRequest, auth, redact)
+	}
+
+	requestSender := sender{client: client, retry: opts.retryPolicy, limiter: opts.rateLimiter, weight: opts.rateLimitWeight, sizeLimit: opts.responseSizeLimit, auth: auth, redact: redact}
+	if opts.paginator != nil {
+		return opts.paginator.execute(requestSender, httpRequest)
+	}
+
+	return requestSender.send(httpRequest)
+}
+
+// sendRequest sends a single request and reads its response, up to the size limit.
+// the secrets of the request are redacted from the logs and from the url of request errors.
+func sendRequest(client *http.Client, httpRequest *http.Request, sizeLimit ResponseSizeLimit, redact redactor) (Result, error) {
+	result := Result{}
+
 	response, err := client.Do(httpRequest)
 	if err != nil {
-		log.Error(err)
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = redact.url(httpRequest.URL)
+		}
+		log.Error(redact.error(err))
 		return result, err
 	}
 	// closing the response body, not closing can cause a mem leak
@@ -261,16 +450,20 @@ func executeRequestWithCredentials(connection map[string]string, httpRequest *ht
 		}
 	}()
 
-	result.Body, err = ioutil.ReadAll(response.Body)
+	result.Body, result.Truncated, err = sizeLimit.readBody(response.Body, response.ContentLength)
 	result.StatusCode = response.StatusCode
+	result.Header = response.Header
 
-	log.Debug(result.Body)
+	log.Debug(redact.value(string(result.Body)))
 	log.Info(result.StatusCode)
+	if result.Truncated {
+		log.Warnf("The response was truncated to %d bytes", sizeLimit.MaxBytes)
+	}
 
 	return result, err
 }
 
-func (p *openApiPlugin) parseActionRequest(executeActionRequest *plugin.ExecuteActionRequest) (*http.Request, error) {
+func (p *openApiPlugin) parseActionRequest(executeActionRequest *plugin.ExecuteActionRequest, requestUrl string) (*http.Request, error) {
 	actionName := executeActionRequest.Name
 
 	if !p.actionExist(actionName) {
@@ -280,7 +473,12 @@ func (p *openApiPlugin) parseActionRequest(executeActionRequest *plugin.ExecuteA
 	}
 
 	actionName = p.mask.ReplaceActionAlias(actionName)
-	operation := handlers.OperationDefinitions[actionName]
+	operation := p.operations.Get(actionName)
+	if operation == nil {
+		err := errors.Errorf("No operation found for action %s", actionName)
+		log.Error(err)
+		return nil, err
+	}
 
 	// get the parameters from the request.
 	rawParameters, err := executeActionRequest.GetParameters()
@@ -291,8 +489,14 @@ func (p *openApiPlugin) parseActionRequest(executeActionRequest *plugin.ExecuteA
 	// replace the raw parameters with their alias.
 	requestParameters := p.mask.ReplaceActionParametersAliases(actionName, rawParameters)
 
+	return buildOperationRequest(operation, requestParameters, requestUrl, p.fileReferenceDir)
+}
+
+// buildOperationRequest builds the request of the operation with the params in their path, headers, cookies, query and body.
+// binary params may reference files under fileReferenceDir, file references are rejected when it's empty.
+func buildOperationRequest(operation *handlers.OperationDefinition, requestParameters map[string]string, requestUrl string, fileReferenceDir string) (*http.Request, error) {
 	requestPath := parsePathParams(requestParameters, operation, operation.Path)
-	operationUrl, err := url.Parse(p.requestUrl + requestPath)
+	operationUrl, err := url.Parse(requestUrl + requestPath)
 	if err != nil {
 		return nil, err
 	}
@@ -303,16 +507,10 @@ func (p *openApiPlugin) parseActionRequest(executeActionRequest *plugin.ExecuteA
 	}
 
 	if operation.Method != http.MethodGet {
-		err = parseBodyParams(requestParameters, operation, request)
+		err = parseBodyParams(requestParameters, operation, request, fileReferenceDir)
 		if err != nil {
 			return nil, err
 		}
-
-		bodyType := operation.GetDefaultBodyType()
-
-		if bodyType != "" {
-			request.Header.Set(consts.ContentTypeHeader, bodyType)
-		}
 	}
 
 	parseHeaderParams(requestParameters, operation, request)
@@ -351,13 +549,14 @@ func parseOpenApiFile(maskData mask.Mask, OpenApiFile string) (parsedOpenApi, er
 		requestUrl = strings.ReplaceAll(requestUrl, consts.ParamPrefix+urlVariableName+consts.ParamSuffix, urlVariable.Default)
 	}
 
-	err = handlers.DefineOperations(openApi)
+	operations := handlers.NewOperationRegistry()
+	err = operations.Define(openApi)
 
 	if err != nil {
 		return parsedOpenApi{}, err
 	}
 
-	for _, operation := range handlers.OperationDefinitions {
+	for _, operation := range operations.Operations() {
 		actionName := operation.OperationId
 		displayName := ""
 
@@ -398,6 +597,15 @@ func parseOpenApiFile(maskData mask.Mask, OpenApiFile string) (parsedOpenApi, er
 		}
 
 		for _, paramBody := range operation.Bodies {
+			if paramBody.DefaultBody && paramBody.IsRaw() {
+				// raw bodies don't have properties, their whole content is passed in a single param.
+				paramName := consts.RawBodyParam
+				if actionParam := parseActionParam(maskData, action.Name, &paramName, rawBodySchema(paramBody), paramBody.Required, rawBodyDescription); actionParam != nil {
+					action.Parameters[paramName] = *actionParam
+				}
+				break
+			}
+
 			if paramBody.DefaultBody {
 
 				handleBodyParams(bodyMetadata{maskData, &action}, paramBody.Schema.OApiSchema, "", "", paramBody.Required)
@@ -414,9 +622,12 @@ func parseOpenApiFile(maskData mask.Mask, OpenApiFile string) (parsedOpenApi, er
 		return actions[i].Name < actions[j].Name
 	})
 	return parsedOpenApi{
-		description: openApi.Info.Description,
-		requestUrl:  requestUrl,
-		actions:     actions,
+		description:     openApi.Info.Description,
+		requestUrl:      requestUrl,
+		actions:         actions,
+		operations:      operations,
+		oauth2:          specOAuth2Config(openApi, requestUrl),
+		securitySchemes: openApi.Components.SecuritySchemes,
 	}, nil
 }
 
@@ -457,6 +668,11 @@ func loadOpenApi(filePath string) (openApi *openapi3.T, err error) {
 	return loader.LoadFromFile(filePath)
 }
 
+// LoadOpenApi loads the openapi file the way plugins do, it is used by the mock server.
+func LoadOpenApi(filePath string) (*openapi3.T, error) {
+	return loadOpenApi(filePath)
+}
+
 // GetRequestUrl Exported for plugin test credentials function
 func GetRequestUrl(actionContext *plugin.ActionContext, provider string) (string, error) {
 	if connection, err := GetCredentials(actionContext, provider); err != nil {
@@ -471,5 +687,8 @@ func validateDefault(response Result) (bool, []byte) {
 	if response.StatusCode >= 200 && response.StatusCode <= 299 {
 		return true, nil
 	}
+	if response.Error != nil {
+		return false, response.Error.response().Result
+	}
 	return false, response.Body
 }
@@ -351,17 +351,19 @@ func (suite *PluginTestSuite) TestParseActionRequest() {
 	cns := map[string]*connections.ConnectionInstance{}
 	cns["test"] = &connections.ConnectionInstance{Name: "test", Id: "lewl"}
 
-	// handlers.DefineOperations populates a global variable (OperationDefinitions) that is REQUIRED for this run.
-	// The only convenient option for populating this var is to load an api from file
+	// the plugin's operation registry is REQUIRED for this run.
+	// The only convenient option for populating it is to load an api from file
 	// the other one - loading from file is just too inconvenient
 	openApi, err := loadOpenApi("https://raw.githubusercontent.com/blinkops/blink-grafana/master/grafana-openapi.yaml")
 	if err != nil {
 		panic("unable to load openapi template")
 	}
-	err = handlers.DefineOperations(openApi)
+	operations := handlers.NewOperationRegistry()
+	err = operations.Define(openApi)
 	if err != nil {
-		panic("unable to prepare DefineOperations() for test")
+		panic("unable to prepare Define() for test")
 	}
+	myPlugin.operations = operations
 
 	type args struct {
 		executeActionRequest *plugin_sdk.ExecuteActionRequest
@@ -391,7 +393,7 @@ func (suite *PluginTestSuite) TestParseActionRequest() {
 	for _, tt := range tests {
 		suite.T().Run("test parseActionRequest(): "+tt.name, func(t *testing.T) {
 			require.Nil(t, err)
-			httpReq, err := myPlugin.parseActionRequest(tt.args.executeActionRequest)
+			httpReq, err := myPlugin.parseActionRequest(tt.args.executeActionRequest, myPlugin.requestUrl)
 			if tt.wantErr != "" {
 				require.NotNil(t, err, tt.name)
 				assert.Contains(t, err.Error(), tt.wantErr, tt.name)
@@ -483,19 +485,21 @@ func TestPluginSuite(t *testing.T) {
 }
 
 func (suite *PluginTestSuite) defineOperations() {
-	// handlers.DefineOperations populates a global variable (OperationDefinitions) that is REQUIRED for this run.
-	// The only convenient option for populating this var is to load an api from file
+	// the plugin's operation registry is REQUIRED for this run.
+	// The only convenient option for populating it is to load an api from file
 	// the other one - loading from file is just too inconvenient
 	openApi, err := loadOpenApi("https://raw.githubusercontent.com/blinkops/blink-grafana/master/grafana-openapi.yaml")
 	if err != nil {
 		panic("unable to load openapi template")
 	}
-	err = handlers.DefineOperations(openApi)
+	operations := handlers.NewOperationRegistry()
+	err = operations.Define(openApi)
 	if err != nil {
-		panic("unable to prepare DefineOperations() for test")
+		panic("unable to prepare Define() for test")
 	}
+	myPlugin.operations = operations
 }
 
 func (suite *PluginTestSuite) resetOperations() {
-	handlers.OperationDefinitions = map[string]*handlers.OperationDefinition{}
+	myPlugin.operations = nil
 }
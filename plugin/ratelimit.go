@@ -0,0 +1,178 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultRateLimitWeight = 1
+
+	// buckets that weren't used for this long and are full again are evicted, a new bucket of the connection starts out the same.
+	rateLimitBucketIdleTTL = 10 * time.Minute
+)
+
+// RateLimit throttles the requests sent for every provider connection.
+// The zero value doesn't limit requests.
+type RateLimit struct {
+	RequestsPerSecond float64
+	Burst             int // max requests sent at once, defaults to RequestsPerSecond rounded up
+}
+
+// rateLimiters holds a token bucket for every provider connection, idle buckets are evicted.
+type rateLimiters struct {
+	config    RateLimit
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastEvict time.Time
+}
+
+// tokenBucket is refilled at rate tokens per second, up to burst tokens.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiters(config RateLimit) *rateLimiters {
+	if config.RequestsPerSecond <= 0 {
+		return nil
+	}
+
+	return &rateLimiters{config: config, buckets: map[string]*tokenBucket{}}
+}
+
+// get returns the token bucket of a provider connection, the connection is identified by a hash of its values.
+func (r *rateLimiters) get(provider string, connection map[string]string) *tokenBucket {
+	if r == nil {
+		return nil
+	}
+
+	key := provider + ":" + connectionIdentity(connection)
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now.Sub(r.lastEvict) >= rateLimitBucketIdleTTL {
+		r.evictIdle(now)
+	}
+
+	bucket, ok := r.buckets[key]
+	if !ok {
+		burst := float64(r.config.Burst)
+		if burst <= 0 {
+			burst = math.Ceil(r.config.RequestsPerSecond)
+		}
+
+		bucket = &tokenBucket{rate: r.config.RequestsPerSecond, burst: burst, tokens: burst, last: now}
+		r.buckets[key] = bucket
+	}
+
+	return bucket
+}
+
+// evictIdle removes the buckets that are idle at now, so connections that are no longer used don't keep their buckets.
+// the caller must hold the lock.
+func (r *rateLimiters) evictIdle(now time.Time) {
+	for key, bucket := range r.buckets {
+		if bucket.idle(now) {
+			delete(r.buckets, key)
+		}
+	}
+
+	r.lastEvict = now
+}
+
+// getRateLimitWeight returns how many tokens a request of the action consumes.
+func (p *openApiPlugin) getRateLimitWeight(actionName string) int {
+	if maskedAction := p.mask.GetAction(actionName); maskedAction != nil && maskedAction.RateLimitWeight > 0 {
+		return maskedAction.RateLimitWeight
+	}
+
+	return defaultRateLimitWeight
+}
+
+// reserve takes weight tokens from the bucket and returns how long to wait until they're available.
+// when the wait is longer than maxWait nothing is taken and an error is returned, a negative maxWait means no limit.
+func (b *tokenBucket) reserve(weight int, maxWait time.Duration, now time.Time) (time.Duration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+
+	var wait time.Duration
+	if missing := float64(weight) - b.tokens; missing > 0 {
+		wait = time.Duration(missing / b.rate * float64(time.Second))
+	}
+
+	if maxWait >= 0 && wait > maxWait {
+		return wait, errors.Errorf("rate limit of %g requests per second exceeded, the request would have to wait %s which is longer than the action timeout", b.rate, wait.Round(time.Millisecond))
+	}
+
+	b.tokens -= float64(weight)
+	return wait, nil
+}
+
+// idle returns whether the bucket wasn't used for rateLimitBucketIdleTTL and was refilled, so it can be replaced by a new one.
+func (b *tokenBucket) idle(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	elapsed := now.Sub(b.last)
+	return elapsed >= rateLimitBucketIdleTTL && b.tokens+elapsed.Seconds()*b.rate >= b.burst
+}
+
+// wait blocks until the request may be sent, or fails when it can't be sent before the context deadline.
+func (b *tokenBucket) wait(ctx context.Context, weight int) error {
+	if b == nil {
+		return nil
+	}
+
+	now := time.Now()
+	maxWait := time.Duration(-1)
+	if deadline, ok := ctx.Deadline(); ok {
+		maxWait = deadline.Sub(now)
+	}
+
+	wait, err := b.reserve(weight, maxWait, now)
+	if err != nil || wait <= 0 {
+		return err
+	}
+
+	log.Debugf("Rate limited, waiting %s before sending the request", wait)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
+
+func connectionIdentity(connection map[string]string) string {
+	keys := make([]string, 0, len(connection))
+	for key := range connection {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		hash.Write([]byte(key + "=" + connection[key] + "\n"))
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
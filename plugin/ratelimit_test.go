@@ -0,0 +1,121 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/blinkops/blink-openapi-sdk/mask"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type RateLimitTestSuite struct {
+	suite.Suite
+}
+
+func (suite *RateLimitTestSuite) TestReserve() {
+	now := time.Now()
+	bucket := &tokenBucket{rate: 2, burst: 2, tokens: 2, last: now}
+
+	// the burst is available right away
+	for i := 0; i < 2; i++ {
+		wait, err := bucket.reserve(1, 0, now)
+		require.NoError(suite.T(), err)
+		assert.Equal(suite.T(), time.Duration(0), wait)
+	}
+
+	// the next token is refilled after half a second
+	wait, err := bucket.reserve(1, time.Second, now)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 500*time.Millisecond, wait)
+
+	// a heavy request that can't be sent in time doesn't take tokens
+	_, err = bucket.reserve(3, time.Second, now)
+	require.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "longer than the action timeout")
+
+	// after a second the bucket is back at the reserved request
+	wait, err = bucket.reserve(1, time.Second, now.Add(time.Second))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), time.Duration(0), wait)
+}
+
+func (suite *RateLimitTestSuite) TestBucketPerConnection() {
+	limiters := newRateLimiters(RateLimit{RequestsPerSecond: 1.5})
+	first := limiters.get("github", map[string]string{"Authorization": "token-1"})
+
+	assert.Equal(suite.T(), float64(2), first.burst)
+	assert.Same(suite.T(), first, limiters.get("github", map[string]string{"Authorization": "token-1"}))
+	assert.NotSame(suite.T(), first, limiters.get("github", map[string]string{"Authorization": "token-2"}))
+	assert.NotSame(suite.T(), first, limiters.get("jira", map[string]string{"Authorization": "token-1"}))
+
+	assert.Nil(suite.T(), newRateLimiters(RateLimit{}).get("github", nil))
+}
+
+func (suite *RateLimitTestSuite) TestEvictIdleBuckets() {
+	limiters := newRateLimiters(RateLimit{RequestsPerSecond: 1})
+	idle := limiters.get("github", map[string]string{"Authorization": "token-1"})
+	active := limiters.get("github", map[string]string{"Authorization": "token-2"})
+
+	// the idle bucket was refilled long ago, the active one was just used.
+	now := time.Now()
+	idle.last = now.Add(-2 * rateLimitBucketIdleTTL)
+	_, err := active.reserve(1, -1, now)
+	require.NoError(suite.T(), err)
+
+	limiters.lastEvict = now.Add(-rateLimitBucketIdleTTL)
+	limiters.get("jira", nil)
+	assert.Len(suite.T(), limiters.buckets, 2)
+	assert.NotSame(suite.T(), idle, limiters.get("github", map[string]string{"Authorization": "token-1"}))
+	assert.Same(suite.T(), active, limiters.get("github", map[string]string{"Authorization": "token-2"}))
+
+	// an idle bucket that is still refilling keeps its debt.
+	drained := &tokenBucket{rate: 0.001, burst: 1, tokens: -1, last: now.Add(-rateLimitBucketIdleTTL)}
+	assert.False(suite.T(), drained.idle(now))
+	assert.True(suite.T(), drained.idle(now.Add(time.Hour)))
+}
+
+func (suite *RateLimitTestSuite) TestSenderFailsBeforeDeadline() {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	requestSender := sender{
+		client:  &http.Client{},
+		limiter: &tokenBucket{rate: 0.5, burst: 1, tokens: 1, last: time.Now()},
+		weight:  1,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	require.NoError(suite.T(), err)
+
+	result, err := requestSender.send(request)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), http.StatusOK, result.StatusCode)
+
+	// the second request would have to wait 2 seconds
+	_, err = requestSender.send(request)
+	require.Error(suite.T(), err)
+	assert.Equal(suite.T(), 1, requests)
+}
+
+func (suite *RateLimitTestSuite) TestGetRateLimitWeight() {
+	p := &openApiPlugin{mask: mask.Mask{Actions: map[string]*mask.MaskedAction{
+		"SearchIssues": {RateLimitWeight: 5},
+	}}}
+
+	assert.Equal(suite.T(), 5, p.getRateLimitWeight("SearchIssues"))
+	assert.Equal(suite.T(), 1, p.getRateLimitWeight("ListIssues"))
+}
+
+func TestRateLimitSuite(t *testing.T) {
+	suite.Run(t, new(RateLimitTestSuite))
+}
@@ -0,0 +1,204 @@
+package plugin
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	"github.com/blinkops/blink-sdk/plugin"
+)
+
+const (
+	redactedValue = "*****"
+
+	// connection values shorter than this, like ports or flags, aren't treated as secrets.
+	minSecretLength = 4
+)
+
+// sensitiveHeaders are always redacted, their values are derived from secrets, like basic auth or signatures.
+var sensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"X-Auth-Token",
+}
+
+// sensitiveQueryParams are the words of query param names whose values are redacted, like api_key or X-Amz-Signature.
+// names are compared in lower case without separators.
+var (
+	sensitiveQueryParams      = []string{"apikey", "accesskey", "privatekey", "token", "secret", "passw", "pwd", "signature", "credential", "session"}
+	sensitiveQueryParamsExact = []string{"key", "sig", "auth", "sid", "pass"}
+)
+
+// publicConnectionKeys are the connection keys whose values aren't secrets, like the request url and the CA bundle.
+var publicConnectionKeys = []string{
+	consts.RequestUrlKey,
+	consts.NoProxyKey,
+	consts.CABundleKey,
+	consts.ClientCertKey,
+	consts.InsecureSkipVerifyKey,
+	consts.TokenUrlKey,
+	consts.ScopesKey,
+	consts.AwsRegionKey,
+	consts.AwsServiceKey,
+	consts.JwtKeyIdKey,
+	consts.JwtIssuerKey,
+	consts.JwtSubjectKey,
+	consts.JwtAudienceKey,
+	consts.JwtScopesKey,
+	consts.JwtTokenUrlKey,
+	consts.JwtLifetimeKey,
+	consts.AuthTypeKey,
+}
+
+// redactor masks the secrets of a request in everything that is logged or shown about it.
+type redactor struct {
+	secrets []string // connection values and password params, also in their URL encoded form
+	headers []string // headers that are always redacted, the sensitive headers and the auth headers of the plugin
+}
+
+// newRedactor returns the redactor of the connection, the password params of the action and the plugin's auth headers.
+func newRedactor(connection map[string]string, opts requestOptions) redactor {
+	r := redactor{headers: append([]string{}, sensitiveHeaders...)}
+	for _, secret := range append(connectionSecrets(connection), opts.secretParams...) {
+		if len(secret) < minSecretLength {
+			continue
+		}
+
+		r.secrets = append(r.secrets, secret)
+		if escaped := url.QueryEscape(secret); escaped != secret {
+			r.secrets = append(r.secrets, escaped)
+		}
+		if escaped := url.PathEscape(secret); escaped != secret {
+			r.secrets = append(r.secrets, escaped)
+		}
+	}
+
+	// longer secrets are redacted first, so secrets that contain other secrets are redacted whole.
+	sort.Slice(r.secrets, func(i, j int) bool {
+		return len(r.secrets[i]) > len(r.secrets[j])
+	})
+
+	for _, header := range opts.headerAlias {
+		r.headers = append(r.headers, header)
+	}
+	for header := range opts.headerValuePrefixes {
+		r.headers = append(r.headers, header)
+	}
+	if opts.hmac.enabled() {
+		r.headers = append(r.headers, opts.hmac.SignatureHeader)
+	}
+
+	return r
+}
+
+// value returns the value with every secret redacted.
+func (r redactor) value(value string) string {
+	return redactSecrets(value, r.secrets)
+}
+
+// error returns the message of the error with every secret redacted.
+func (r redactor) error(err error) string {
+	return r.value(err.Error())
+}
+
+// url returns the url with the secrets, the password of its user and the values of sensitive query params redacted.
+func (r redactor) url(requestUrl *url.URL) string {
+	redacted := *requestUrl
+
+	// the raw query is rewritten in place, so the logged url keeps the order of the params.
+	params := strings.Split(redacted.RawQuery, "&")
+	for i, param := range params {
+		name := strings.SplitN(param, "=", 2)[0]
+		if unescaped, err := url.QueryUnescape(name); err == nil && isSensitiveQueryParam(unescaped) && strings.Contains(param, "=") {
+			params[i] = name + "=" + redactedValue
+		}
+	}
+	redacted.RawQuery = strings.Join(params, "&")
+
+	return r.value(redacted.Redacted())
+}
+
+// header returns a copy of the headers with the sensitive headers and the secrets redacted.
+func (r redactor) header(header http.Header) http.Header {
+	redacted := make(http.Header, len(header))
+	for name, values := range header {
+		redactedValues := make([]string, len(values))
+		for i, value := range values {
+			if containsFold(r.headers, name) {
+				redactedValues[i] = redactedValue
+			} else {
+				redactedValues[i] = r.value(value)
+			}
+		}
+		redacted[name] = redactedValues
+	}
+
+	return redacted
+}
+
+// connectionSecrets returns the values of the connection that must never be shown, every value but the public ones.
+func connectionSecrets(connection map[string]string) []string {
+	secrets := make([]string, 0, len(connection))
+	for key, value := range connection {
+		if isPublicConnectionKey(key) || len(value) < minSecretLength {
+			continue
+		}
+		secrets = append(secrets, value)
+	}
+
+	return secrets
+}
+
+func isPublicConnectionKey(key string) bool {
+	return containsFold(publicConnectionKeys, key)
+}
+
+// redactSecrets replaces every occurrence of the secrets in the value.
+func redactSecrets(value string, secrets []string) string {
+	for _, secret := range secrets {
+		value = strings.ReplaceAll(value, secret, redactedValue)
+	}
+
+	return value
+}
+
+func isSensitiveQueryParam(name string) bool {
+	name = strings.ToLower(strings.NewReplacer("-", "", "_", "", ".", "").Replace(name))
+	if StringInSlice(name, sensitiveQueryParamsExact) {
+		return true
+	}
+
+	for _, word := range sensitiveQueryParams {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getSecretParams returns the values of the action's password params, they are redacted like the connection values.
+func (p *openApiPlugin) getSecretParams(request *plugin.ExecuteActionRequest) []string {
+	var secrets []string
+	for _, action := range p.actions {
+		if action.Name != request.Name {
+			continue
+		}
+
+		for name, param := range action.Parameters {
+			if param.Type != consts.TypePassword && param.Format != consts.TypePassword {
+				continue
+			}
+			if value := request.Parameters[name]; value != "" {
+				secrets = append(secrets, value)
+			}
+		}
+	}
+
+	return secrets
+}
@@ -1,9 +1,11 @@
 package plugin
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -74,8 +76,9 @@ func parseQueryParams(requestParameters map[string]string, operation *handlers.O
 	request.URL.RawQuery = query.Encode()
 }
 
-// parseBodyParams add the params to to body of the request (JSON/ URL encoded params).
-func parseBodyParams(requestParameters map[string]string, operation *handlers.OperationDefinition, request *http.Request) error {
+// parseBodyParams add the params to to body of the request (JSON/ XML/ URL encoded/ multipart params or a raw body),
+// and sets the content type of the body.
+func parseBodyParams(requestParameters map[string]string, operation *handlers.OperationDefinition, request *http.Request, fileReferenceDir string) error {
 	requestBody := map[string]interface{}{}
 
 	// the default body prefers to be json if available, otherwise will pick the first body.
@@ -86,6 +89,10 @@ func parseBodyParams(requestParameters map[string]string, operation *handlers.Op
 		return nil
 	}
 
+	if defaultBody.IsRaw() {
+		return parseRawBody(requestParameters, defaultBody, request, fileReferenceDir)
+	}
+
 	// Add "." delimited params as request body
 	for paramName, paramValue := range requestParameters {
 		mapKeys := strings.Split(paramName, consts.BodyParamDelimiter)
@@ -93,8 +100,11 @@ func parseBodyParams(requestParameters map[string]string, operation *handlers.Op
 
 	}
 
-	// when the content type is url encoded, the values need be urlencoded and sent in the body.
-	if defaultBody.ContentType == consts.URLEncoded {
+	contentType := defaultBody.ContentType
+
+	switch defaultBody.ContentType {
+	case consts.URLEncoded:
+		// when the content type is url encoded, the values need be urlencoded and sent in the body.
 		values := url.Values{}
 		// add the values
 		for paramName, paramValue := range requestBody {
@@ -102,9 +112,29 @@ func parseBodyParams(requestParameters map[string]string, operation *handlers.Op
 		}
 
 		// url encoded the values and add to the body.
-		request.Body = ioutil.NopCloser(strings.NewReader(values.Encode()))
+		setRequestBody(request, []byte(values.Encode()))
+
+	case consts.MultipartFormData:
+		// the content type of multipart bodies holds the boundary between the parts.
+		multipartBody, multipartContentType, err := encodeMultipartBody(requestBody, defaultBody.Schema.OApiSchema, fileReferenceDir)
+		if err != nil {
+			return err
+		}
+
+		setRequestBody(request, multipartBody)
+		contentType = multipartContentType
+
+	default:
+		if isXMLContentType(defaultBody.ContentType) {
+			xmlBody, err := encodeXMLBody(xmlRootName(operation, defaultBody), requestBody, defaultBody.Schema.OApiSchema)
+			if err != nil {
+				return err
+			}
+
+			setRequestBody(request, xmlBody)
+			break
+		}
 
-	} else {
 		// for any other content type, send the values as JSON.
 		marshaledBody, err := json.Marshal(requestBody)
 		if err != nil {
@@ -112,12 +142,22 @@ func parseBodyParams(requestParameters map[string]string, operation *handlers.Op
 		}
 
 		// add the JSON to the body.
-		request.Body = ioutil.NopCloser(strings.NewReader(string(marshaledBody)))
-		request.ContentLength = int64(len(marshaledBody))
+		setRequestBody(request, marshaledBody)
 	}
+
+	request.Header.Set(consts.ContentTypeHeader, contentType)
 	return nil
 }
 
+// setRequestBody sets a body that can be read again using GetBody, so the request can be resent.
+func setRequestBody(request *http.Request, body []byte) {
+	request.ContentLength = int64(len(body))
+	request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	request.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+}
+
 // Build nested json request body from "." delimited parameters
 func buildRequestBody(mapKeys []string, propertySchema *openapi3.Schema, paramValue string, requestBody map[string]interface{}) {
 	key := mapKeys[0]
@@ -179,11 +219,13 @@ func castBodyParamType(paramValue string, paramType string) interface{} {
 func setAuthenticationHeaders(securityHeaders map[string]string, request *http.Request, prefixes HeaderValuePrefixes, headerAlias HeaderAlias) error {
 	headers := make(map[string]string)
 
-	// Remove request url and leave only other authentication headers
-	// We don't want to parse the URL with request params
-	delete(securityHeaders, consts.RequestUrlKey)
-
 	for header, headerValue := range securityHeaders {
+		// Skip the request url and the transport keys and leave only other authentication headers
+		// We don't want to parse the URL with request params
+		if isReservedConnectionKey(header) {
+			continue
+		}
+
 		header = strings.ToUpper(header)
 		// if the header is in our alias map replace it with the value in the map
 		// TOKEN -> AUTHORIZATION
@@ -0,0 +1,153 @@
+package plugin
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+const contentDispositionHeader = "Content-Disposition"
+
+var (
+	// textMediaTypes are returned as text even though they're not text/*.
+	textMediaTypes = []string{
+		"application/json",
+		"application/xml",
+		"application/javascript",
+		"application/x-www-form-urlencoded",
+		"application/x-yaml",
+		"application/yaml",
+		"application/graphql",
+	}
+
+	// binaryMediaTypePrefixes are returned base64 encoded even when they happen to be valid UTF-8.
+	binaryMediaTypePrefixes = []string{
+		"image/",
+		"audio/",
+		"video/",
+		"font/",
+		"application/octet-stream",
+		"application/pdf",
+		"application/zip",
+		"application/gzip",
+		"application/x-gzip",
+		"application/x-tar",
+		"application/x-7z-compressed",
+		"application/msword",
+		"application/vnd.ms-",
+		"application/vnd.openxmlformats-officedocument.",
+	}
+)
+
+// BinaryResponse is the action result of binary responses, like images, PDFs and archives,
+// which can't be passed to the workflow as text.
+type BinaryResponse struct {
+	ContentType string `json:"content_type"`
+	Filename    string `json:"filename,omitempty"`
+	Size        int    `json:"size"`
+	Content     string `json:"content"`             // base64 encoded
+	Truncated   bool   `json:"truncated,omitempty"` // the content was cut at the response size limit
+}
+
+// decodeResultCharset converts text responses in other charsets, like ISO-8859-1, to UTF-8.
+// the body is left as is when the charset is unknown.
+func decodeResultCharset(result *Result) {
+	mediaType, params, err := mime.ParseMediaType(result.Header.Get(consts.ContentTypeHeader))
+	if err != nil || !isTextMediaType(mediaType) {
+		return
+	}
+
+	charset := strings.ToLower(params["charset"])
+	if charset == "" || charset == "utf-8" || charset == "utf8" || charset == "us-ascii" {
+		return
+	}
+
+	encoding, err := htmlindex.Get(charset)
+	if err != nil {
+		log.Warnf("Unknown response charset %s, the response is returned as is", charset)
+		return
+	}
+
+	decodedBody, err := encoding.NewDecoder().Bytes(result.Body)
+	if err != nil {
+		log.Warnf("Failed to decode the response from %s: %v", charset, err)
+		return
+	}
+
+	params["charset"] = "utf-8"
+	result.Body = decodedBody
+	result.Header = result.Header.Clone()
+	result.Header.Set(consts.ContentTypeHeader, mime.FormatMediaType(mediaType, params))
+}
+
+// formatResultBody returns the body that is passed to the workflow, binary bodies are wrapped in a base64 encoded BinaryResponse.
+func formatResultBody(result Result) ([]byte, error) {
+	contentType := result.Header.Get(consts.ContentTypeHeader)
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "" {
+		// providers that don't send a content type are sniffed.
+		contentType = http.DetectContentType(result.Body)
+		mediaType, _, _ = mime.ParseMediaType(contentType)
+	}
+
+	if len(result.Body) == 0 || !isBinaryMediaType(mediaType, result.Body) {
+		if result.Truncated {
+			return append(result.Body[:len(result.Body):len(result.Body)], responseTruncatedMarker...), nil
+		}
+		return result.Body, nil
+	}
+
+	return json.Marshal(BinaryResponse{
+		ContentType: contentType,
+		Filename:    getFilename(result.Header),
+		Size:        len(result.Body),
+		Content:     base64.StdEncoding.EncodeToString(result.Body),
+		Truncated:   result.Truncated,
+	})
+}
+
+func isTextMediaType(mediaType string) bool {
+	if strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+
+	for _, textMediaType := range textMediaTypes {
+		if mediaType == textMediaType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isBinaryMediaType returns whether a body is binary, bodies of unknown media types are binary when they're not valid UTF-8.
+func isBinaryMediaType(mediaType string, body []byte) bool {
+	if isTextMediaType(mediaType) {
+		return false
+	}
+
+	for _, prefix := range binaryMediaTypePrefixes {
+		if strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+
+	return !utf8.Valid(body)
+}
+
+// getFilename returns the filename of the Content-Disposition header, if there is one.
+func getFilename(header http.Header) string {
+	_, params, err := mime.ParseMediaType(header.Get(contentDispositionHeader))
+	if err != nil {
+		return ""
+	}
+
+	return params["filename"]
+}
@@ -0,0 +1,76 @@
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/blinkops/blink-openapi-sdk/mask"
+)
+
+// responseTruncatedMarker is appended to text responses that were cut at the size limit.
+const responseTruncatedMarker = "\n[response truncated]"
+
+// ResponseSizeLimit caps the size of response bodies, so huge exports can't exhaust the plugin's memory.
+// The zero value doesn't limit responses.
+type ResponseSizeLimit struct {
+	MaxBytes int64  // max body size, 0 is unlimited
+	Policy   string // mask.ResponseSizeFail (the default) fails the action, mask.ResponseSizeTruncate returns the first MaxBytes
+}
+
+// ResponseTooLargeError is returned when a response is larger than the limit and the policy is to fail.
+type ResponseTooLargeError struct {
+	MaxBytes int64
+}
+
+func (e *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("The response is larger than the limit of %d bytes", e.MaxBytes)
+}
+
+// getResponseSizeLimit returns the plugin response size limit, overridden by the action's mask.
+func (p *openApiPlugin) getResponseSizeLimit(actionName string) ResponseSizeLimit {
+	limit := p.responseSizeLimit
+
+	maskedAction := p.mask.GetAction(actionName)
+	if maskedAction == nil {
+		return limit
+	}
+
+	if maskedAction.MaxResponseSize != 0 {
+		limit.MaxBytes = maskedAction.MaxResponseSize
+	}
+	if maskedAction.ResponseSizePolicy != "" {
+		limit.Policy = maskedAction.ResponseSizePolicy
+	}
+
+	return limit
+}
+
+func (l ResponseSizeLimit) truncates() bool {
+	return l.Policy == mask.ResponseSizeTruncate
+}
+
+// readBody reads the body up to the limit, reading one more byte than the limit to know whether the body is larger.
+// larger bodies are either cut at the limit or fail, the rest of the body is never read.
+func (l ResponseSizeLimit) readBody(body io.Reader, contentLength int64) ([]byte, bool, error) {
+	if l.MaxBytes <= 0 {
+		content, err := ioutil.ReadAll(body)
+		return content, false, err
+	}
+
+	// no need to download a body that is known to be too large.
+	if contentLength > l.MaxBytes && !l.truncates() {
+		return nil, false, &ResponseTooLargeError{MaxBytes: l.MaxBytes}
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(body, l.MaxBytes+1))
+	if err != nil || int64(len(content)) <= l.MaxBytes {
+		return content, false, err
+	}
+
+	if !l.truncates() {
+		return nil, false, &ResponseTooLargeError{MaxBytes: l.MaxBytes}
+	}
+
+	return content[:l.MaxBytes], true, nil
+}
@@ -0,0 +1,123 @@
+package plugin
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	"github.com/blinkops/blink-openapi-sdk/mask"
+	plugin_sdk "github.com/blinkops/blink-sdk/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const exportsMask = `
+actions:
+  GetExport:
+    max_response_size: 4
+    response_size_policy: truncate
+`
+
+type ResponseSizeTestSuite struct {
+	suite.Suite
+	server      *httptest.Server
+	connection  map[string]string
+	openApiFile string
+	maskFile    string
+}
+
+func (suite *ResponseSizeTestSuite) SetupSuite() {
+	suite.server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch filepath.Base(req.URL.Path) {
+		case "image.png":
+			res.Header().Set(consts.ContentTypeHeader, "image/png")
+			_, _ = res.Write(pngContent)
+		case "chunked.txt":
+			// flushing before writing the body sends it without a Content-Length.
+			res.Header().Set(consts.ContentTypeHeader, "text/plain")
+			res.(http.Flusher).Flush()
+			_, _ = res.Write([]byte(strings.Repeat("a", 100)))
+		default:
+			res.Header().Set(consts.ContentTypeHeader, "text/plain")
+			_, _ = res.Write([]byte("0123456789"))
+		}
+	}))
+	suite.connection = map[string]string{consts.RequestUrlKey: suite.server.URL}
+
+	dir := suite.T().TempDir()
+	suite.openApiFile = filepath.Join(dir, "exports-openapi.yaml")
+	suite.maskFile = filepath.Join(dir, "exports-mask.yaml")
+	require.NoError(suite.T(), ioutil.WriteFile(suite.openApiFile, []byte(exportsOpenApi), 0600))
+	require.NoError(suite.T(), ioutil.WriteFile(suite.maskFile, []byte(exportsMask), 0600))
+}
+
+func (suite *ResponseSizeTestSuite) TearDownSuite() {
+	suite.server.Close()
+}
+
+func (suite *ResponseSizeTestSuite) execute(meta PluginMetadata, export string) *plugin_sdk.ExecuteActionResponse {
+	meta.Name, meta.Provider, meta.OpenApiFile = "exports", "exports", suite.openApiFile
+	p, err := NewOpenApiPlugin(nil, meta, Callbacks{})
+	require.NoError(suite.T(), err)
+
+	return p.executeActionWithCredentials(suite.connection, &plugin_sdk.ExecuteActionRequest{Name: "GetExport", Parameters: map[string]string{"export": export}})
+}
+
+func (suite *ResponseSizeTestSuite) TestNoLimit() {
+	res := suite.execute(PluginMetadata{}, "export.txt")
+	assert.Equal(suite.T(), int64(consts.OK), res.ErrorCode)
+	assert.Equal(suite.T(), "0123456789", string(res.Result))
+
+	res = suite.execute(PluginMetadata{ResponseSizeLimit: ResponseSizeLimit{MaxBytes: 10}}, "export.txt")
+	assert.Equal(suite.T(), int64(consts.OK), res.ErrorCode)
+	assert.Equal(suite.T(), "0123456789", string(res.Result))
+}
+
+func (suite *ResponseSizeTestSuite) TestFail() {
+	for _, export := range []string{"export.txt", "chunked.txt"} {
+		res := suite.execute(PluginMetadata{ResponseSizeLimit: ResponseSizeLimit{MaxBytes: 8}}, export)
+		assert.Equal(suite.T(), int64(consts.Error), res.ErrorCode, export)
+		assert.Equal(suite.T(), "The response is larger than the limit of 8 bytes", decodeActionError(suite.T(), res.Result).Message, export)
+	}
+}
+
+func (suite *ResponseSizeTestSuite) TestTruncate() {
+	limit := ResponseSizeLimit{MaxBytes: 8, Policy: mask.ResponseSizeTruncate}
+
+	res := suite.execute(PluginMetadata{ResponseSizeLimit: limit}, "export.txt")
+	assert.Equal(suite.T(), int64(consts.OK), res.ErrorCode)
+	assert.Equal(suite.T(), "01234567"+responseTruncatedMarker, string(res.Result))
+
+	res = suite.execute(PluginMetadata{ResponseSizeLimit: limit}, "chunked.txt")
+	assert.Equal(suite.T(), int64(consts.OK), res.ErrorCode)
+	assert.Equal(suite.T(), "aaaaaaaa"+responseTruncatedMarker, string(res.Result))
+
+	res = suite.execute(PluginMetadata{ResponseSizeLimit: limit}, "image.png")
+	assert.Equal(suite.T(), int64(consts.OK), res.ErrorCode)
+	var binaryResponse BinaryResponse
+	require.NoError(suite.T(), json.Unmarshal(res.Result, &binaryResponse))
+	assert.True(suite.T(), binaryResponse.Truncated)
+	assert.Equal(suite.T(), base64.StdEncoding.EncodeToString(pngContent[:8]), binaryResponse.Content)
+}
+
+func (suite *ResponseSizeTestSuite) TestMaskOverride() {
+	res := suite.execute(PluginMetadata{MaskFile: suite.maskFile, ResponseSizeLimit: ResponseSizeLimit{MaxBytes: 100}}, "export.txt")
+	assert.Equal(suite.T(), int64(consts.OK), res.ErrorCode)
+	assert.Equal(suite.T(), "0123"+responseTruncatedMarker, string(res.Result))
+}
+
+func (suite *ResponseSizeTestSuite) TestTooLargeResponsesAreNotRetried() {
+	err := error(&ResponseTooLargeError{MaxBytes: 8})
+	assert.False(suite.T(), RetryPolicy{MaxAttempts: 3}.shouldRetry(httptest.NewRequest(http.MethodGet, "/", nil).Context(), Result{}, err))
+}
+
+func TestResponseSizeSuite(t *testing.T) {
+	suite.Run(t, new(ResponseSizeTestSuite))
+}
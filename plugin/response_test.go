@@ -0,0 +1,167 @@
+package plugin
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	plugin_sdk "github.com/blinkops/blink-sdk/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const exportsOpenApi = `
+openapi: 3.0.0
+info:
+  title: exports
+  version: 1.0.0
+servers:
+  - url: https://api.example.com
+paths:
+  /exports/{export}:
+    get:
+      operationId: GetExport
+      parameters:
+        - name: export
+          in: path
+          required: true
+          schema:
+            type: string
+      responses:
+        "200":
+          description: export
+`
+
+var pngContent = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0x00, 0x00, 0x00, 0x0d, 0xff}
+
+type ResponseTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ResponseTestSuite) result(contentType string, body []byte) Result {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set(consts.ContentTypeHeader, contentType)
+	}
+
+	return Result{StatusCode: http.StatusOK, Header: header, Body: body}
+}
+
+func (suite *ResponseTestSuite) decodeBinaryResponse(body []byte) BinaryResponse {
+	var binaryResponse BinaryResponse
+	require.NoError(suite.T(), json.Unmarshal(body, &binaryResponse))
+	return binaryResponse
+}
+
+func (suite *ResponseTestSuite) TestBinaryResponse() {
+	result := suite.result("image/png", pngContent)
+	result.Header.Set(contentDispositionHeader, `attachment; filename="chart.png"`)
+
+	body, err := formatResultBody(result)
+	require.NoError(suite.T(), err)
+
+	binaryResponse := suite.decodeBinaryResponse(body)
+	assert.Equal(suite.T(), "image/png", binaryResponse.ContentType)
+	assert.Equal(suite.T(), "chart.png", binaryResponse.Filename)
+	assert.Equal(suite.T(), len(pngContent), binaryResponse.Size)
+
+	content, err := base64.StdEncoding.DecodeString(binaryResponse.Content)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), pngContent, content)
+}
+
+func (suite *ResponseTestSuite) TestBinaryResponseDetection() {
+	cases := []struct {
+		name        string
+		contentType string
+		body        []byte
+		binary      bool
+	}{
+		{name: "json", contentType: "application/json", body: []byte(`{"a": 1}`)},
+		{name: "vendor json", contentType: "application/vnd.api+json", body: []byte(`{"a": 1}`)},
+		{name: "csv", contentType: "text/csv", body: []byte("a,b\n1,2")},
+		{name: "svg", contentType: "image/svg+xml", body: []byte("<svg></svg>")},
+		{name: "pdf", contentType: "application/pdf", body: []byte("%PDF-1.4"), binary: true},
+		{name: "zip", contentType: "application/zip", body: []byte("PK\x03\x04"), binary: true},
+		{name: "unknown utf8", contentType: "application/vnd.custom", body: []byte("plain")},
+		{name: "unknown binary", contentType: "application/vnd.custom", body: pngContent, binary: true},
+		{name: "sniffed png", body: pngContent, binary: true},
+		{name: "sniffed text", body: []byte("hello")},
+		{name: "empty", contentType: "application/octet-stream"},
+	}
+
+	for _, c := range cases {
+		body, err := formatResultBody(suite.result(c.contentType, c.body))
+		require.NoError(suite.T(), err, c.name)
+
+		if c.binary {
+			assert.NotEmpty(suite.T(), suite.decodeBinaryResponse(body).Content, c.name)
+		} else {
+			assert.Equal(suite.T(), c.body, body, c.name)
+		}
+	}
+}
+
+func (suite *ResponseTestSuite) TestCharsetDecoding() {
+	// "café" in ISO-8859-1 and "€" in windows-1252
+	result := suite.result("text/plain; charset=ISO-8859-1", []byte{'c', 'a', 'f', 0xe9})
+	decodeResultCharset(&result)
+	assert.Equal(suite.T(), "café", string(result.Body))
+	assert.Equal(suite.T(), "text/plain; charset=utf-8", result.Header.Get(consts.ContentTypeHeader))
+
+	result = suite.result("application/json; charset=windows-1252", []byte{'"', 0x80, '"'})
+	decodeResultCharset(&result)
+	assert.Equal(suite.T(), `"€"`, string(result.Body))
+
+	for _, contentType := range []string{"text/plain; charset=utf-8", "text/plain; charset=unknown-charset", "image/png; charset=ISO-8859-1", ""} {
+		result = suite.result(contentType, []byte{'c', 'a', 'f', 0xe9})
+		decodeResultCharset(&result)
+		assert.Equal(suite.T(), []byte{'c', 'a', 'f', 0xe9}, result.Body, contentType)
+	}
+}
+
+func (suite *ResponseTestSuite) TestExecuteActionWithBinaryResponse() {
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch filepath.Base(req.URL.Path) {
+		case "report.pdf":
+			res.Header().Set(consts.ContentTypeHeader, "application/pdf")
+			res.Header().Set(contentDispositionHeader, `attachment; filename*=UTF-8''r%C3%A9sum%C3%A9.pdf`)
+			_, _ = res.Write([]byte("%PDF-1.4\x00\xff"))
+		default:
+			res.Header().Set(consts.ContentTypeHeader, "text/plain; charset=ISO-8859-1")
+			_, _ = res.Write([]byte{'c', 'a', 'f', 0xe9})
+		}
+	}))
+	defer server.Close()
+
+	openApiFile := filepath.Join(suite.T().TempDir(), "exports-openapi.yaml")
+	require.NoError(suite.T(), ioutil.WriteFile(openApiFile, []byte(exportsOpenApi), 0600))
+	p, err := NewOpenApiPlugin(nil, PluginMetadata{Name: "exports", Provider: "exports", OpenApiFile: openApiFile}, Callbacks{})
+	require.NoError(suite.T(), err)
+
+	connection := map[string]string{consts.RequestUrlKey: server.URL}
+
+	res := p.executeActionWithCredentials(connection, &plugin_sdk.ExecuteActionRequest{Name: "GetExport", Parameters: map[string]string{"export": "report.pdf"}})
+	require.Equal(suite.T(), int64(consts.OK), res.ErrorCode)
+	binaryResponse := suite.decodeBinaryResponse(res.Result)
+	assert.Equal(suite.T(), BinaryResponse{
+		ContentType: "application/pdf",
+		Filename:    "résumé.pdf",
+		Size:        10,
+		Content:     base64.StdEncoding.EncodeToString([]byte("%PDF-1.4\x00\xff")),
+	}, binaryResponse)
+
+	res = p.executeActionWithCredentials(connection, &plugin_sdk.ExecuteActionRequest{Name: "GetExport", Parameters: map[string]string{"export": "notes.txt"}})
+	require.Equal(suite.T(), int64(consts.OK), res.ErrorCode)
+	assert.Equal(suite.T(), "café", string(res.Result))
+}
+
+func TestResponseSuite(t *testing.T) {
+	suite.Run(t, new(ResponseTestSuite))
+}
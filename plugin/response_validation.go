@@ -0,0 +1,119 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ResponseValidationMode sets what happens when a response doesn't match the responses declared in the openapi spec.
+type ResponseValidationMode string
+
+const (
+	ResponseValidationOff    ResponseValidationMode = "off"  // responses aren't validated, the default
+	ResponseValidationWarn   ResponseValidationMode = "warn" // the problems are logged and attached to the result's SchemaWarnings
+	ResponseValidationStrict ResponseValidationMode = "strict"
+)
+
+// getResponseValidationMode returns the plugin response validation mode, overridden by the action's mask.
+func (p *openApiPlugin) getResponseValidationMode(actionName string) ResponseValidationMode {
+	if maskedAction := p.mask.GetAction(actionName); maskedAction != nil && maskedAction.ResponseValidation != "" {
+		return ResponseValidationMode(maskedAction.ResponseValidation)
+	}
+
+	return p.responseValidation
+}
+
+// validateResponseSchema validates the status, content type and body of a successful response against the action's spec.
+// in strict mode an error lists the problems, in warn mode they're logged and attached to the result.
+func (p *openApiPlugin) validateResponseSchema(actionName string, request *http.Request, result *Result) error {
+	mode := p.getResponseValidationMode(actionName)
+	if mode != ResponseValidationWarn && mode != ResponseValidationStrict {
+		return nil
+	}
+
+	// failed responses are handled by the ValidateResponse callback, truncated ones can't match the schema.
+	if result.StatusCode < 200 || result.StatusCode > 299 || result.Truncated {
+		return nil
+	}
+
+	operation := p.operations.Get(p.mask.ReplaceActionAlias(actionName))
+	if operation == nil || operation.Spec == nil {
+		return nil
+	}
+
+	problems := responseSchemaProblems(operation.Spec, request, *result)
+	if len(problems) == 0 {
+		return nil
+	}
+
+	if mode == ResponseValidationStrict {
+		return errors.Errorf("The response doesn't match the openapi spec:\n%s", strings.Join(problems, "\n"))
+	}
+
+	log.Warnf("The response of %s doesn't match the openapi spec: %s", actionName, strings.Join(problems, ", "))
+	result.SchemaWarnings = problems
+	return nil
+}
+
+func responseSchemaProblems(operation *openapi3.Operation, request *http.Request, result Result) []string {
+	options := &openapi3filter.Options{IncludeResponseStatus: true, MultiError: true}
+	input := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: &openapi3filter.RequestValidationInput{
+			Request: request,
+			Route:   &routers.Route{Method: request.Method, Operation: operation},
+			Options: options,
+		},
+		Status:  result.StatusCode,
+		Header:  result.Header,
+		Body:    ioutil.NopCloser(bytes.NewReader(result.Body)),
+		Options: options,
+	}
+
+	if input.Header == nil {
+		input.Header = http.Header{}
+	}
+
+	if err := openapi3filter.ValidateResponse(context.Background(), input); err != nil {
+		return describeResponseError(err)
+	}
+
+	return nil
+}
+
+// describeResponseError flattens the validation errors to one readable line per problem, without the schema dumps.
+func describeResponseError(err error) []string {
+	switch e := err.(type) {
+	case openapi3.MultiError:
+		var problems []string
+		for _, inner := range e {
+			problems = append(problems, describeResponseError(inner)...)
+		}
+		return problems
+	case *openapi3filter.ResponseError:
+		if e.Err == nil {
+			return []string{e.Reason}
+		}
+
+		var problems []string
+		for _, problem := range describeResponseError(e.Err) {
+			problems = append(problems, e.Reason+": "+problem)
+		}
+		return problems
+	case *openapi3.SchemaError:
+		if pointer := e.JSONPointer(); len(pointer) > 0 {
+			return []string{"/" + strings.Join(pointer, "/") + " " + e.Reason}
+		}
+		return []string{e.Reason}
+	default:
+		return []string{err.Error()}
+	}
+}
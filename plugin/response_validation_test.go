@@ -0,0 +1,172 @@
+package plugin
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	plugin_sdk "github.com/blinkops/blink-sdk/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	profilesOpenApi = `
+openapi: 3.0.0
+info:
+  title: profiles
+  version: 1.0.0
+servers:
+  - url: https://api.example.com
+paths:
+  /profiles/{profile}:
+    get:
+      operationId: GetProfile
+      parameters:
+        - name: profile
+          in: path
+          required: true
+          schema:
+            type: string
+      responses:
+        "200":
+          description: profile
+          content:
+            application/json:
+              schema:
+                type: object
+                required: [id]
+                properties:
+                  id:
+                    type: integer
+                  name:
+                    type: string
+`
+
+	profilesMask = `
+actions:
+  GetProfile:
+    alias: Get Profile
+    response_validation: strict
+    parameters:
+      profile:
+        alias: Profile
+`
+)
+
+// profileResponses are served by the test server, the path param selects the response.
+var profileResponses = map[string]struct {
+	status      int
+	contentType string
+	body        string
+}{
+	"valid":        {http.StatusOK, "application/json", `{"id": 1, "name": "jane"}`},
+	"wrong-type":   {http.StatusOK, "application/json; charset=utf-8", `{"id": "one", "name": "jane"}`},
+	"missing":      {http.StatusOK, "application/json", `{"name": "jane"}`},
+	"content-type": {http.StatusOK, "text/html", `<html></html>`},
+	"status":       {http.StatusAccepted, "application/json", `{"id": 1}`},
+	"not-found":    {http.StatusNotFound, "application/json", `{"message": "not found"}`},
+}
+
+type ResponseValidationTestSuite struct {
+	suite.Suite
+	server      *httptest.Server
+	openApiFile string
+	maskFile    string
+	lastResult  Result
+}
+
+func (suite *ResponseValidationTestSuite) SetupSuite() {
+	suite.server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		response := profileResponses[filepath.Base(req.URL.Path)]
+		res.Header().Set(consts.ContentTypeHeader, response.contentType)
+		res.WriteHeader(response.status)
+		_, _ = res.Write([]byte(response.body))
+	}))
+
+	dir := suite.T().TempDir()
+	suite.openApiFile = filepath.Join(dir, "profiles-openapi.yaml")
+	suite.maskFile = filepath.Join(dir, "profiles-mask.yaml")
+	require.NoError(suite.T(), ioutil.WriteFile(suite.openApiFile, []byte(profilesOpenApi), 0600))
+	require.NoError(suite.T(), ioutil.WriteFile(suite.maskFile, []byte(profilesMask), 0600))
+}
+
+func (suite *ResponseValidationTestSuite) TearDownSuite() {
+	suite.server.Close()
+}
+
+func (suite *ResponseValidationTestSuite) execute(mode ResponseValidationMode, maskFile string, actionName string, profile string) *plugin_sdk.ExecuteActionResponse {
+	callbacks := Callbacks{ValidateResponse: func(result Result) (bool, []byte) {
+		suite.lastResult = result
+		return validateDefault(result)
+	}}
+
+	p, err := NewOpenApiPlugin(nil, PluginMetadata{Name: "profiles", Provider: "profiles", OpenApiFile: suite.openApiFile, MaskFile: maskFile, ResponseValidation: mode}, callbacks)
+	require.NoError(suite.T(), err)
+
+	suite.lastResult = Result{}
+	return p.executeActionWithCredentials(map[string]string{consts.RequestUrlKey: suite.server.URL}, &plugin_sdk.ExecuteActionRequest{Name: actionName, Parameters: map[string]string{"profile": profile}})
+}
+
+// the reasons of schema errors come from kin-openapi, so only the start of the problems is compared.
+func (suite *ResponseValidationTestSuite) TestStrict() {
+	cases := map[string]string{
+		"valid":        "",
+		"not-found":    "",
+		"wrong-type":   "The response doesn't match the openapi spec:\nresponse body doesn't match the schema: /id ",
+		"missing":      "The response doesn't match the openapi spec:\nresponse body doesn't match the schema: ",
+		"content-type": "The response doesn't match the openapi spec:\nresponse header Content-Type has unexpected value: \"text/html\"",
+		"status":       "The response doesn't match the openapi spec:\nstatus is not supported",
+	}
+
+	for profile, problem := range cases {
+		res := suite.execute(ResponseValidationStrict, "", "GetProfile", profile)
+
+		if problem == "" {
+			assert.Equal(suite.T(), profileResponses[profile].body, string(suite.lastResult.Body), profile)
+			continue
+		}
+
+		assert.Equal(suite.T(), int64(consts.Error), res.ErrorCode, profile)
+		actionErr := decodeActionError(suite.T(), res.Result)
+		assert.Equal(suite.T(), ErrorCategoryServer, actionErr.Category, profile)
+		assert.True(suite.T(), strings.HasPrefix(actionErr.Message, problem), "%s: %s", profile, actionErr.Message)
+	}
+}
+
+func (suite *ResponseValidationTestSuite) TestWarn() {
+	res := suite.execute(ResponseValidationWarn, "", "GetProfile", "wrong-type")
+
+	assert.Equal(suite.T(), int64(consts.OK), res.ErrorCode)
+	assert.Equal(suite.T(), profileResponses["wrong-type"].body, string(res.Result))
+	if assert.Len(suite.T(), suite.lastResult.SchemaWarnings, 1) {
+		assert.True(suite.T(), strings.HasPrefix(suite.lastResult.SchemaWarnings[0], "response body doesn't match the schema: /id "))
+	}
+
+	suite.execute(ResponseValidationWarn, "", "GetProfile", "valid")
+	assert.Empty(suite.T(), suite.lastResult.SchemaWarnings)
+}
+
+func (suite *ResponseValidationTestSuite) TestOff() {
+	for _, mode := range []ResponseValidationMode{"", ResponseValidationOff} {
+		res := suite.execute(mode, "", "GetProfile", "wrong-type")
+
+		assert.Equal(suite.T(), int64(consts.OK), res.ErrorCode)
+		assert.Empty(suite.T(), suite.lastResult.SchemaWarnings)
+	}
+}
+
+func (suite *ResponseValidationTestSuite) TestMaskOverride() {
+	res := suite.execute(ResponseValidationOff, suite.maskFile, "Get Profile", "wrong-type")
+
+	assert.Equal(suite.T(), int64(consts.Error), res.ErrorCode)
+}
+
+func TestResponseValidationSuite(t *testing.T) {
+	suite.Run(t, new(ResponseValidationTestSuite))
+}
@@ -0,0 +1,259 @@
+package plugin
+
+import (
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultInitialBackoff = 500 * time.Millisecond
+	defaultMaxBackoff     = 30 * time.Second
+	defaultMaxServerWait  = 2 * time.Minute
+
+	retryAfterHeader     = "Retry-After"
+	rateLimitResetHeader = "X-RateLimit-Reset"
+
+	// X-RateLimit-Reset values above this are unix timestamps, smaller values are seconds to wait.
+	minResetTimestamp = 1000000000
+)
+
+var (
+	defaultRetryStatusCodes = []int{http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+	idempotentMethods       = []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace}
+)
+
+// RetryPolicy controls how failed requests are retried.
+// The zero value sends every request once.
+type RetryPolicy struct {
+	MaxAttempts     int           // total number of attempts, including the first one
+	InitialBackoff  time.Duration // backoff before the first retry, doubled on every retry
+	MaxBackoff      time.Duration // max backoff between attempts
+	MaxServerWait   time.Duration // max wait for the server's hints, the failed result is returned when the server asks for longer
+	RetryAllMethods bool          // by default only idempotent methods are retried
+	StatusCodes     []int         // status codes to retry on, defaults to 429 and 5xx gateway errors
+}
+
+// sender sends single requests, retrying them according to the retry policy.
+type sender struct {
+	client    *http.Client
+	retry     RetryPolicy
+	limiter   *tokenBucket
+	weight    int
+	sizeLimit ResponseSizeLimit
+	auth      authenticator // nil when the connection only has static headers
+	redact    redactor
+}
+
+// getRetryPolicy returns the plugin retry policy, overridden by the action's mask.
+func (p *openApiPlugin) getRetryPolicy(actionName string) RetryPolicy {
+	policy := p.retryPolicy
+
+	maskedAction := p.mask.GetAction(actionName)
+	if maskedAction == nil || maskedAction.Retry == nil {
+		return policy
+	}
+
+	if maskedAction.Retry.MaxAttempts != 0 {
+		policy.MaxAttempts = maskedAction.Retry.MaxAttempts
+	}
+	if maskedAction.Retry.InitialBackoff != 0 {
+		policy.InitialBackoff = maskedAction.Retry.InitialBackoff
+	}
+	if maskedAction.Retry.MaxBackoff != 0 {
+		policy.MaxBackoff = maskedAction.Retry.MaxBackoff
+	}
+	if maskedAction.Retry.MaxServerWait != 0 {
+		policy.MaxServerWait = maskedAction.Retry.MaxServerWait
+	}
+	if maskedAction.Retry.RetryAllMethods {
+		policy.RetryAllMethods = true
+	}
+	if len(maskedAction.Retry.StatusCodes) > 0 {
+		policy.StatusCodes = maskedAction.Retry.StatusCodes
+	}
+
+	return policy
+}
+
+// send sends the request and retries it while the policy allows it.
+func (s sender) send(request *http.Request) (Result, error) {
+	if !s.retry.allowsRetry(request.Method) {
+		return s.sendOnce(request)
+	}
+
+	if err := makeBodyRewindable(request); err != nil {
+		return Result{}, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		result, err := s.sendOnce(request)
+		if attempt >= s.retry.MaxAttempts || !s.retry.shouldRetry(request.Context(), result, err) {
+			return result, err
+		}
+
+		wait, ok := s.retry.backoff(request.Context(), attempt, result)
+		if !ok {
+			log.Warnf("Attempt %d/%d of %s %s failed, not retrying since the server asked to wait %s", attempt, s.retry.MaxAttempts, request.Method, s.redact.url(request.URL), wait)
+			return result, err
+		}
+
+		log.Warnf("Attempt %d/%d of %s %s failed, retrying in %s", attempt, s.retry.MaxAttempts, request.Method, s.redact.url(request.URL), wait)
+
+		select {
+		case <-request.Context().Done():
+			return result, err
+		case <-time.After(wait):
+		}
+
+		if request.GetBody != nil {
+			if request.Body, err = request.GetBody(); err != nil {
+				return result, err
+			}
+		}
+	}
+}
+
+// sendOnce waits for the rate limiter and sends the request.
+func (s sender) sendOnce(request *http.Request) (Result, error) {
+	if err := s.limiter.wait(request.Context(), s.weight); err != nil {
+		log.Error(err)
+		return Result{}, err
+	}
+
+	if s.auth != nil {
+		return s.sendAuthenticated(request)
+	}
+
+	return sendRequest(s.client, request, s.sizeLimit, s.redact)
+}
+
+func (r RetryPolicy) allowsRetry(method string) bool {
+	if r.MaxAttempts <= 1 {
+		return false
+	}
+
+	return r.RetryAllMethods || StringInSlice(method, idempotentMethods)
+}
+
+func (r RetryPolicy) shouldRetry(ctx context.Context, result Result, err error) bool {
+	if err != nil {
+		// the response will be just as large next time.
+		var tooLargeErr *ResponseTooLargeError
+		if errors.As(err, &tooLargeErr) {
+			return false
+		}
+
+		// the request was canceled or timed out by the caller, trying again won't help.
+		return ctx.Err() == nil
+	}
+
+	statusCodes := r.StatusCodes
+	if len(statusCodes) == 0 {
+		statusCodes = defaultRetryStatusCodes
+	}
+
+	for _, statusCode := range statusCodes {
+		if result.StatusCode == statusCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backoff returns how long to wait before the next attempt.
+// the server's Retry-After/X-RateLimit-Reset hints are preferred over the exponential backoff,
+// it returns false when the hint is longer than the max server wait or than the time left until the deadline of the request.
+func (r RetryPolicy) backoff(ctx context.Context, attempt int, result Result) (time.Duration, bool) {
+	if wait, ok := serverWaitHint(result, time.Now()); ok {
+		maxServerWait := r.MaxServerWait
+		if maxServerWait <= 0 {
+			maxServerWait = defaultMaxServerWait
+		}
+		if wait > maxServerWait {
+			return wait, false
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return wait, false
+		}
+
+		return wait, true
+	}
+
+	initialBackoff, maxBackoff := r.InitialBackoff, r.MaxBackoff
+	if initialBackoff <= 0 {
+		initialBackoff = defaultInitialBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+
+	wait := initialBackoff << uint(attempt-1)
+	if wait > maxBackoff || wait <= 0 {
+		wait = maxBackoff
+	}
+
+	// jitter in [wait/2, wait) so parallel actions don't retry together.
+	half := int64(wait / 2)
+	return time.Duration(half + rand.Int63n(half+1)), true
+}
+
+// serverWaitHint parses the Retry-After (seconds or http date) and X-RateLimit-Reset (timestamp or seconds) headers.
+// some providers send X-RateLimit-Reset with every response, so it is only used when the request was rate limited.
+func serverWaitHint(result Result, now time.Time) (time.Duration, bool) {
+	header := result.Header
+	if header == nil {
+		return 0, false
+	}
+
+	if retryAfter := header.Get(retryAfterHeader); retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil {
+			return nonNegative(time.Duration(seconds) * time.Second), true
+		}
+		if date, err := http.ParseTime(retryAfter); err == nil {
+			return nonNegative(date.Sub(now)), true
+		}
+	}
+
+	if reset := header.Get(rateLimitResetHeader); reset != "" && result.StatusCode == http.StatusTooManyRequests {
+		if value, err := strconv.ParseInt(reset, 10, 64); err == nil {
+			if value > minResetTimestamp {
+				return nonNegative(time.Unix(value, 0).Sub(now)), true
+			}
+			return nonNegative(time.Duration(value) * time.Second), true
+		}
+	}
+
+	return 0, false
+}
+
+func nonNegative(duration time.Duration) time.Duration {
+	if duration < 0 {
+		return 0
+	}
+
+	return duration
+}
+
+// makeBodyRewindable buffers a body that can only be read once, so it can be sent again.
+func makeBodyRewindable(request *http.Request) error {
+	if request.Body == nil || request.Body == http.NoBody || request.GetBody != nil {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to read request body")
+	}
+	_ = request.Body.Close()
+
+	setRequestBody(request, body)
+	return nil
+}
@@ -0,0 +1,235 @@
+package plugin
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blinkops/blink-openapi-sdk/mask"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type RetryTestSuite struct {
+	suite.Suite
+	server     *httptest.Server
+	failures   int
+	attempts   int
+	bodies     []string
+	retryAfter string
+}
+
+// the test server fails with 503 until it failed suite.failures times.
+func (suite *RetryTestSuite) SetupTest() {
+	suite.attempts = 0
+	suite.bodies = nil
+	suite.retryAfter = "0"
+	suite.server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		suite.attempts++
+		body, _ := ioutil.ReadAll(req.Body)
+		suite.bodies = append(suite.bodies, string(body))
+
+		if suite.attempts <= suite.failures {
+			res.Header().Set(retryAfterHeader, suite.retryAfter)
+			res.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		res.WriteHeader(http.StatusOK)
+	}))
+}
+
+func (suite *RetryTestSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+func (suite *RetryTestSuite) send(method string, policy RetryPolicy) Result {
+	request, err := http.NewRequest(method, suite.server.URL, nil)
+	require.NoError(suite.T(), err)
+	setRequestBody(request, []byte(`{"name":"blink"}`))
+
+	result, err := sender{client: &http.Client{}, retry: policy}.send(request)
+	require.NoError(suite.T(), err)
+
+	return result
+}
+
+func (suite *RetryTestSuite) TestRetryUntilSuccess() {
+	suite.failures = 2
+	result := suite.send(http.MethodPut, RetryPolicy{MaxAttempts: 3})
+
+	assert.Equal(suite.T(), http.StatusOK, result.StatusCode)
+	assert.Equal(suite.T(), 3, suite.attempts)
+	// the body must be sent again on every attempt
+	assert.Equal(suite.T(), []string{`{"name":"blink"}`, `{"name":"blink"}`, `{"name":"blink"}`}, suite.bodies)
+}
+
+func (suite *RetryTestSuite) TestMaxAttempts() {
+	suite.failures = 5
+	result := suite.send(http.MethodGet, RetryPolicy{MaxAttempts: 2})
+
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, result.StatusCode)
+	assert.Equal(suite.T(), 2, suite.attempts)
+}
+
+func (suite *RetryTestSuite) TestNonIdempotentMethod() {
+	suite.failures = 1
+	result := suite.send(http.MethodPost, RetryPolicy{MaxAttempts: 3})
+
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, result.StatusCode)
+	assert.Equal(suite.T(), 1, suite.attempts)
+
+	suite.attempts = 0
+	result = suite.send(http.MethodPost, RetryPolicy{MaxAttempts: 3, RetryAllMethods: true})
+	assert.Equal(suite.T(), http.StatusOK, result.StatusCode)
+	assert.Equal(suite.T(), 2, suite.attempts)
+}
+
+func (suite *RetryTestSuite) TestNoRetryPolicy() {
+	suite.failures = 1
+	result := suite.send(http.MethodGet, RetryPolicy{})
+
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, result.StatusCode)
+	assert.Equal(suite.T(), 1, suite.attempts)
+}
+
+func (suite *RetryTestSuite) TestRewindUnbufferedBody() {
+	suite.failures = 1
+	request, err := http.NewRequest(http.MethodPut, suite.server.URL, ioutil.NopCloser(strings.NewReader("raw body")))
+	require.NoError(suite.T(), err)
+
+	result, err := sender{client: &http.Client{}, retry: RetryPolicy{MaxAttempts: 2}}.send(request)
+	require.NoError(suite.T(), err)
+
+	assert.Equal(suite.T(), http.StatusOK, result.StatusCode)
+	assert.Equal(suite.T(), []string{"raw body", "raw body"}, suite.bodies)
+}
+
+func (suite *RetryTestSuite) TestServerWaitHint() {
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		result   Result
+		expected time.Duration
+		ok       bool
+	}{
+		{
+			name:     "retry after seconds",
+			result:   Result{StatusCode: http.StatusServiceUnavailable, Header: testHeader(retryAfterHeader, "7")},
+			expected: 7 * time.Second,
+			ok:       true,
+		},
+		{
+			name:     "retry after date",
+			result:   Result{StatusCode: http.StatusTooManyRequests, Header: testHeader(retryAfterHeader, now.Add(time.Minute).Format(http.TimeFormat))},
+			expected: time.Minute,
+			ok:       true,
+		},
+		{
+			name:     "rate limit reset timestamp",
+			result:   Result{StatusCode: http.StatusTooManyRequests, Header: testHeader(rateLimitResetHeader, "1633089630")},
+			expected: 30 * time.Second,
+			ok:       true,
+		},
+		{
+			name:     "rate limit reset seconds",
+			result:   Result{StatusCode: http.StatusTooManyRequests, Header: testHeader(rateLimitResetHeader, "12")},
+			expected: 12 * time.Second,
+			ok:       true,
+		},
+		{
+			name:   "rate limit reset is ignored when not rate limited",
+			result: Result{StatusCode: http.StatusBadGateway, Header: testHeader(rateLimitResetHeader, "12")},
+			ok:     false,
+		},
+		{
+			name:   "no hint",
+			result: Result{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}},
+			ok:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		suite.T().Run("test serverWaitHint(): "+tt.name, func(t *testing.T) {
+			wait, ok := serverWaitHint(tt.result, now)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.expected, wait)
+		})
+	}
+}
+
+func (suite *RetryTestSuite) TestBackoff() {
+	policy := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 3 * time.Second}
+
+	first, ok := policy.backoff(context.Background(), 1, Result{})
+	assert.True(suite.T(), ok)
+	assert.True(suite.T(), first >= 500*time.Millisecond && first <= time.Second)
+
+	capped, ok := policy.backoff(context.Background(), 5, Result{})
+	assert.True(suite.T(), ok)
+	assert.True(suite.T(), capped >= 1500*time.Millisecond && capped <= 3*time.Second)
+}
+
+func (suite *RetryTestSuite) TestServerWaitCap() {
+	// the server asks to wait longer than the policy allows, the failed result is returned right away.
+	suite.failures = 1
+	suite.retryAfter = "3600"
+	start := time.Now()
+	result := suite.send(http.MethodGet, RetryPolicy{MaxAttempts: 3, MaxServerWait: time.Minute})
+
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, result.StatusCode)
+	assert.Equal(suite.T(), 1, suite.attempts)
+	assert.Less(suite.T(), time.Since(start), time.Second)
+
+	wait, ok := RetryPolicy{}.backoff(context.Background(), 1, Result{Header: testHeader(retryAfterHeader, "60")})
+	assert.True(suite.T(), ok)
+	assert.Equal(suite.T(), time.Minute, wait)
+
+	_, ok = RetryPolicy{}.backoff(context.Background(), 1, Result{Header: testHeader(retryAfterHeader, "600")})
+	assert.False(suite.T(), ok)
+}
+
+func (suite *RetryTestSuite) TestServerWaitPastDeadline() {
+	// the server asks to wait past the deadline of the request, the failed result is returned instead of the deadline error.
+	suite.failures = 1
+	suite.retryAfter = "5"
+	request, err := http.NewRequest(http.MethodGet, suite.server.URL, nil)
+	require.NoError(suite.T(), err)
+	ctx, cancel := context.WithTimeout(request.Context(), 2*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	result, err := sender{client: &http.Client{}, retry: RetryPolicy{MaxAttempts: 3}}.send(request.WithContext(ctx))
+	require.NoError(suite.T(), err)
+
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, result.StatusCode)
+	assert.Equal(suite.T(), 1, suite.attempts)
+	assert.Less(suite.T(), time.Since(start), time.Second)
+}
+
+func (suite *RetryTestSuite) TestGetRetryPolicy() {
+	p := &openApiPlugin{
+		retryPolicy: RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second},
+		mask: mask.Mask{Actions: map[string]*mask.MaskedAction{
+			"CreateIssue": {Retry: &mask.Retry{MaxAttempts: 5, RetryAllMethods: true}},
+		}},
+	}
+
+	assert.Equal(suite.T(), RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second}, p.getRetryPolicy("ListIssues"))
+	assert.Equal(suite.T(), RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second, RetryAllMethods: true}, p.getRetryPolicy("CreateIssue"))
+}
+
+func testHeader(key string, value string) http.Header {
+	header := http.Header{}
+	header.Set(key, value)
+	return header
+}
+
+func TestRetrySuite(t *testing.T) {
+	suite.Run(t, new(RetryTestSuite))
+}
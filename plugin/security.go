@@ -0,0 +1,185 @@
+package plugin
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	"github.com/blinkops/blink-openapi-sdk/plugin/handlers"
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+const (
+	securityTypeApiKey        = "apiKey"
+	securityTypeHttp          = "http"
+	securityTypeOAuth2        = "oauth2"
+	securityTypeOpenIdConnect = "openIdConnect"
+
+	securitySchemeBasic  = "basic"
+	securitySchemeBearer = "bearer"
+
+	authorizationHeader = "Authorization"
+)
+
+// bearerTokenKeys are the connection keys of bearer tokens, when the connection doesn't have the scheme name.
+var bearerTokenKeys = []string{"TOKEN", "ACCESS_TOKEN", authorizationHeader}
+
+type (
+	// securityRequirement is a set of security schemes that are used together, an operation accepts one of its requirements.
+	securityRequirement []namedSecurityScheme
+
+	namedSecurityScheme struct {
+		name   string
+		scheme *openapi3.SecurityScheme
+	}
+
+	// securityCredential is a credential of the connection and where it is sent.
+	securityCredential struct {
+		in    string // header, query or cookie
+		name  string
+		value string
+	}
+)
+
+// getSecurityRequirements returns the security requirements of the action's operation, with their schemes.
+func (p *openApiPlugin) getSecurityRequirements(actionName string) []securityRequirement {
+	operation := p.operations.Get(p.mask.ReplaceActionAlias(actionName))
+	if operation == nil {
+		return nil
+	}
+
+	return operationSecurityRequirements(operation, p.securitySchemes)
+}
+
+func operationSecurityRequirements(operation *handlers.OperationDefinition, securitySchemes openapi3.SecuritySchemes) []securityRequirement {
+	var requirements []securityRequirement
+	for _, definition := range operation.SecurityDefinitions {
+		for len(requirements) <= definition.Requirement {
+			requirements = append(requirements, securityRequirement{})
+		}
+
+		// a scheme that isn't in the components is kept without its definition, so its requirement can't be satisfied.
+		named := namedSecurityScheme{name: definition.ProviderName}
+		if schemeRef := securitySchemes[definition.ProviderName]; schemeRef != nil {
+			named.scheme = schemeRef.Value
+		}
+
+		requirements[definition.Requirement] = append(requirements[definition.Requirement], named)
+	}
+
+	return requirements
+}
+
+// resolveSecurity returns the credentials of the first requirement the connection has all the credentials of,
+// and the connection without the keys they were taken from, so they aren't sent as headers too.
+func resolveSecurity(connection map[string]string, requirements []securityRequirement) ([]securityCredential, map[string]string) {
+	for _, requirement := range requirements {
+		if len(requirement) == 0 {
+			continue
+		}
+
+		var (
+			credentials []securityCredential
+			usedKeys    []string
+		)
+
+		for _, named := range requirement {
+			credential, keys, ok := named.credential(connection)
+			if !ok {
+				credentials = nil
+				break
+			}
+
+			credentials = append(credentials, credential)
+			usedKeys = append(usedKeys, keys...)
+		}
+
+		if len(credentials) > 0 {
+			return credentials, withoutConnectionKeys(connection, usedKeys)
+		}
+	}
+
+	return nil, connection
+}
+
+// credential maps the connection fields onto the scheme, the field of the scheme name is preferred,
+// then the fields the scheme conventionally uses, like the header name of api keys.
+func (s namedSecurityScheme) credential(connection map[string]string) (securityCredential, []string, bool) {
+	if s.scheme == nil {
+		return securityCredential{}, nil, false
+	}
+
+	switch s.scheme.Type {
+	case securityTypeApiKey:
+		for _, key := range []string{s.name, s.scheme.Name} {
+			if value := connectionValue(connection, key); value != "" {
+				return securityCredential{in: s.scheme.In, name: s.scheme.Name, value: value}, []string{key}, true
+			}
+		}
+	case securityTypeHttp:
+		switch strings.ToLower(s.scheme.Scheme) {
+		case securitySchemeBasic:
+			return s.basicCredential(connection)
+		case securitySchemeBearer:
+			return s.bearerCredential(connection)
+		}
+	case securityTypeOAuth2, securityTypeOpenIdConnect:
+		return s.bearerCredential(connection)
+	}
+
+	return securityCredential{}, nil, false
+}
+
+// basicCredential uses the USERNAME and PASSWORD fields, or a user:password field of the scheme name.
+func (s namedSecurityScheme) basicCredential(connection map[string]string) (securityCredential, []string, bool) {
+	username, password := connectionValue(connection, consts.BasicAuthUsername), connectionValue(connection, consts.BasicAuthPassword)
+	if username != "" || password != "" {
+		return securityCredential{in: openapi3.ParameterInHeader, name: authorizationHeader, value: constructBasicAuthHeader(username, password)},
+			[]string{consts.BasicAuthUsername, consts.BasicAuthPassword}, true
+	}
+
+	if value := connectionValue(connection, s.name); value != "" {
+		if strings.HasPrefix(value, consts.BasicAuth) {
+			return securityCredential{in: openapi3.ParameterInHeader, name: authorizationHeader, value: value}, []string{s.name}, true
+		}
+
+		username, password := value, ""
+		if i := strings.Index(value, ":"); i >= 0 {
+			username, password = value[:i], value[i+1:]
+		}
+		return securityCredential{in: openapi3.ParameterInHeader, name: authorizationHeader, value: constructBasicAuthHeader(username, password)}, []string{s.name}, true
+	}
+
+	return securityCredential{}, nil, false
+}
+
+func (s namedSecurityScheme) bearerCredential(connection map[string]string) (securityCredential, []string, bool) {
+	for _, key := range append([]string{s.name}, bearerTokenKeys...) {
+		if value := connectionValue(connection, key); value != "" {
+			// an authorization field may have another scheme, like "Token <token>".
+			isAuthorization := strings.EqualFold(key, authorizationHeader) && strings.Contains(value, " ")
+			if !isAuthorization && !strings.HasPrefix(value, consts.BearerAuth) {
+				value = consts.BearerAuth + value
+			}
+			return securityCredential{in: openapi3.ParameterInHeader, name: authorizationHeader, value: value}, []string{key}, true
+		}
+	}
+
+	return securityCredential{}, nil, false
+}
+
+// applySecurityCredentials sends the credentials where their schemes declared.
+func applySecurityCredentials(request *http.Request, credentials []securityCredential) {
+	for _, credential := range credentials {
+		switch credential.in {
+		case openapi3.ParameterInQuery:
+			query := request.URL.Query()
+			query.Set(credential.name, credential.value)
+			request.URL.RawQuery = query.Encode()
+		case openapi3.ParameterInCookie:
+			request.AddCookie(&http.Cookie{Name: credential.name, Value: credential.value})
+		default:
+			request.Header.Set(credential.name, credential.value)
+		}
+	}
+}
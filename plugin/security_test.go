@@ -0,0 +1,236 @@
+package plugin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	plugin_sdk "github.com/blinkops/blink-sdk/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const securityOpenApi = `
+openapi: 3.0.0
+info:
+  title: security
+  version: 1.0.0
+servers:
+  - url: https://api.example.com
+security:
+  - bearerAuth: []
+paths:
+  /default:
+    get:
+      operationId: GetDefault
+      responses:
+        "200":
+          description: ok
+  /header:
+    get:
+      operationId: GetHeader
+      security:
+        - apiKeyHeader: []
+      responses:
+        "200":
+          description: ok
+  /query:
+    get:
+      operationId: GetQuery
+      parameters:
+        - name: page
+          in: query
+          schema:
+            type: string
+      security:
+        - apiKeyQuery: []
+      responses:
+        "200":
+          description: ok
+  /cookie:
+    get:
+      operationId: GetCookie
+      security:
+        - sessionCookie: []
+      responses:
+        "200":
+          description: ok
+  /basic:
+    get:
+      operationId: GetBasic
+      security:
+        - basicAuth: []
+      responses:
+        "200":
+          description: ok
+  /either:
+    get:
+      operationId: GetEither
+      security:
+        - apiKeyHeader: []
+          apiKeyQuery: []
+        - bearerAuth: []
+      responses:
+        "200":
+          description: ok
+  /undefined:
+    get:
+      operationId: GetUndefined
+      security:
+        - apiKeyHeader: []
+          undefinedAuth: []
+        - bearerAuth: []
+      responses:
+        "200":
+          description: ok
+  /public:
+    get:
+      operationId: GetPublic
+      security: []
+      responses:
+        "200":
+          description: ok
+components:
+  securitySchemes:
+    apiKeyHeader:
+      type: apiKey
+      in: header
+      name: X-Api-Key
+    apiKeyQuery:
+      type: apiKey
+      in: query
+      name: api_key
+    sessionCookie:
+      type: apiKey
+      in: cookie
+      name: session
+    basicAuth:
+      type: http
+      scheme: basic
+    bearerAuth:
+      type: http
+      scheme: bearer
+`
+
+// securityEcho is the credentials the test server got.
+type securityEcho struct {
+	Authorization string `json:"authorization"`
+	ApiKey        string `json:"api_key_header"`
+	QueryKey      string `json:"api_key_query"`
+	Page          string `json:"page"`
+	Session       string `json:"session"`
+	Token         string `json:"token_header"`
+}
+
+type SecurityTestSuite struct {
+	suite.Suite
+	server *httptest.Server
+	plugin *openApiPlugin
+}
+
+func (suite *SecurityTestSuite) SetupSuite() {
+	suite.server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		echo := securityEcho{
+			Authorization: req.Header.Get("Authorization"),
+			ApiKey:        req.Header.Get("X-Api-Key"),
+			QueryKey:      req.URL.Query().Get("api_key"),
+			Page:          req.URL.Query().Get("page"),
+			Token:         req.Header.Get("Token"),
+		}
+		if cookie, err := req.Cookie("session"); err == nil {
+			echo.Session = cookie.Value
+		}
+
+		body, _ := json.Marshal(echo)
+		res.Header().Set(consts.ContentTypeHeader, consts.RequestBodyType)
+		_, _ = res.Write(body)
+	}))
+
+	openApiFile := filepath.Join(suite.T().TempDir(), "security-openapi.yaml")
+	require.NoError(suite.T(), ioutil.WriteFile(openApiFile, []byte(securityOpenApi), 0600))
+
+	p, err := NewOpenApiPlugin(nil, PluginMetadata{Name: "security", Provider: "security", OpenApiFile: openApiFile}, Callbacks{ValidateResponse: validateDefault})
+	require.NoError(suite.T(), err)
+	suite.plugin = p
+}
+
+func (suite *SecurityTestSuite) TearDownSuite() {
+	suite.server.Close()
+}
+
+func (suite *SecurityTestSuite) execute(actionName string, connection map[string]string, parameters map[string]string) securityEcho {
+	connection[consts.RequestUrlKey] = suite.server.URL
+	res := suite.plugin.executeActionWithCredentials(connection, &plugin_sdk.ExecuteActionRequest{Name: actionName, Parameters: parameters})
+	require.Equal(suite.T(), int64(consts.OK), res.ErrorCode, string(res.Result))
+
+	echo := securityEcho{}
+	require.NoError(suite.T(), json.Unmarshal(res.Result, &echo))
+	return echo
+}
+
+func (suite *SecurityTestSuite) TestApiKey() {
+	// the connection field can be the scheme name or the declared name.
+	echo := suite.execute("GetHeader", map[string]string{"apiKeyHeader": "header-key"}, nil)
+	assert.Equal(suite.T(), securityEcho{ApiKey: "header-key"}, echo)
+
+	echo = suite.execute("GetHeader", map[string]string{"x-api-key": "header-key"}, nil)
+	assert.Equal(suite.T(), securityEcho{ApiKey: "header-key"}, echo)
+
+	echo = suite.execute("GetQuery", map[string]string{"apiKeyQuery": "query key&more"}, map[string]string{"page": "2"})
+	assert.Equal(suite.T(), securityEcho{QueryKey: "query key&more", Page: "2"}, echo)
+
+	echo = suite.execute("GetCookie", map[string]string{"SESSIONCOOKIE": "session-id"}, nil)
+	assert.Equal(suite.T(), securityEcho{Session: "session-id"}, echo)
+}
+
+func (suite *SecurityTestSuite) TestHttpSchemes() {
+	echo := suite.execute("GetBasic", map[string]string{consts.BasicAuthUsername: "jane", consts.BasicAuthPassword: "secret"}, nil)
+	assert.Equal(suite.T(), securityEcho{Authorization: constructBasicAuthHeader("jane", "secret")}, echo)
+
+	echo = suite.execute("GetBasic", map[string]string{"basicAuth": "jane:secret"}, nil)
+	assert.Equal(suite.T(), securityEcho{Authorization: constructBasicAuthHeader("jane", "secret")}, echo)
+
+	// the bearer prefix isn't doubled, the operations without security use the global requirements.
+	for _, token := range []string{"token", "Bearer token"} {
+		echo = suite.execute("GetDefault", map[string]string{"TOKEN": token}, nil)
+		assert.Equal(suite.T(), securityEcho{Authorization: "Bearer token"}, echo)
+	}
+}
+
+func (suite *SecurityTestSuite) TestRequirementAlternatives() {
+	// the schemes of a requirement are used together.
+	echo := suite.execute("GetEither", map[string]string{"X-Api-Key": "header-key", "api_key": "query-key", "TOKEN": "token"}, nil)
+	assert.Equal(suite.T(), securityEcho{ApiKey: "header-key", QueryKey: "query-key", Token: "token"}, echo)
+
+	// a requirement is only used when the connection has all its credentials.
+	echo = suite.execute("GetEither", map[string]string{"X-Api-Key": "header-key", "bearerAuth": "token"}, nil)
+	assert.Equal(suite.T(), securityEcho{ApiKey: "header-key", Authorization: "Bearer token"}, echo)
+}
+
+func (suite *SecurityTestSuite) TestUndefinedScheme() {
+	// the requirement with a scheme that isn't defined can't be satisfied, the next one is used.
+	echo := suite.execute("GetUndefined", map[string]string{"X-Api-Key": "header-key", "bearerAuth": "token"}, nil)
+	assert.Equal(suite.T(), securityEcho{ApiKey: "header-key", Authorization: "Bearer token"}, echo)
+
+	requirements := suite.plugin.getSecurityRequirements("GetUndefined")
+	require.Len(suite.T(), requirements, 2)
+	assert.Len(suite.T(), requirements[0], 2)
+}
+
+func (suite *SecurityTestSuite) TestStaticHeaders() {
+	// operations without security and fields that don't map onto a scheme are sent as headers, like before.
+	echo := suite.execute("GetPublic", map[string]string{"Token": "token"}, nil)
+	assert.Equal(suite.T(), securityEcho{Token: "token"}, echo)
+
+	echo = suite.execute("GetQuery", map[string]string{"X-Api-Key": "header-key"}, nil)
+	assert.Equal(suite.T(), securityEcho{ApiKey: "header-key"}, echo)
+}
+
+func TestSecuritySuite(t *testing.T) {
+	suite.Run(t, new(SecurityTestSuite))
+}
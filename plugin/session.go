@@ -0,0 +1,349 @@
+package plugin
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	"github.com/blinkops/blink-openapi-sdk/mask"
+	"github.com/blinkops/blink-openapi-sdk/plugin/handlers"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// login responses are read to find the provider's message when the login fails.
+	maxLoginResponseSize = 1 << 20
+
+	// sessions of connections that aren't used anymore are evicted, providers usually expire them by then.
+	sessionIdleTTL = time.Hour
+)
+
+// sessions caches the logged in sessions of all connections in memory, until they are rejected or idle.
+var sessions = &sessionCache{sessions: map[string]*session{}}
+
+type (
+	// SessionConfig logs in with the connection's credentials before the requests of the plugin, for tools without API tokens.
+	// the cookies of the login are sent with the requests of the connection, it logs in again when a request is rejected with 401 or 403.
+	SessionConfig struct {
+		LoginAction        string            // the operation id of the login operation, sessions are disabled when it's empty
+		Parameters         map[string]string // the login params and the connection keys of their values, username: USERNAME and password: PASSWORD by default
+		SessionCookie      string            // the cookie the login must set, any cookies are accepted when it's empty
+		TokenHeader        string            // the login response header with a token, like X-CSRF-Token
+		TokenCookie        string            // the login response cookie with a token, like XSRF-TOKEN
+		TokenRequestHeader string            // the request header of the token, defaults to TokenHeader
+	}
+
+	// sessionLogin is the login operation of the plugin's connections.
+	sessionLogin struct {
+		config     SessionConfig
+		operation  *handlers.OperationDefinition
+		requestUrl string // the default url of the spec, overridden by the connection's REQUEST_URL
+	}
+
+	sessionAuthenticator struct {
+		client     *http.Client
+		login      *sessionLogin
+		params     map[string]string
+		requestUrl string
+		key        string // the key of the session in the cache
+		session    *session
+		state      sessionState // the session the last request was sent with
+	}
+
+	// session is the logged in session of a connection, its mutex makes concurrent requests wait for a single login.
+	session struct {
+		mutex    sync.Mutex
+		state    sessionState
+		lastUsed time.Time // guarded by the cache mutex
+	}
+
+	sessionState struct {
+		jar        http.CookieJar
+		token      string
+		generation int // incremented by every login, zero before the first one
+	}
+
+	sessionCache struct {
+		mutex     sync.Mutex
+		sessions  map[string]*session
+		lastEvict time.Time
+	}
+)
+
+func (c SessionConfig) enabled() bool {
+	return c.LoginAction != ""
+}
+
+func (c SessionConfig) withDefaults() SessionConfig {
+	if len(c.Parameters) == 0 {
+		c.Parameters = map[string]string{"username": consts.BasicAuthUsername, "password": consts.BasicAuthPassword}
+	}
+	if c.TokenRequestHeader == "" {
+		c.TokenRequestHeader = c.TokenHeader
+	}
+
+	return c
+}
+
+// sessionConfig returns the session of the plugin metadata, or the session of the mask when the metadata has none.
+func sessionConfig(config SessionConfig, maskedSession *mask.Session) SessionConfig {
+	if config.enabled() || maskedSession == nil {
+		return config
+	}
+
+	return SessionConfig{
+		LoginAction:        maskedSession.LoginAction,
+		Parameters:         maskedSession.Parameters,
+		SessionCookie:      maskedSession.SessionCookie,
+		TokenHeader:        maskedSession.TokenHeader,
+		TokenCookie:        maskedSession.TokenCookie,
+		TokenRequestHeader: maskedSession.TokenRequestHeader,
+	}
+}
+
+// newSessionLogin returns the login of the config, nil when sessions are disabled.
+func newSessionLogin(config SessionConfig, operations *handlers.OperationRegistry, maskData mask.Mask, requestUrl string) (*sessionLogin, error) {
+	if !config.enabled() {
+		return nil, nil
+	}
+
+	config = config.withDefaults()
+	if config.TokenCookie != "" && config.TokenRequestHeader == "" {
+		return nil, errors.Errorf("the session token cookie %s needs a request header", config.TokenCookie)
+	}
+
+	operation := operations.Get(maskData.ReplaceActionAlias(config.LoginAction))
+	if operation == nil {
+		return nil, errors.Errorf("No operation found for the login action %s", config.LoginAction)
+	}
+
+	return &sessionLogin{config: config, operation: operation, requestUrl: requestUrl}, nil
+}
+
+// newSessionAuthenticator returns the session authenticator of connections with the login params, nil for other connections.
+func newSessionAuthenticator(connection map[string]string, client *http.Client, login *sessionLogin) *sessionAuthenticator {
+	if login == nil {
+		return nil
+	}
+
+	params := make(map[string]string, len(login.config.Parameters))
+	for param, key := range login.config.Parameters {
+		value := connectionValue(connection, key)
+		if value == "" {
+			return nil
+		}
+		params[param] = value
+	}
+
+	requestUrl := getRequestUrlFromConnection(login.requestUrl, connection)
+	key := requestUrl + ":" + login.config.LoginAction + ":" + connectionIdentity(params)
+	return &sessionAuthenticator{
+		client:     client,
+		login:      login,
+		params:     params,
+		requestUrl: requestUrl,
+		key:        key,
+		session:    sessions.get(key),
+	}
+}
+
+// connectionKeys returns the connection keys of the login params, they aren't sent as headers.
+func (l *sessionLogin) connectionKeys() []string {
+	keys := make([]string, 0, len(l.config.Parameters))
+	for _, key := range l.config.Parameters {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// authenticate sends the session cookies and token with the request, it logs in first when the connection has no session.
+func (a *sessionAuthenticator) authenticate(request *http.Request) error {
+	state, err := a.session.get(request.Context(), 0, a.logIn)
+	if err != nil {
+		return err
+	}
+	a.state = state
+
+	// the cookies of a previous attempt are replaced.
+	sessionCookies := state.jar.Cookies(request.URL)
+	names := make(map[string]bool, len(sessionCookies))
+	for _, cookie := range sessionCookies {
+		names[cookie.Name] = true
+	}
+
+	requestCookies := request.Cookies()
+	request.Header.Del("Cookie")
+	for _, cookie := range requestCookies {
+		if !names[cookie.Name] {
+			request.AddCookie(cookie)
+		}
+	}
+	for _, cookie := range sessionCookies {
+		request.AddCookie(cookie)
+	}
+
+	if state.token != "" {
+		request.Header.Set(a.login.config.TokenRequestHeader, state.token)
+	}
+
+	return nil
+}
+
+// reauthenticate keeps the cookies set by the response, and logs in again when the session was rejected.
+func (a *sessionAuthenticator) reauthenticate(request *http.Request, result Result) (bool, error) {
+	if cookies := (&http.Response{Header: result.Header}).Cookies(); len(cookies) > 0 {
+		a.state.jar.SetCookies(request.URL, cookies)
+	}
+
+	if result.StatusCode != http.StatusUnauthorized && result.StatusCode != http.StatusForbidden {
+		return false, nil
+	}
+
+	log.Warnf("The session was rejected by %s %s, logging in again", request.Method, request.URL.Path)
+	if _, err := a.session.get(request.Context(), a.state.generation, a.logIn); err != nil {
+		sessions.remove(a.key, a.session)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// logIn sends the login operation with the connection's params, and returns the session it started.
+func (a *sessionAuthenticator) logIn(ctx context.Context) (sessionState, error) {
+	config := a.login.config
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return sessionState{}, err
+	}
+
+	// the login params come from the connection, they can't reference files.
+	request, err := buildOperationRequest(a.login.operation, a.params, a.requestUrl, "")
+	if err != nil {
+		return sessionState{}, errors.Wrap(err, "failed to build the login request")
+	}
+	if err = fixRequestURL(request); err != nil {
+		return sessionState{}, err
+	}
+
+	// the login's redirects are followed with its cookies.
+	client := *a.client
+	client.Jar = jar
+
+	log.Infof("Logging in: %s %s", request.Method, request.URL.Path)
+	response, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		return sessionState{}, errors.Wrap(err, "failed to log in")
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxLoginResponseSize))
+	if err != nil {
+		return sessionState{}, errors.Wrap(err, "failed to read the login response")
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return sessionState{}, &ActionError{
+			Category:        ErrorCategoryAuth,
+			Message:         "Failed to log in, status " + strconv.Itoa(response.StatusCode),
+			StatusCode:      response.StatusCode,
+			ProviderMessage: providerMessage(body),
+		}
+	}
+
+	cookies := map[string]string{}
+	for _, cookie := range jar.Cookies(request.URL) {
+		cookies[cookie.Name] = cookie.Value
+	}
+
+	if _, ok := cookies[config.SessionCookie]; config.SessionCookie != "" && !ok {
+		return sessionState{}, &ActionError{Category: ErrorCategoryAuth, Message: "The login response doesn't have the " + config.SessionCookie + " cookie"}
+	}
+
+	state := sessionState{jar: jar}
+	switch {
+	case config.TokenCookie != "":
+		state.token = cookies[config.TokenCookie]
+	case config.TokenHeader != "":
+		state.token = response.Header.Get(config.TokenHeader)
+	}
+
+	if state.token == "" && (config.TokenCookie != "" || config.TokenHeader != "") {
+		return sessionState{}, &ActionError{Category: ErrorCategoryAuth, Message: "The login response doesn't have the session token"}
+	}
+
+	return state, nil
+}
+
+// get returns the current session, it logs in when there's no session or the current one is the rejected one.
+// when another request already replaced the rejected session, the new session is used as is.
+// when the login fails the rejected session is dropped, so the next request logs in again instead of sending it.
+func (s *session) get(ctx context.Context, rejected int, logIn func(context.Context) (sessionState, error)) (sessionState, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.state.jar != nil && s.state.generation != rejected {
+		return s.state, nil
+	}
+
+	state, err := logIn(ctx)
+	if err != nil {
+		s.state = sessionState{generation: s.state.generation}
+		return sessionState{}, err
+	}
+
+	state.generation = s.state.generation + 1
+	s.state = state
+	return state, nil
+}
+
+// get returns the session of the key, the same one for all the requests of the connection.
+func (c *sessionCache) get(key string) *session {
+	now := time.Now()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if now.Sub(c.lastEvict) >= sessionIdleTTL {
+		c.evictIdle(now)
+	}
+
+	s, ok := c.sessions[key]
+	if !ok {
+		s = &session{}
+		c.sessions[key] = s
+	}
+	s.lastUsed = now
+
+	return s
+}
+
+// remove drops the session of the key, unless it was already replaced by a new one.
+func (c *sessionCache) remove(key string, s *session) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.sessions[key] == s {
+		delete(c.sessions, key)
+	}
+}
+
+// evictIdle removes the sessions that weren't used for sessionIdleTTL, so connections that are no longer used don't keep their sessions.
+// the caller must hold the lock.
+func (c *sessionCache) evictIdle(now time.Time) {
+	for key, s := range c.sessions {
+		if now.Sub(s.lastUsed) >= sessionIdleTTL {
+			delete(c.sessions, key)
+		}
+	}
+
+	c.lastEvict = now
+}
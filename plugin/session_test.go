@@ -0,0 +1,301 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	plugin_sdk "github.com/blinkops/blink-sdk/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const sessionOpenApi = `
+openapi: 3.0.0
+info:
+  title: tool
+  version: 1.0.0
+servers:
+  - url: https://tool.example.com
+paths:
+  /api/login:
+    post:
+      operationId: Login
+      requestBody:
+        content:
+          application/json:
+            schema:
+              type: object
+              properties:
+                user:
+                  type: string
+                password:
+                  type: string
+      responses:
+        "200":
+          description: logged in
+  /api/orders:
+    get:
+      operationId: ListOrders
+      parameters:
+        - name: status
+          in: query
+          schema:
+            type: string
+      responses:
+        "200":
+          description: orders
+`
+
+const sessionMask = `
+actions:
+  ListOrders:
+    display_name: List Orders
+session:
+  login_action: Login
+  parameters:
+    user: USERNAME
+    password: PASSWORD
+  session_cookie: sid
+  token_cookie: XSRF-TOKEN
+  token_request_header: X-XSRF-TOKEN
+`
+
+type SessionTestSuite struct {
+	suite.Suite
+	server      *httptest.Server
+	openApiFile string
+	maskFile    string
+
+	mutex       sync.Mutex
+	logins      int
+	apiRequests []*http.Request
+	session     string // the only session the server accepts
+	loginDown   bool   // the login fails like an unavailable identity provider
+}
+
+func (suite *SessionTestSuite) SetupTest() {
+	suite.logins = 0
+	suite.apiRequests = nil
+	suite.session = ""
+	suite.loginDown = false
+
+	dir := suite.T().TempDir()
+	suite.openApiFile = filepath.Join(dir, "tool-openapi.yaml")
+	suite.maskFile = filepath.Join(dir, "tool-mask.yaml")
+	require.NoError(suite.T(), ioutil.WriteFile(suite.openApiFile, []byte(sessionOpenApi), 0600))
+	require.NoError(suite.T(), ioutil.WriteFile(suite.maskFile, []byte(sessionMask), 0600))
+
+	suite.server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		suite.mutex.Lock()
+		defer suite.mutex.Unlock()
+		res.Header().Set(consts.ContentTypeHeader, consts.RequestBodyType)
+
+		if req.URL.Path == "/api/login" {
+			if suite.loginDown {
+				res.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+
+			credentials := map[string]string{}
+			_ = json.NewDecoder(req.Body).Decode(&credentials)
+			if credentials["user"] != "jane" || credentials["password"] != "secret" {
+				res.WriteHeader(http.StatusUnauthorized)
+				_, _ = res.Write([]byte(`{"message": "Invalid credentials"}`))
+				return
+			}
+
+			suite.logins++
+			suite.session = fmt.Sprintf("session-%d", suite.logins)
+			http.SetCookie(res, &http.Cookie{Name: "sid", Value: suite.session, Path: "/"})
+			http.SetCookie(res, &http.Cookie{Name: "XSRF-TOKEN", Value: "csrf-" + suite.session, Path: "/"})
+			res.Header().Set("X-CSRF-Token", "csrf-"+suite.session)
+			_, _ = res.Write([]byte(`{}`))
+			return
+		}
+
+		suite.apiRequests = append(suite.apiRequests, req)
+		sid, err := req.Cookie("sid")
+		if err != nil || sid.Value != suite.session {
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if req.Header.Get("X-CSRF-Token") != "csrf-"+suite.session && req.Header.Get("X-XSRF-TOKEN") != "csrf-"+suite.session {
+			res.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		_, _ = fmt.Fprintf(res, `{"session": %q, "status": %q}`, sid.Value, req.URL.Query().Get("status"))
+	}))
+}
+
+func (suite *SessionTestSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+func (suite *SessionTestSuite) newPlugin(meta PluginMetadata) *openApiPlugin {
+	meta.Name, meta.Provider, meta.OpenApiFile = "tool", "tool", suite.openApiFile
+	p, err := NewOpenApiPlugin(nil, meta, Callbacks{})
+	require.NoError(suite.T(), err)
+
+	return p
+}
+
+func (suite *SessionTestSuite) listOrders(p *openApiPlugin, connection map[string]string) *plugin_sdk.ExecuteActionResponse {
+	return p.executeActionWithCredentials(connection, &plugin_sdk.ExecuteActionRequest{Name: "ListOrders", Parameters: map[string]string{"status": "open"}})
+}
+
+func (suite *SessionTestSuite) expireSession() {
+	suite.mutex.Lock()
+	defer suite.mutex.Unlock()
+	suite.session = "expired"
+}
+
+func (suite *SessionTestSuite) TestLoginOnce() {
+	p := suite.newPlugin(PluginMetadata{Session: SessionConfig{
+		LoginAction: "Login",
+		Parameters:  map[string]string{"user": "USERNAME", "password": "PASSWORD"},
+		TokenHeader: "X-CSRF-Token",
+	}})
+	connection := map[string]string{consts.RequestUrlKey: suite.server.URL, "USERNAME": "jane", "PASSWORD": "secret", "X-Tenant": "acme"}
+
+	for i := 0; i < 2; i++ {
+		res := suite.listOrders(p, connection)
+		require.Equal(suite.T(), int64(consts.OK), res.ErrorCode, string(res.Result))
+		assert.JSONEq(suite.T(), `{"session": "session-1", "status": "open"}`, string(res.Result))
+	}
+	assert.Equal(suite.T(), 1, suite.logins)
+
+	// the credentials are only sent to the login.
+	require.Len(suite.T(), suite.apiRequests, 2)
+	header := suite.apiRequests[0].Header
+	assert.Equal(suite.T(), "acme", header.Get("X-Tenant"))
+	assert.Empty(suite.T(), header.Get("USERNAME"))
+	assert.Empty(suite.T(), header.Get("PASSWORD"))
+	assert.Empty(suite.T(), header.Get("Authorization"))
+}
+
+func (suite *SessionTestSuite) TestRelogin() {
+	// the login action is masked out, it isn't an action of the plugin.
+	p := suite.newPlugin(PluginMetadata{MaskFile: suite.maskFile})
+	require.False(suite.T(), p.actionExist("Login"))
+	connection := map[string]string{consts.RequestUrlKey: suite.server.URL, "USERNAME": "jane", "PASSWORD": "secret"}
+
+	res := suite.listOrders(p, connection)
+	require.Equal(suite.T(), int64(consts.OK), res.ErrorCode, string(res.Result))
+
+	// the rejected request is sent again once with the new session.
+	suite.expireSession()
+	res = suite.listOrders(p, connection)
+	require.Equal(suite.T(), int64(consts.OK), res.ErrorCode, string(res.Result))
+	assert.JSONEq(suite.T(), `{"session": "session-2", "status": "open"}`, string(res.Result))
+	assert.Equal(suite.T(), 2, suite.logins)
+	require.Len(suite.T(), suite.apiRequests, 3)
+	assert.Equal(suite.T(), "csrf-session-2", suite.apiRequests[2].Header.Get("X-XSRF-TOKEN"))
+
+	// concurrent rejected requests log in once.
+	suite.expireSession()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := suite.listOrders(p, connection)
+			assert.Equal(suite.T(), int64(consts.OK), res.ErrorCode, string(res.Result))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(suite.T(), 3, suite.logins)
+}
+
+func (suite *SessionTestSuite) TestLoginFailure() {
+	p := suite.newPlugin(PluginMetadata{MaskFile: suite.maskFile})
+
+	res := suite.listOrders(p, map[string]string{consts.RequestUrlKey: suite.server.URL, "USERNAME": "jane", "PASSWORD": "wrong"})
+	require.Equal(suite.T(), int64(consts.Error), res.ErrorCode)
+
+	actionErr := ActionError{}
+	require.NoError(suite.T(), json.Unmarshal(res.Result, &actionErr))
+	assert.Equal(suite.T(), ErrorCategoryAuth, actionErr.Category)
+	assert.Equal(suite.T(), http.StatusUnauthorized, actionErr.StatusCode)
+	assert.Equal(suite.T(), "Invalid credentials", actionErr.ProviderMessage)
+	assert.Empty(suite.T(), suite.apiRequests)
+
+	// connections without the login params don't log in.
+	res = suite.listOrders(p, map[string]string{consts.RequestUrlKey: suite.server.URL})
+	assert.Equal(suite.T(), int64(consts.Error), res.ErrorCode)
+	assert.Len(suite.T(), suite.apiRequests, 1)
+	assert.Equal(suite.T(), 0, suite.logins)
+}
+
+func (suite *SessionTestSuite) TestFailedRelogin() {
+	p := suite.newPlugin(PluginMetadata{MaskFile: suite.maskFile})
+	connection := map[string]string{consts.RequestUrlKey: suite.server.URL, "USERNAME": "jane", "PASSWORD": "secret"}
+
+	res := suite.listOrders(p, connection)
+	require.Equal(suite.T(), int64(consts.OK), res.ErrorCode, string(res.Result))
+	authenticator := newSessionAuthenticator(connection, &http.Client{}, p.session)
+	cached := authenticator.session
+
+	// the rejected session is dropped when the login fails, it isn't sent with the next requests.
+	suite.expireSession()
+	suite.mutex.Lock()
+	suite.loginDown = true
+	suite.mutex.Unlock()
+	res = suite.listOrders(p, connection)
+	require.Equal(suite.T(), int64(consts.Error), res.ErrorCode)
+	assert.Nil(suite.T(), cached.state.jar)
+	assert.NotSame(suite.T(), cached, newSessionAuthenticator(connection, &http.Client{}, p.session).session)
+
+	suite.mutex.Lock()
+	suite.loginDown = false
+	suite.mutex.Unlock()
+	res = suite.listOrders(p, connection)
+	require.Equal(suite.T(), int64(consts.OK), res.ErrorCode, string(res.Result))
+	assert.JSONEq(suite.T(), `{"session": "session-2", "status": "open"}`, string(res.Result))
+}
+
+func (suite *SessionTestSuite) TestEvictIdleSessions() {
+	cache := &sessionCache{sessions: map[string]*session{}}
+	idle := cache.get("idle")
+	active := cache.get("active")
+
+	now := time.Now()
+	idle.lastUsed = now.Add(-sessionIdleTTL)
+	cache.lastEvict = now.Add(-sessionIdleTTL)
+	cache.get("other")
+	assert.Len(suite.T(), cache.sessions, 2)
+	assert.NotSame(suite.T(), idle, cache.get("idle"))
+	assert.Same(suite.T(), active, cache.get("active"))
+
+	// a session that was already replaced isn't removed.
+	replaced := cache.get("other")
+	cache.remove("active", replaced)
+	assert.Same(suite.T(), active, cache.get("active"))
+	cache.remove("active", active)
+	assert.NotSame(suite.T(), active, cache.get("active"))
+}
+
+func (suite *SessionTestSuite) TestInvalidConfig() {
+	configs := []SessionConfig{
+		{LoginAction: "SignIn"},
+		{LoginAction: "Login", TokenCookie: "XSRF-TOKEN"},
+	}
+
+	for _, config := range configs {
+		_, err := NewOpenApiPlugin(nil, PluginMetadata{Name: "tool", Provider: "tool", OpenApiFile: suite.openApiFile, Session: config}, Callbacks{})
+		assert.Error(suite.T(), err, config)
+	}
+}
+
+func TestSessionSuite(t *testing.T) {
+	suite.Run(t, new(SessionTestSuite))
+}
@@ -0,0 +1,223 @@
+package plugin
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	"github.com/pkg/errors"
+)
+
+const (
+	sigV4Algorithm   = "AWS4-HMAC-SHA256"
+	sigV4DateFormat  = "20060102"
+	sigV4TimeFormat  = "20060102T150405Z"
+	sigV4ScopeSuffix = "aws4_request"
+
+	amzDateHeader          = "X-Amz-Date"
+	amzSecurityTokenHeader = "X-Amz-Security-Token"
+	amzContentSha256Header = "X-Amz-Content-Sha256"
+
+	// S3 paths are signed as they are, other services sign the escaped path escaped once more.
+	s3Service = "s3"
+)
+
+// sigV4ConnectionKeys are the connection keys of the signer, they aren't sent as headers when requests are signed.
+var sigV4ConnectionKeys = []string{
+	consts.AwsAccessKeyIdKey,
+	consts.AwsSecretAccessKeyKey,
+	consts.AwsSessionTokenKey,
+	consts.AwsRegionKey,
+	consts.AwsServiceKey,
+}
+
+// sigV4IgnoredHeaders aren't signed, they may be changed on the way to the server.
+var sigV4IgnoredHeaders = []string{"Authorization", "User-Agent", "X-Amzn-Trace-Id", "Expect"}
+
+// sigV4Signer signs requests with AWS Signature Version 4,
+// https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html
+type sigV4Signer struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	region          string
+	service         string
+	now             func() time.Time
+}
+
+// newSigV4Signer returns the signer of connections with an AWS access key, nil for other connections.
+func newSigV4Signer(connection map[string]string) *sigV4Signer {
+	signer := &sigV4Signer{
+		accessKeyID:     connectionValue(connection, consts.AwsAccessKeyIdKey),
+		secretAccessKey: connectionValue(connection, consts.AwsSecretAccessKeyKey),
+		sessionToken:    connectionValue(connection, consts.AwsSessionTokenKey),
+		region:          connectionValue(connection, consts.AwsRegionKey),
+		service:         connectionValue(connection, consts.AwsServiceKey),
+		now:             time.Now,
+	}
+
+	if signer.accessKeyID == "" || signer.secretAccessKey == "" {
+		return nil
+	}
+
+	return signer
+}
+
+// authenticate signs the request as it is sent, it is signed again on every attempt with the current time.
+func (s *sigV4Signer) authenticate(request *http.Request) error {
+	return s.sign(request, s.now())
+}
+
+func (s *sigV4Signer) reauthenticate(*http.Request, Result) (bool, error) {
+	return false, nil
+}
+
+func (s *sigV4Signer) sign(request *http.Request, signingTime time.Time) error {
+	if s.region == "" || s.service == "" {
+		return errors.Errorf("%s and %s are required to sign AWS requests", consts.AwsRegionKey, consts.AwsServiceKey)
+	}
+
+	body, err := readRequestBody(request)
+	if err != nil {
+		return err
+	}
+	payloadHash := sha256Hex(body)
+
+	signingTime = signingTime.UTC()
+	request.Header.Del("Authorization")
+	request.Header.Set(amzDateHeader, signingTime.Format(sigV4TimeFormat))
+	if s.sessionToken != "" {
+		request.Header.Set(amzSecurityTokenHeader, s.sessionToken)
+	}
+	if s.service == s3Service {
+		request.Header.Set(amzContentSha256Header, payloadHash)
+	}
+
+	signedHeaders, canonicalHeaders := sigV4CanonicalHeaders(request)
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		s.canonicalURI(request.URL),
+		sigV4CanonicalQuery(request.URL),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{signingTime.Format(sigV4DateFormat), s.region, s.service, sigV4ScopeSuffix}, "/")
+	stringToSign := strings.Join([]string{sigV4Algorithm, signingTime.Format(sigV4TimeFormat), scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.secretAccessKey), signingTime.Format(sigV4DateFormat))
+	for _, value := range []string{s.region, s.service, sigV4ScopeSuffix} {
+		signingKey = hmacSHA256(signingKey, value)
+	}
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s", sigV4Algorithm, s.accessKeyID, scope, signedHeaders, signature))
+	return nil
+}
+
+func (s *sigV4Signer) canonicalURI(requestUrl *url.URL) string {
+	path := requestUrl.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+
+	if s.service == s3Service {
+		return path
+	}
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = sigV4Escape(segment)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// sigV4CanonicalQuery sorts the query params by name and value, escaping them with RFC 3986 rules.
+func sigV4CanonicalQuery(requestUrl *url.URL) string {
+	var params []string
+	for name, values := range requestUrl.Query() {
+		for _, value := range values {
+			params = append(params, sigV4Escape(name)+"="+sigV4Escape(value))
+		}
+	}
+	sort.Strings(params)
+
+	return strings.Join(params, "&")
+}
+
+// sigV4CanonicalHeaders returns the signed header names and the canonical headers block, including the host.
+func sigV4CanonicalHeaders(request *http.Request) (string, string) {
+	headers := map[string]string{"host": requestHost(request)}
+	for name, values := range request.Header {
+		if containsFold(sigV4IgnoredHeaders, name) {
+			continue
+		}
+
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonical strings.Builder
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	return strings.Join(names, ";"), canonical.String()
+}
+
+// requestHost returns the host the request is sent to, without the default port of its scheme.
+func requestHost(request *http.Request) string {
+	host := request.Host
+	if host == "" {
+		host = request.URL.Host
+	}
+
+	if (request.URL.Scheme == "https" && strings.HasSuffix(host, ":443")) || (request.URL.Scheme == "http" && strings.HasSuffix(host, ":80")) {
+		host = host[:strings.LastIndex(host, ":")]
+	}
+
+	return host
+}
+
+// sigV4Escape escapes everything but the RFC 3986 unreserved characters.
+func sigV4Escape(value string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(value) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') || b == '-' || b == '.' || b == '_' || b == '~' {
+			escaped.WriteByte(b)
+			continue
+		}
+		fmt.Fprintf(&escaped, "%%%02X", b)
+	}
+
+	return escaped.String()
+}
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,173 @@
+package plugin
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// the credentials and time of the AWS Signature Version 4 test suite.
+const (
+	sigV4TestAccessKey = "AKIDEXAMPLE"
+	sigV4TestSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	sigV4TestTime      = "20150830T123600Z"
+	sigV4TestScope     = "AKIDEXAMPLE/20150830/us-east-1/service/aws4_request"
+)
+
+type SigV4TestSuite struct {
+	suite.Suite
+}
+
+func (suite *SigV4TestSuite) TestVectors() {
+	signingTime, err := time.Parse(sigV4TimeFormat, sigV4TestTime)
+	require.NoError(suite.T(), err)
+
+	signer := newSigV4Signer(map[string]string{
+		consts.AwsAccessKeyIdKey:     sigV4TestAccessKey,
+		consts.AwsSecretAccessKeyKey: sigV4TestSecretKey,
+		consts.AwsRegionKey:          "us-east-1",
+		consts.AwsServiceKey:         "service",
+	})
+	require.NotNil(suite.T(), signer)
+
+	vectors := map[string]struct {
+		method        string
+		url           string
+		headers       map[string]string
+		body          string
+		signedHeaders string
+		signature     string
+	}{
+		"get-vanilla": {
+			method:        http.MethodGet,
+			url:           "https://example.amazonaws.com/",
+			signedHeaders: "host;x-amz-date",
+			signature:     "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		"get-vanilla-empty-query-key": {
+			method:        http.MethodGet,
+			url:           "https://example.amazonaws.com/?Param1=value1",
+			signedHeaders: "host;x-amz-date",
+			signature:     "a67d582fa61cc504c4bae71f336f98b97f1ea3c7a6bfe1b6e45aec72011b9aeb",
+		},
+		"get-vanilla-query-order-key-case": {
+			method:        http.MethodGet,
+			url:           "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			signedHeaders: "host;x-amz-date",
+			signature:     "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		"post-vanilla": {
+			method:        http.MethodPost,
+			url:           "https://example.amazonaws.com/",
+			signedHeaders: "host;x-amz-date",
+			signature:     "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		"post-vanilla-query": {
+			method:        http.MethodPost,
+			url:           "https://example.amazonaws.com/?Param1=value1",
+			signedHeaders: "host;x-amz-date",
+			signature:     "28038455d6de14eafc1f9222cf5aa6f1a96197d7deb8263271d420d138af7f11",
+		},
+		"post-x-www-form-urlencoded": {
+			method:        http.MethodPost,
+			url:           "https://example.amazonaws.com/",
+			headers:       map[string]string{consts.ContentTypeHeader: consts.URLEncoded},
+			body:          "Param1=value1",
+			signedHeaders: "content-type;host;x-amz-date",
+			signature:     "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+	}
+
+	for name, vector := range vectors {
+		request, err := http.NewRequest(vector.method, vector.url, strings.NewReader(vector.body))
+		require.NoError(suite.T(), err)
+		for header, value := range vector.headers {
+			request.Header.Set(header, value)
+		}
+
+		require.NoError(suite.T(), signer.sign(request, signingTime), name)
+		assert.Equal(suite.T(), sigV4TestTime, request.Header.Get(amzDateHeader), name)
+		assert.Equal(suite.T(),
+			"AWS4-HMAC-SHA256 Credential="+sigV4TestScope+", SignedHeaders="+vector.signedHeaders+", Signature="+vector.signature,
+			request.Header.Get("Authorization"), name)
+
+		// the body is still sent.
+		body, err := ioutil.ReadAll(request.Body)
+		require.NoError(suite.T(), err)
+		assert.Equal(suite.T(), vector.body, string(body), name)
+	}
+}
+
+func (suite *SigV4TestSuite) TestCanonicalRequest() {
+	signer := &sigV4Signer{service: "es"}
+	request, err := http.NewRequest(http.MethodGet, "https://search.example.com:443/my%20index/_search?q=a b&b=2&a=1&a=0", nil)
+	require.NoError(suite.T(), err)
+	request.Header.Set("X-Custom", "  a   b  ")
+	request.Header.Set("User-Agent", "blink")
+
+	// non S3 paths are escaped twice, the default port isn't signed.
+	assert.Equal(suite.T(), "/my%2520index/_search", signer.canonicalURI(request.URL))
+	assert.Equal(suite.T(), "a=0&a=1&b=2&q=a%20b", sigV4CanonicalQuery(request.URL))
+
+	signedHeaders, canonicalHeaders := sigV4CanonicalHeaders(request)
+	assert.Equal(suite.T(), "host;x-custom", signedHeaders)
+	assert.Equal(suite.T(), "host:search.example.com\nx-custom:a b\n", canonicalHeaders)
+
+	signer.service = s3Service
+	assert.Equal(suite.T(), "/my%20index/_search", signer.canonicalURI(request.URL))
+}
+
+func (suite *SigV4TestSuite) TestSignedRequests() {
+	var requests []*http.Request
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		requests = append(requests, req)
+		bodies = append(bodies, string(body))
+	}))
+	defer server.Close()
+
+	connection := map[string]string{
+		consts.AwsAccessKeyIdKey:     sigV4TestAccessKey,
+		consts.AwsSecretAccessKeyKey: sigV4TestSecretKey,
+		consts.AwsSessionTokenKey:    "session-token",
+		consts.AwsRegionKey:          "eu-west-1",
+		consts.AwsServiceKey:         s3Service,
+	}
+
+	request, err := http.NewRequest(http.MethodPut, server.URL+"/bucket/key", strings.NewReader(`{"name": "jane"}`))
+	require.NoError(suite.T(), err)
+	_, err = executeRequestWithCredentials(connection, request, requestOptions{timeout: 5})
+	require.NoError(suite.T(), err)
+
+	require.Len(suite.T(), requests, 1)
+	header := requests[0].Header
+	assert.True(suite.T(), strings.HasPrefix(header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/"))
+	assert.Contains(suite.T(), header.Get("Authorization"), "/eu-west-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-security-token,")
+	assert.Equal(suite.T(), "session-token", header.Get(amzSecurityTokenHeader))
+	assert.Equal(suite.T(), sha256Hex([]byte(`{"name": "jane"}`)), header.Get(amzContentSha256Header))
+	assert.Equal(suite.T(), `{"name": "jane"}`, bodies[0])
+
+	// the connection keys aren't sent as headers.
+	for _, key := range sigV4ConnectionKeys {
+		assert.Empty(suite.T(), header.Get(key), key)
+	}
+
+	delete(connection, consts.AwsRegionKey)
+	request, err = http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(suite.T(), err)
+	_, err = executeRequestWithCredentials(connection, request, requestOptions{timeout: 5})
+	assert.Error(suite.T(), err)
+}
+
+func TestSigV4Suite(t *testing.T) {
+	suite.Run(t, new(SigV4TestSuite))
+}
@@ -0,0 +1,108 @@
+package plugin
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	defaultDialTimeout         = 30 * time.Second
+	defaultKeepAlive           = 30 * time.Second
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 10
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+)
+
+// defaultClients is used by requests that aren't sent by a plugin, like ExecuteRequest.
+var defaultClients = newClientCache(TransportConfig{})
+
+// TransportConfig configures the HTTP transport shared by all the requests of a plugin.
+// zero values are replaced by defaults, the action timeout is applied per request.
+type TransportConfig struct {
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	IdleConnTimeout       time.Duration
+	KeepAlive             time.Duration
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration // no timeout by default
+	DisableHTTP2          bool
+	// Middleware wraps the transport, like the cassette recorder of tests.
+	// it runs once per connection transport, since connections with proxy or TLS settings get their own transport,
+	// so it must return an independent RoundTripper every time instead of replacing the transport of a previous call.
+	Middleware func(http.RoundTripper) http.RoundTripper
+}
+
+// newHTTPClient creates a client with a pooled transport, it is safe for concurrent use.
+func newHTTPClient(config TransportConfig) *http.Client {
+	return wrapTransport(config, newHTTPTransport(config))
+}
+
+func newHTTPTransport(config TransportConfig) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   durationOrDefault(config.DialTimeout, defaultDialTimeout),
+		KeepAlive: durationOrDefault(config.KeepAlive, defaultKeepAlive),
+	}
+
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     !config.DisableHTTP2,
+		MaxIdleConns:          intOrDefault(config.MaxIdleConns, defaultMaxIdleConns),
+		MaxIdleConnsPerHost:   intOrDefault(config.MaxIdleConnsPerHost, defaultMaxIdleConnsPerHost),
+		IdleConnTimeout:       durationOrDefault(config.IdleConnTimeout, defaultIdleConnTimeout),
+		TLSHandshakeTimeout:   durationOrDefault(config.TLSHandshakeTimeout, defaultTLSHandshakeTimeout),
+		ResponseHeaderTimeout: config.ResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	if config.DisableHTTP2 {
+		// a non nil empty map disables the automatic HTTP/2 upgrade.
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	return transport
+}
+
+func wrapTransport(config TransportConfig, transport *http.Transport) *http.Client {
+	if config.Middleware != nil {
+		return &http.Client{Transport: config.Middleware(transport)}
+	}
+
+	return &http.Client{Transport: transport}
+}
+
+// SecretHeaders returns the headers the connection's secrets are sent in, according to the header alias and prefix config.
+// cassettes scrub them, so recorded interactions can be committed.
+func (meta PluginMetadata) SecretHeaders() []string {
+	var headers []string
+	for header, alias := range meta.HeaderAlias {
+		headers = append(headers, header, alias)
+	}
+	for header := range meta.HeaderValuePrefixes {
+		headers = append(headers, header)
+	}
+
+	sort.Strings(headers)
+	return headers
+}
+
+func durationOrDefault(value time.Duration, defaultValue time.Duration) time.Duration {
+	if value > 0 {
+		return value
+	}
+
+	return defaultValue
+}
+
+func intOrDefault(value int, defaultValue int) int {
+	if value > 0 {
+		return value
+	}
+
+	return defaultValue
+}
@@ -0,0 +1,188 @@
+package plugin
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blinkops/blink-openapi-sdk/cassette"
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	plugin_sdk "github.com/blinkops/blink-sdk/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type TransportTestSuite struct {
+	suite.Suite
+}
+
+func newTLSTestServer(newConnections *int32) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "application/json")
+		_, _ = res.Write([]byte(`{"ok":true}`))
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(newConnections, 1)
+		}
+	}
+	server.StartTLS()
+
+	return server
+}
+
+// trustTestServer makes the client trust the self signed certificate of the test server.
+func trustTestServer(client *http.Client, server *httptest.Server) {
+	client.Transport.(*http.Transport).TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+}
+
+func (suite *TransportTestSuite) TestConnectionReuse() {
+	var newConnections int32
+	server := newTLSTestServer(&newConnections)
+	defer server.Close()
+
+	client := newHTTPClient(TransportConfig{})
+	trustTestServer(client, server)
+
+	for i := 0; i < 5; i++ {
+		request, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		require.NoError(suite.T(), err)
+
+		result, err := executeRequestWithCredentials(nil, request, requestOptions{client: client, timeout: 5})
+		require.NoError(suite.T(), err)
+		assert.Equal(suite.T(), http.StatusOK, result.StatusCode)
+	}
+
+	assert.Equal(suite.T(), int32(1), atomic.LoadInt32(&newConnections))
+}
+
+func (suite *TransportTestSuite) TestTimeoutDeadline() {
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		time.Sleep(2 * time.Second)
+	}))
+	defer server.Close()
+
+	request, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(suite.T(), err)
+
+	start := time.Now()
+	_, err = executeRequestWithCredentials(nil, request, requestOptions{timeout: 1})
+	require.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "context deadline exceeded")
+	assert.Less(suite.T(), time.Since(start), 2*time.Second)
+}
+
+func (suite *TransportTestSuite) TestDisableHTTP2() {
+	transport := newHTTPClient(TransportConfig{DisableHTTP2: true}).Transport.(*http.Transport)
+	assert.False(suite.T(), transport.ForceAttemptHTTP2)
+	assert.NotNil(suite.T(), transport.TLSNextProto)
+
+	transport = newHTTPClient(TransportConfig{MaxIdleConnsPerHost: 50}).Transport.(*http.Transport)
+	assert.True(suite.T(), transport.ForceAttemptHTTP2)
+	assert.Equal(suite.T(), 50, transport.MaxIdleConnsPerHost)
+	assert.Equal(suite.T(), defaultIdleConnTimeout, transport.IdleConnTimeout)
+}
+
+func (suite *TransportTestSuite) TestCassetteMiddleware() {
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set(consts.ContentTypeHeader, "text/plain")
+		_, _ = res.Write([]byte("recorded " + req.URL.Path))
+	}))
+
+	dir := suite.T().TempDir()
+	openApiFile := filepath.Join(dir, "exports-openapi.yaml")
+	cassetteFile := filepath.Join(dir, "exports.yaml")
+	require.NoError(suite.T(), ioutil.WriteFile(openApiFile, []byte(exportsOpenApi), 0600))
+
+	meta := PluginMetadata{Name: "exports", Provider: "exports", OpenApiFile: openApiFile, HeaderAlias: HeaderAlias{"TOKEN": "X-EXPORTS-TOKEN"}}
+	connection := map[string]string{consts.RequestUrlKey: server.URL, "TOKEN": "secret-token"}
+	request := &plugin_sdk.ExecuteActionRequest{Name: "GetExport", Parameters: map[string]string{"export": "report.txt"}}
+
+	execute := func(mode cassette.Mode) *plugin_sdk.ExecuteActionResponse {
+		recorder, err := cassette.New(cassetteFile, cassette.Options{Mode: mode, ScrubHeaders: meta.SecretHeaders()})
+		require.NoError(suite.T(), err)
+
+		meta.Transport.Middleware = recorder.Wrap
+		p, err := NewOpenApiPlugin(nil, meta, Callbacks{})
+		require.NoError(suite.T(), err)
+
+		return p.executeActionWithCredentials(connection, request)
+	}
+
+	res := execute(cassette.ModeRecord)
+	assert.Equal(suite.T(), "recorded /exports/report.txt", string(res.Result))
+	server.Close()
+
+	content, err := ioutil.ReadFile(cassetteFile)
+	require.NoError(suite.T(), err)
+	assert.NotContains(suite.T(), string(content), "secret-token")
+
+	res = execute(cassette.ModeReplay)
+	assert.Equal(suite.T(), int64(consts.OK), res.ErrorCode)
+	assert.Equal(suite.T(), "recorded /exports/report.txt", string(res.Result))
+}
+
+func (suite *TransportTestSuite) TestSecretHeaders() {
+	meta := PluginMetadata{
+		HeaderAlias:         HeaderAlias{"TOKEN": "AUTHORIZATION"},
+		HeaderValuePrefixes: HeaderValuePrefixes{"X-API-KEY": "Key "},
+	}
+
+	assert.Equal(suite.T(), []string{"AUTHORIZATION", "TOKEN", "X-API-KEY"}, meta.SecretHeaders())
+}
+
+func TestTransportSuite(t *testing.T) {
+	suite.Run(t, new(TransportTestSuite))
+}
+
+// BenchmarkSharedTransport sends the requests through the plugin's pooled transport,
+// connections and TLS sessions are reused between requests.
+func BenchmarkSharedTransport(b *testing.B) {
+	var newConnections int32
+	server := newTLSTestServer(&newConnections)
+	defer server.Close()
+
+	client := newHTTPClient(TransportConfig{})
+	trustTestServer(client, server)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		benchmarkRequest(b, server.URL, client)
+	}
+	b.ReportMetric(float64(atomic.LoadInt32(&newConnections)), "conns")
+}
+
+// BenchmarkClientPerRequest creates a new client for every request like the plugins used to,
+// every request opens a new connection and does a full TLS handshake.
+func BenchmarkClientPerRequest(b *testing.B) {
+	var newConnections int32
+	server := newTLSTestServer(&newConnections)
+	defer server.Close()
+
+	tlsConfig := server.Client().Transport.(*http.Transport).TLSClientConfig
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		transport := &http.Transport{TLSClientConfig: tlsConfig.Clone()}
+		benchmarkRequest(b, server.URL, &http.Client{Transport: transport})
+		transport.CloseIdleConnections()
+	}
+	b.ReportMetric(float64(atomic.LoadInt32(&newConnections)), "conns")
+}
+
+func benchmarkRequest(b *testing.B, requestUrl string, client *http.Client) {
+	request, err := http.NewRequest(http.MethodGet, requestUrl, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	if _, err = executeRequestWithCredentials(nil, request, requestOptions{client: client, timeout: 5}); err != nil {
+		b.Fatal(err)
+	}
+}
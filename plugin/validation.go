@@ -0,0 +1,290 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	"github.com/blinkops/blink-openapi-sdk/plugin/handlers"
+	"github.com/blinkops/blink-sdk/plugin"
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+var uuidRE = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// ParametersError lists every invalid parameter of an action request.
+type ParametersError struct {
+	Problems []string
+}
+
+func (e *ParametersError) Error() string {
+	return "Invalid action parameters:\n" + strings.Join(e.Problems, "\n")
+}
+
+// validateParameters checks the request parameters against the openapi schema of the action,
+// so invalid requests fail before they're sent with an error listing all the invalid parameters.
+func (p *openApiPlugin) validateParameters(request *plugin.ExecuteActionRequest) error {
+	action := p.getAction(request.Name)
+	actionName := p.mask.ReplaceActionAlias(request.Name)
+	operation := p.operations.Get(actionName)
+
+	// unknown actions are reported when the request is parsed.
+	if action == nil || operation == nil {
+		return nil
+	}
+
+	rawParameters, err := request.GetParameters()
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+
+	requiredParams := make([]string, 0, len(action.Parameters))
+	for paramName, param := range action.Parameters {
+		if param.Required {
+			requiredParams = append(requiredParams, paramName)
+		}
+	}
+	sort.Strings(requiredParams)
+
+	for _, paramName := range requiredParams {
+		if !hasParameterValue(rawParameters, paramName, p.mask.ReplaceActionParameterAlias(actionName, paramName)) {
+			problems = append(problems, fmt.Sprintf("%s: required parameter is missing", paramName))
+		}
+	}
+
+	rawNames := make([]string, 0, len(rawParameters))
+	for rawName := range rawParameters {
+		rawNames = append(rawNames, rawName)
+	}
+	sort.Strings(rawNames)
+
+	for _, rawName := range rawNames {
+		paramName := p.mask.ReplaceActionParameterAlias(actionName, rawName)
+		paramSchema, ok := getParameterSchema(operation, paramName)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: unknown parameter", rawName))
+			continue
+		}
+
+		value := rawParameters[rawName]
+		if paramSchema == nil || value == "" {
+			continue
+		}
+
+		// values of masked types, like date_epoch, aren't in the format of the schema.
+		maskedParam := p.mask.GetParameter(actionName, paramName)
+		checkFormat := maskedParam == nil || maskedParam.Type == ""
+
+		if maskedParam != nil && maskedParam.IsMulti && paramSchema.Type != consts.TypeArray {
+			for _, item := range strings.Split(value, consts.ArrayDelimiter) {
+				problems = append(problems, validateValue(rawName, item, paramSchema, checkFormat)...)
+			}
+			continue
+		}
+
+		problems = append(problems, validateValue(rawName, value, paramSchema, checkFormat)...)
+	}
+
+	if len(problems) > 0 {
+		return &ParametersError{Problems: problems}
+	}
+
+	return nil
+}
+
+func (p *openApiPlugin) getAction(actionName string) *plugin.Action {
+	for i := range p.actions {
+		if p.actions[i].Name == actionName {
+			return &p.actions[i]
+		}
+	}
+
+	return nil
+}
+
+// hasParameterValue looks for a non empty parameter by its alias or original name, names are compared case insensitive like path params.
+func hasParameterValue(rawParameters map[string]string, names ...string) bool {
+	for rawName, value := range rawParameters {
+		for _, name := range names {
+			if strings.EqualFold(rawName, name) && value != "" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// getParameterSchema returns the schema of a path/query/header/cookie param or of a "." delimited body param.
+// the schema is nil for known params that don't have one, like properties of a free form object.
+func getParameterSchema(operation *handlers.OperationDefinition, paramName string) (*openapi3.Schema, bool) {
+	for _, param := range operation.AllParams() {
+		if param.ParamName == paramName || (param.In == "path" && strings.EqualFold(param.ParamName, paramName)) {
+			if param.Spec == nil || param.Spec.Schema == nil {
+				return nil, true
+			}
+			return param.Spec.Schema.Value, true
+		}
+	}
+
+	defaultBody := operation.GetDefaultBody()
+	if operation.Method == http.MethodGet || defaultBody == nil {
+		return nil, false
+	}
+
+	if defaultBody.IsRaw() {
+		return nil, paramName == consts.RawBodyParam
+	}
+
+	if defaultBody.Schema.OApiSchema == nil {
+		return nil, false
+	}
+
+	propertySchema := defaultBody.Schema.OApiSchema
+	for _, key := range strings.Split(paramName, consts.BodyParamDelimiter) {
+		subPropertySchema := handlers.GetPropertyByName(key, propertySchema)
+		if subPropertySchema == nil {
+			if allowsAdditionalProperties(propertySchema) {
+				return nil, true
+			}
+			return nil, false
+		}
+		propertySchema = subPropertySchema
+	}
+
+	return propertySchema, true
+}
+
+func allowsAdditionalProperties(schema *openapi3.Schema) bool {
+	return schema.AdditionalProperties != nil || (schema.AdditionalPropertiesAllowed != nil && *schema.AdditionalPropertiesAllowed)
+}
+
+// validateValue validates a raw parameter value and returns a problem for every constraint it doesn't satisfy.
+func validateValue(name string, value string, schema *openapi3.Schema, checkFormat bool) []string {
+	var problems []string
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, name+": "+fmt.Sprintf(format, args...))
+	}
+
+	switch schema.Type {
+	case consts.TypeArray:
+		if schema.Items == nil || schema.Items.Value == nil {
+			return nil
+		}
+		for _, item := range strings.Split(value, consts.ArrayDelimiter) {
+			problems = append(problems, validateValue(name, item, schema.Items.Value, checkFormat)...)
+		}
+		return problems
+	case consts.TypeObject:
+		var jsonValue map[string]interface{}
+		if err := json.Unmarshal([]byte(value), &jsonValue); err != nil {
+			fail("must be a JSON object")
+		}
+		return problems
+	case consts.TypeBoolean:
+		if _, err := strconv.ParseBool(value); err != nil {
+			fail("must be true or false")
+		}
+	case consts.TypeInteger, "number":
+		number, err := strconv.ParseFloat(value, 64)
+		if schema.Type == consts.TypeInteger {
+			_, err = strconv.ParseInt(value, 10, 64)
+		}
+		if err != nil {
+			fail("must be a valid %s", schema.Type)
+			return problems
+		}
+
+		if schema.Min != nil && (number < *schema.Min || (schema.ExclusiveMin && number == *schema.Min)) {
+			fail("must be %s %g", comparison("greater than", schema.ExclusiveMin), *schema.Min)
+		}
+		if schema.Max != nil && (number > *schema.Max || (schema.ExclusiveMax && number == *schema.Max)) {
+			fail("must be %s %g", comparison("less than", schema.ExclusiveMax), *schema.Max)
+		}
+	default:
+		length := uint64(utf8.RuneCountInString(value))
+		if length < schema.MinLength {
+			fail("must be at least %d characters long", schema.MinLength)
+		}
+		if schema.MaxLength != nil && length > *schema.MaxLength {
+			fail("must be at most %d characters long", *schema.MaxLength)
+		}
+
+		if schema.Pattern != "" {
+			if pattern, err := regexp.Compile(schema.Pattern); err == nil && !pattern.MatchString(value) {
+				fail("must match the pattern %s", schema.Pattern)
+			}
+		}
+
+		if checkFormat && !isValidFormat(schema.Format, value) {
+			fail("must be a valid %s", schema.Format)
+		}
+	}
+
+	if len(schema.Enum) > 0 {
+		options := make([]string, 0, len(schema.Enum))
+		found := false
+		for _, option := range schema.Enum {
+			optionValue := fmt.Sprintf("%v", option)
+			options = append(options, optionValue)
+			if optionValue == value {
+				found = true
+			}
+		}
+
+		if !found {
+			fail("must be one of %s", strings.Join(options, ", "))
+		}
+	}
+
+	return problems
+}
+
+func comparison(operator string, exclusive bool) string {
+	if exclusive {
+		return operator
+	}
+
+	return operator + " or equal to"
+}
+
+// isValidFormat validates the common string formats, unknown formats are considered valid.
+func isValidFormat(format string, value string) bool {
+	var err error
+
+	switch format {
+	case "date":
+		_, err = time.Parse("2006-01-02", value)
+	case "date-time":
+		_, err = time.Parse(time.RFC3339, value)
+	case "email":
+		_, err = mail.ParseAddress(value)
+	case "uri", "url":
+		var parsedUrl *url.URL
+		if parsedUrl, err = url.ParseRequestURI(value); err == nil && parsedUrl.Scheme == "" {
+			return false
+		}
+	case "uuid":
+		return uuidRE.MatchString(value)
+	case "ipv4":
+		ip := net.ParseIP(value)
+		return ip != nil && ip.To4() != nil
+	case "ipv6":
+		ip := net.ParseIP(value)
+		return ip != nil && ip.To4() == nil
+	}
+
+	return err == nil
+}
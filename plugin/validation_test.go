@@ -0,0 +1,237 @@
+package plugin
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	plugin_sdk "github.com/blinkops/blink-sdk/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	membersOpenApi = `
+openapi: 3.0.0
+info:
+  title: members
+  version: 1.0.0
+servers:
+  - url: https://api.example.com
+paths:
+  /teams/{teamId}/members:
+    post:
+      operationId: AddMember
+      parameters:
+        - name: teamId
+          in: path
+          required: true
+          schema:
+            type: integer
+            minimum: 1
+        - name: role
+          in: query
+          schema:
+            type: string
+            enum: [admin, member]
+        - name: X-Request-Id
+          in: header
+          schema:
+            type: string
+            format: uuid
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              type: object
+              required: [email]
+              properties:
+                email:
+                  type: string
+                  format: email
+                name:
+                  type: string
+                  minLength: 2
+                  maxLength: 10
+                code:
+                  type: string
+                  pattern: ^[A-Z]{3}$
+                active:
+                  type: boolean
+                tags:
+                  type: array
+                  items:
+                    type: string
+                    enum: [a, b]
+                settings:
+                  type: object
+                  properties:
+                    level:
+                      type: integer
+                      maximum: 5
+                      exclusiveMaximum: true
+                metadata:
+                  type: object
+                  additionalProperties: true
+      responses:
+        "200":
+          description: member
+`
+
+	membersMask = `
+actions:
+  AddMember:
+    alias: Add Member
+    parameters:
+      teamId:
+        alias: Team ID
+      role:
+        alias: Role
+      X-Request-Id:
+        alias: Request ID
+      email:
+        alias: Email
+      name:
+        alias: Name
+      code:
+        alias: Code
+      active:
+        alias: Active
+      tags:
+        alias: Tags
+      settings.level:
+        alias: Level
+      metadata:
+        alias: Metadata
+`
+)
+
+type ValidationTestSuite struct {
+	suite.Suite
+	plugin *openApiPlugin
+}
+
+func (suite *ValidationTestSuite) SetupSuite() {
+	dir := suite.T().TempDir()
+	openApiFile := filepath.Join(dir, "members-openapi.yaml")
+	maskFile := filepath.Join(dir, "members-mask.yaml")
+	require.NoError(suite.T(), ioutil.WriteFile(openApiFile, []byte(membersOpenApi), 0600))
+	require.NoError(suite.T(), ioutil.WriteFile(maskFile, []byte(membersMask), 0600))
+
+	var err error
+	suite.plugin, err = NewOpenApiPlugin(nil, PluginMetadata{Name: "members", Provider: "members", OpenApiFile: openApiFile, MaskFile: maskFile}, Callbacks{})
+	require.NoError(suite.T(), err)
+}
+
+func validMemberParameters() map[string]string {
+	return map[string]string{
+		"Team ID":    "3",
+		"Role":       "admin",
+		"Request ID": "0b8c5a3e-4a8f-4d6e-9b1a-2f3c4d5e6f70",
+		"Email":      "jane@example.com",
+		"Name":       "Jane",
+		"Code":       "ABC",
+		"Active":     "true",
+		"Tags":       "a,b",
+		"Level":      "4",
+		"Metadata":   `{"source": "test"}`,
+	}
+}
+
+func (suite *ValidationTestSuite) validate(overrides map[string]string, removed ...string) error {
+	parameters := validMemberParameters()
+	for name, value := range overrides {
+		parameters[name] = value
+	}
+	for _, name := range removed {
+		delete(parameters, name)
+	}
+
+	return suite.plugin.validateParameters(&plugin_sdk.ExecuteActionRequest{Name: "Add Member", Parameters: parameters})
+}
+
+func (suite *ValidationTestSuite) TestValidParameters() {
+	assert.NoError(suite.T(), suite.validate(nil))
+	assert.NoError(suite.T(), suite.validate(map[string]string{"Role": ""}), "empty optional parameters are not validated")
+	assert.NoError(suite.T(), suite.validate(map[string]string{"metadata.owner": "jane"}), "free form objects accept any property")
+	assert.NoError(suite.T(), suite.validate(map[string]string{"settings": `{"level": 1}`}, "Level"), "object parameters can be passed as JSON")
+	assert.NoError(suite.T(), suite.validate(map[string]string{"teamId": "3"}, "Team ID"), "original names are accepted")
+}
+
+func (suite *ValidationTestSuite) TestInvalidParameters() {
+	cases := []struct {
+		name       string
+		parameters map[string]string
+		removed    []string
+		problem    string
+	}{
+		{name: "required", removed: []string{"Email"}, problem: "Email: required parameter is missing"},
+		{name: "required empty", parameters: map[string]string{"Team ID": ""}, problem: "Team ID: required parameter is missing"},
+		{name: "unknown", parameters: map[string]string{"Nickname": "jj"}, problem: "Nickname: unknown parameter"},
+		{name: "unknown nested", parameters: map[string]string{"settings.color": "red"}, problem: "settings.color: unknown parameter"},
+		{name: "enum", parameters: map[string]string{"Role": "owner"}, problem: "Role: must be one of admin, member"},
+		{name: "array enum", parameters: map[string]string{"Tags": "a,c"}, problem: "Tags: must be one of a, b"},
+		{name: "pattern", parameters: map[string]string{"Code": "abc"}, problem: "Code: must match the pattern ^[A-Z]{3}$"},
+		{name: "minimum", parameters: map[string]string{"Team ID": "0"}, problem: "Team ID: must be greater than or equal to 1"},
+		{name: "exclusive maximum", parameters: map[string]string{"Level": "5"}, problem: "Level: must be less than 5"},
+		{name: "min length", parameters: map[string]string{"Name": "J"}, problem: "Name: must be at least 2 characters long"},
+		{name: "max length", parameters: map[string]string{"Name": "Janet Jackson"}, problem: "Name: must be at most 10 characters long"},
+		{name: "email format", parameters: map[string]string{"Email": "jane"}, problem: "Email: must be a valid email"},
+		{name: "uuid format", parameters: map[string]string{"Request ID": "1234"}, problem: "Request ID: must be a valid uuid"},
+		{name: "integer", parameters: map[string]string{"Team ID": "3.5"}, problem: "Team ID: must be a valid integer"},
+		{name: "boolean", parameters: map[string]string{"Active": "yes"}, problem: "Active: must be true or false"},
+		{name: "object", parameters: map[string]string{"Metadata": "source"}, problem: "Metadata: must be a JSON object"},
+	}
+
+	for _, c := range cases {
+		err := suite.validate(c.parameters, c.removed...)
+		if assert.Error(suite.T(), err, c.name) {
+			assert.Equal(suite.T(), "Invalid action parameters:\n"+c.problem, err.Error(), c.name)
+		}
+	}
+}
+
+func (suite *ValidationTestSuite) TestAggregatedError() {
+	err := suite.validate(map[string]string{"Role": "owner", "Active": "yes", "Nickname": "jj"}, "Email")
+
+	assert.EqualError(suite.T(), err, "Invalid action parameters:\n"+
+		"Email: required parameter is missing\n"+
+		"Active: must be true or false\n"+
+		"Nickname: unknown parameter\n"+
+		"Role: must be one of admin, member")
+}
+
+func (suite *ValidationTestSuite) TestInvalidRequestIsNotSent() {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer server.Close()
+
+	parameters := validMemberParameters()
+	parameters["Role"] = "owner"
+
+	res := suite.plugin.executeActionWithCredentials(map[string]string{consts.RequestUrlKey: server.URL}, &plugin_sdk.ExecuteActionRequest{Name: "Add Member", Parameters: parameters})
+
+	assert.Equal(suite.T(), int64(consts.Error), res.ErrorCode)
+	actionErr := decodeActionError(suite.T(), res.Result)
+	assert.Equal(suite.T(), ErrorCategoryValidation, actionErr.Category)
+	assert.Equal(suite.T(), "Invalid action parameters:\nRole: must be one of admin, member", actionErr.Message)
+	assert.Equal(suite.T(), []string{"Role: must be one of admin, member"}, actionErr.Details)
+	assert.Equal(suite.T(), int32(0), atomic.LoadInt32(&requests))
+
+	res = suite.plugin.executeActionWithCredentials(map[string]string{consts.RequestUrlKey: server.URL}, &plugin_sdk.ExecuteActionRequest{Name: "Add Member", Parameters: validMemberParameters()})
+
+	assert.Equal(suite.T(), int64(consts.OK), res.ErrorCode)
+	assert.Equal(suite.T(), int32(1), atomic.LoadInt32(&requests))
+}
+
+func TestValidationSuite(t *testing.T) {
+	suite.Run(t, new(ValidationTestSuite))
+}
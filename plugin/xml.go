@@ -0,0 +1,289 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"sort"
+	"strings"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	"github.com/blinkops/blink-openapi-sdk/plugin/handlers"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultXMLRootName = "root"
+	xmlAttributePrefix = "@"     // prefix of the JSON keys of converted XML attributes
+	xmlTextKey         = "#text" // JSON key of the text of converted XML elements that also have attributes or children
+)
+
+// isXMLContentType returns whether the content type is application/xml, text/xml or a +xml type.
+func isXMLContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml")
+}
+
+// xmlRootName returns the root element name of an XML body, its xml name or the name of its schema component.
+func xmlRootName(operation *handlers.OperationDefinition, defaultBody *handlers.RequestBodyDefinition) string {
+	if bodySchema := defaultBody.Schema.OApiSchema; bodySchema != nil && bodySchema.XML != nil && bodySchema.XML.Name != "" {
+		return bodySchema.XML.Name
+	}
+
+	if operation.Spec != nil && operation.Spec.RequestBody != nil && operation.Spec.RequestBody.Value != nil {
+		if mediaType := operation.Spec.RequestBody.Value.Content.Get(defaultBody.ContentType); mediaType != nil && mediaType.Schema != nil && mediaType.Schema.Ref != "" {
+			return mediaType.Schema.Ref[strings.LastIndex(mediaType.Schema.Ref, "/")+1:]
+		}
+	}
+
+	return defaultXMLRootName
+}
+
+// encodeXMLBody serializes the body params as XML, following the xml objects (name, prefix, namespace, attribute and wrapped) of the schema.
+func encodeXMLBody(rootName string, requestBody map[string]interface{}, bodySchema *openapi3.Schema) ([]byte, error) {
+	body := bytes.NewBufferString(xml.Header)
+	encoder := xml.NewEncoder(body)
+
+	if err := writeXMLElement(encoder, rootName, requestBody, bodySchema); err != nil {
+		return nil, err
+	}
+
+	if err := encoder.Flush(); err != nil {
+		return nil, err
+	}
+
+	return body.Bytes(), nil
+}
+
+func writeXMLElement(encoder *xml.Encoder, name string, value interface{}, elementSchema *openapi3.Schema) error {
+	start := xml.StartElement{Name: xmlName(name, elementSchema)}
+	if elementSchema != nil && elementSchema.XML != nil && elementSchema.XML.Namespace != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xmlNamespaceName(elementSchema.XML.Prefix), Value: elementSchema.XML.Namespace})
+	}
+
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		var children []string
+		for _, key := range sortedMapKeys(typedValue) {
+			propertySchema := getXMLPropertySchema(key, elementSchema)
+			if propertySchema != nil && propertySchema.XML != nil && propertySchema.XML.Attribute {
+				start.Attr = append(start.Attr, xml.Attr{Name: xmlName(key, propertySchema), Value: fmt.Sprintf("%v", typedValue[key])})
+				continue
+			}
+			children = append(children, key)
+		}
+
+		if err := encoder.EncodeToken(start); err != nil {
+			return err
+		}
+
+		for _, key := range children {
+			if err := writeXMLProperty(encoder, key, typedValue[key], getXMLPropertySchema(key, elementSchema)); err != nil {
+				return err
+			}
+		}
+	default:
+		if err := encoder.EncodeToken(start); err != nil {
+			return err
+		}
+
+		if err := encoder.EncodeToken(xml.CharData(fmt.Sprintf("%v", typedValue))); err != nil {
+			return err
+		}
+	}
+
+	return encoder.EncodeToken(start.End())
+}
+
+// writeXMLProperty writes a property of an object, array items are repeated elements named after the property,
+// unless the items have their own xml name, and they're wrapped only when the schema says so.
+func writeXMLProperty(encoder *xml.Encoder, name string, value interface{}, propertySchema *openapi3.Schema) error {
+	items := toInterfaceSlice(value)
+	if items == nil {
+		return writeXMLElement(encoder, name, value, propertySchema)
+	}
+
+	var itemsSchema *openapi3.Schema
+	if propertySchema != nil && propertySchema.Items != nil {
+		itemsSchema = propertySchema.Items.Value
+	}
+
+	wrapped := propertySchema != nil && propertySchema.XML != nil && propertySchema.XML.Wrapped
+	wrapper := xml.StartElement{Name: xmlName(name, propertySchema)}
+	if wrapped {
+		if err := encoder.EncodeToken(wrapper); err != nil {
+			return err
+		}
+	}
+
+	for _, item := range items {
+		if err := writeXMLElement(encoder, name, item, itemsSchema); err != nil {
+			return err
+		}
+	}
+
+	if wrapped {
+		return encoder.EncodeToken(wrapper.End())
+	}
+
+	return nil
+}
+
+func getXMLPropertySchema(name string, objectSchema *openapi3.Schema) *openapi3.Schema {
+	if objectSchema == nil {
+		return nil
+	}
+
+	return handlers.GetPropertyByName(name, objectSchema)
+}
+
+func xmlName(name string, elementSchema *openapi3.Schema) xml.Name {
+	if elementSchema == nil || elementSchema.XML == nil {
+		return xml.Name{Local: name}
+	}
+
+	if elementSchema.XML.Name != "" {
+		name = elementSchema.XML.Name
+	}
+
+	if elementSchema.XML.Prefix != "" {
+		name = elementSchema.XML.Prefix + ":" + name
+	}
+
+	return xml.Name{Local: name}
+}
+
+func xmlNamespaceName(prefix string) xml.Name {
+	if prefix == "" {
+		return xml.Name{Local: "xmlns"}
+	}
+
+	return xml.Name{Local: "xmlns:" + prefix}
+}
+
+func toInterfaceSlice(value interface{}) []interface{} {
+	switch typedValue := value.(type) {
+	case []interface{}:
+		return typedValue
+	case []string:
+		items := make([]interface{}, len(typedValue))
+		for i, item := range typedValue {
+			items[i] = item
+		}
+		return items
+	default:
+		return nil
+	}
+}
+
+func sortedMapKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// convertXMLResult replaces an XML response body with its JSON representation.
+// the body is left as is when it can't be parsed.
+func convertXMLResult(result *Result) {
+	if len(result.Body) == 0 || !isXMLContentType(result.Header.Get(consts.ContentTypeHeader)) {
+		return
+	}
+
+	jsonBody, err := xmlToJSON(result.Body)
+	if err != nil {
+		log.Warnf("Failed to convert the XML response to JSON: %v", err)
+		return
+	}
+
+	result.Body = jsonBody
+	result.Header = result.Header.Clone()
+	result.Header.Set(consts.ContentTypeHeader, consts.RequestBodyType)
+}
+
+// xmlToJSON converts an XML document to JSON keyed by the root element name.
+// attributes are prefixed with @, repeated elements become arrays and the text of elements
+// that also have attributes or children is kept under #text.
+func xmlToJSON(body []byte) ([]byte, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, errors.Wrap(err, "no root element found")
+		}
+
+		if start, ok := token.(xml.StartElement); ok {
+			value, err := decodeXMLElement(decoder, start)
+			if err != nil {
+				return nil, err
+			}
+
+			return json.Marshal(map[string]interface{}{start.Name.Local: value})
+		}
+	}
+}
+
+func decodeXMLElement(decoder *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	element := map[string]interface{}{}
+	for _, attr := range start.Attr {
+		// namespace declarations aren't data.
+		if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" {
+			continue
+		}
+		element[xmlAttributePrefix+attr.Name.Local] = attr.Value
+	}
+
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch typedToken := token.(type) {
+		case xml.StartElement:
+			child, err := decodeXMLElement(decoder, typedToken)
+			if err != nil {
+				return nil, err
+			}
+			addXMLChild(element, typedToken.Name.Local, child)
+		case xml.CharData:
+			text.Write(typedToken)
+		case xml.EndElement:
+			trimmedText := strings.TrimSpace(text.String())
+			if len(element) == 0 {
+				return trimmedText, nil
+			}
+			if trimmedText != "" {
+				element[xmlTextKey] = trimmedText
+			}
+			return element, nil
+		}
+	}
+}
+
+func addXMLChild(element map[string]interface{}, name string, child interface{}) {
+	existing, ok := element[name]
+	if !ok {
+		element[name] = child
+		return
+	}
+
+	if items, ok := existing.([]interface{}); ok {
+		element[name] = append(items, child)
+		return
+	}
+
+	element[name] = []interface{}{existing, child}
+}
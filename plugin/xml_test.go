@@ -0,0 +1,195 @@
+package plugin
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/blinkops/blink-openapi-sdk/consts"
+	plugin_sdk "github.com/blinkops/blink-sdk/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	petsOpenApi = `
+openapi: 3.0.0
+info:
+  title: pets
+  version: 1.0.0
+servers:
+  - url: https://api.example.com
+paths:
+  /pets:
+    post:
+      operationId: AddPet
+      requestBody:
+        content:
+          application/xml:
+            schema:
+              $ref: "#/components/schemas/Pet"
+      responses:
+        "200":
+          description: pet
+  /owners:
+    post:
+      operationId: AddOwner
+      requestBody:
+        content:
+          text/xml:
+            schema:
+              type: object
+              properties:
+                name:
+                  type: string
+      responses:
+        "200":
+          description: owner
+components:
+  schemas:
+    Pet:
+      type: object
+      xml:
+        name: pet
+        prefix: p
+        namespace: https://example.com/schema/pet
+      properties:
+        id:
+          type: integer
+          xml:
+            attribute: true
+        name:
+          type: string
+          xml:
+            name: petName
+        photoUrls:
+          type: array
+          xml:
+            wrapped: true
+            name: photos
+          items:
+            type: string
+            xml:
+              name: photo
+        tags:
+          type: array
+          items:
+            type: string
+        category:
+          type: object
+          properties:
+            name:
+              type: string
+`
+
+	petXMLResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<pet xmlns="https://example.com/schema/pet" id="7">
+  <name>Rex</name>
+  <tag>good</tag>
+  <tag>dog</tag>
+  <owner><name>Jane</name></owner>
+  <weight unit="kg">12</weight>
+</pet>`
+)
+
+type XMLTestSuite struct {
+	suite.Suite
+	openApiFile string
+}
+
+func (suite *XMLTestSuite) SetupSuite() {
+	suite.openApiFile = filepath.Join(suite.T().TempDir(), "pets-openapi.yaml")
+	require.NoError(suite.T(), ioutil.WriteFile(suite.openApiFile, []byte(petsOpenApi), 0600))
+}
+
+func (suite *XMLTestSuite) newPlugin(convertXMLResponses bool) *openApiPlugin {
+	p, err := NewOpenApiPlugin(nil, PluginMetadata{Name: "pets", Provider: "pets", OpenApiFile: suite.openApiFile, ConvertXMLResponses: convertXMLResponses}, Callbacks{})
+	require.NoError(suite.T(), err)
+	return p
+}
+
+func (suite *XMLTestSuite) TestXMLRequestBody() {
+	p := suite.newPlugin(false)
+
+	request, err := p.parseActionRequest(&plugin_sdk.ExecuteActionRequest{Name: "AddPet", Parameters: map[string]string{
+		"id":            "7",
+		"name":          "Rex & Co",
+		"photoUrls":     "a.png,b.png",
+		"tags":          "good,dog",
+		"category.name": "dogs",
+	}}, p.requestUrl)
+	require.NoError(suite.T(), err)
+
+	assert.Equal(suite.T(), "application/xml", request.Header.Get(consts.ContentTypeHeader))
+
+	body, err := ioutil.ReadAll(request.Body)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), xml.Header+
+		`<p:pet xmlns:p="https://example.com/schema/pet" id="7">`+
+		`<category><name>dogs</name></category>`+
+		`<petName>Rex &amp; Co</petName>`+
+		`<photos><photo>a.png</photo><photo>b.png</photo></photos>`+
+		`<tags>good</tags><tags>dog</tags>`+
+		`</p:pet>`, string(body))
+}
+
+func (suite *XMLTestSuite) TestXMLRootName() {
+	p := suite.newPlugin(false)
+
+	request, err := p.parseActionRequest(&plugin_sdk.ExecuteActionRequest{Name: "AddOwner", Parameters: map[string]string{"name": "Jane"}}, p.requestUrl)
+	require.NoError(suite.T(), err)
+
+	body, err := ioutil.ReadAll(request.Body)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), xml.Header+`<root><name>Jane</name></root>`, string(body))
+}
+
+func (suite *XMLTestSuite) TestXMLToJSON() {
+	jsonBody, err := xmlToJSON([]byte(petXMLResponse))
+	require.NoError(suite.T(), err)
+
+	assert.JSONEq(suite.T(), `{"pet": {
+		"@id": "7",
+		"name": "Rex",
+		"tag": ["good", "dog"],
+		"owner": {"name": "Jane"},
+		"weight": {"@unit": "kg", "#text": "12"}
+	}}`, string(jsonBody))
+
+	_, err = xmlToJSON([]byte("not xml"))
+	assert.Error(suite.T(), err)
+}
+
+func (suite *XMLTestSuite) TestConvertXMLResponses() {
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set(consts.ContentTypeHeader, "application/xml; charset=utf-8")
+		_, _ = res.Write([]byte(petXMLResponse))
+	}))
+	defer server.Close()
+
+	request := &plugin_sdk.ExecuteActionRequest{Name: "AddOwner", Parameters: map[string]string{"name": "Jane"}}
+	connection := map[string]string{consts.RequestUrlKey: server.URL}
+
+	res := suite.newPlugin(true).executeActionWithCredentials(connection, request)
+	assert.Equal(suite.T(), int64(consts.OK), res.ErrorCode)
+	assert.JSONEq(suite.T(), `{"pet": {"@id": "7", "name": "Rex", "tag": ["good", "dog"], "owner": {"name": "Jane"}, "weight": {"@unit": "kg", "#text": "12"}}}`, string(res.Result))
+
+	res = suite.newPlugin(false).executeActionWithCredentials(connection, request)
+	assert.Equal(suite.T(), petXMLResponse, string(res.Result))
+}
+
+func (suite *XMLTestSuite) TestIsXMLContentType() {
+	assert.True(suite.T(), isXMLContentType("application/xml"))
+	assert.True(suite.T(), isXMLContentType("text/xml; charset=utf-8"))
+	assert.True(suite.T(), isXMLContentType("application/atom+xml"))
+	assert.False(suite.T(), isXMLContentType("application/json"))
+	assert.False(suite.T(), isXMLContentType(""))
+}
+
+func TestXMLSuite(t *testing.T) {
+	suite.Run(t, new(XMLTestSuite))
+}